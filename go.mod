@@ -1,12 +1,13 @@
 module github.com/yituoshiniao/kit
 
-go 1.13
+go 1.16
 
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/HdrHistogram/hdrhistogram-go v1.1.2 // indirect
 	github.com/VividCortex/gohistogram v1.0.0 // indirect
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/certifi/gocertifi v0.0.0-20210507211836-431795d63e8d // indirect
 	github.com/dghubble/sling v1.3.0
 	github.com/dlmiddlecote/sqlstats v1.0.2
@@ -15,6 +16,7 @@ require (
 	github.com/go-redis/redis v6.15.9+incompatible
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/google/go-querystring v1.1.0 // indirect
+	github.com/gorilla/websocket v1.5.0
 	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0
 	github.com/hibiken/asynq v0.24.1
 	github.com/imdario/mergo v0.3.11 // indirect
@@ -40,6 +42,7 @@ require (
 	golang.org/x/net v0.0.0-20211008194852-3b03d305991f
 	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/grpc v1.44.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.4.0
 	gorm.io/driver/mysql v1.3.3
@@ -0,0 +1,16 @@
+package v1
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/yituoshiniao/kit/xhealth"
+)
+
+// HealthCheck 数据库连接检查，用于 xhealth.Registry 注册
+func HealthCheck(db *gorm.DB) xhealth.CheckFunc {
+	return func(ctx context.Context) error {
+		return db.DB().PingContext(ctx)
+	}
+}
@@ -0,0 +1,20 @@
+package v2
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"github.com/yituoshiniao/kit/xhealth"
+)
+
+// HealthCheck 数据库连接检查，用于 xhealth.Registry 注册
+func HealthCheck(db *gorm.DB) xhealth.CheckFunc {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+}
@@ -0,0 +1,320 @@
+// Package xhealth 健康检查注册表，组件注册检查项，hserver 通过 /livez、/readyz 输出检查结果
+package xhealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// 检查结果状态
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDegraded = "degraded"
+	StatusDraining = "draining"
+)
+
+// CheckFunc 检查依赖是否可用，需要在 ctx 超时后尽快返回
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name     string
+	fn       CheckFunc
+	timeout  time.Duration
+	critical bool
+	liveness bool
+
+	// 保证同一时间只有一个检查在执行
+	run    sync.Mutex
+	mu     sync.Mutex
+	result Result
+}
+
+// Result 单个检查项的结果
+type Result struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	// 可能包含 DSN、地址等信息，LiveHandler、ReadyHandler 不输出，通过 DetailHandler 查看
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"durationMs"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// Report 整体的检查结果
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// withoutErrors 去掉检查项的错误详情，用于无需认证的探针接口
+func (rep Report) withoutErrors() Report {
+	checks := make(map[string]Result, len(rep.Checks))
+	for name, res := range rep.Checks {
+		res.Error = ""
+		checks[name] = res
+	}
+	rep.Checks = checks
+	return rep
+}
+
+// Registry 检查项注册表
+type Registry struct {
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	mu       sync.RWMutex
+	checks   []*check
+	draining bool
+}
+
+type Option func(*Registry)
+
+// WithCacheTTL 检查结果的缓存时间，避免探针频繁访问依赖，默认 5 秒
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(r *Registry) {
+		r.cacheTTL = ttl
+	}
+}
+
+// WithDefaultTimeout 检查项默认的超时时间，默认 2 秒
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return func(r *Registry) {
+		r.timeout = timeout
+	}
+}
+
+func New(opts ...Option) *Registry {
+	r := &Registry{cacheTTL: 5 * time.Second, timeout: 2 * time.Second}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// CheckOption 检查项的配置
+type CheckOption func(*check)
+
+// WithTimeout 设置检查项的超时时间
+func WithTimeout(timeout time.Duration) CheckOption {
+	return func(c *check) {
+		c.timeout = timeout
+	}
+}
+
+// NonCritical 检查失败时 /readyz 仍然返回 200，状态为 degraded，如缓存等可降级的依赖
+func NonCritical() CheckOption {
+	return func(c *check) {
+		c.critical = false
+	}
+}
+
+// Liveness 用于 /livez 的检查项，只检查进程自身，如死锁检测，不要用于外部依赖，
+// 否则依赖故障时所有实例都会被重启
+func Liveness() CheckOption {
+	return func(c *check) {
+		c.liveness = true
+	}
+}
+
+// Register 注册检查项，默认为 /readyz 的关键检查项
+func (r *Registry) Register(name string, fn CheckFunc, opts ...CheckOption) {
+	c := &check{name: name, fn: fn, timeout: r.timeout, critical: true}
+	for _, opt := range opts {
+		opt(c)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, c)
+}
+
+// SetDraining 设置摘流状态，摘流时 /readyz 返回 503，发布前调用使负载均衡不再转发新请求
+func (r *Registry) SetDraining(draining bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.draining = draining
+	xlog.S(context.Background()).Infow("设置摘流状态", "draining", draining)
+}
+
+// Draining 是否处于摘流状态
+func (r *Registry) Draining() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.draining
+}
+
+// Live 执行存活检查项
+func (r *Registry) Live(ctx context.Context) Report {
+	return r.report(ctx, true)
+}
+
+// Ready 执行就绪检查项
+func (r *Registry) Ready(ctx context.Context) Report {
+	report := r.report(ctx, false)
+	if r.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+func (r *Registry) report(ctx context.Context, liveness bool) Report {
+	r.mu.RLock()
+	var checks []*check
+	for _, c := range r.checks {
+		if c.liveness == liveness {
+			checks = append(checks, c)
+		}
+	}
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			results[i] = r.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range checks {
+		res := results[i]
+		report.Checks[c.name] = res
+		if res.Status == StatusOK {
+			continue
+		}
+		if res.Critical {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run 缓存未过期时直接返回上次的结果。检查项只受自身 timeout 限制，不使用调用方的 ctx，
+// 探针请求断开时的结果不缓存，避免把断开导致的失败返回给后续的探针
+func (r *Registry) run(ctx context.Context, c *check) Result {
+	c.run.Lock()
+	defer c.run.Unlock()
+
+	c.mu.Lock()
+	res := c.result
+	c.mu.Unlock()
+	if !res.CheckedAt.IsZero() && time.Since(res.CheckedAt) < r.cacheTTL {
+		return res
+	}
+
+	start := time.Now()
+	err := runCheck(context.Background(), c)
+	res = Result{
+		Status:     StatusOK,
+		Critical:   c.critical,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt:  start,
+	}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	if ctx.Err() != nil {
+		return res
+	}
+
+	c.mu.Lock()
+	prev := c.result
+	c.result = res
+	c.mu.Unlock()
+
+	// 探针每隔几秒访问一次，只在状态或错误变化时记录日志
+	switch {
+	case res.Error != "" && res.Error != prev.Error:
+		xlog.S(ctx).Warnw("健康检查失败", "check", c.name, "err", err)
+	case res.Error == "" && prev.Error != "":
+		xlog.S(ctx).Infow("健康检查恢复", "check", c.name)
+	}
+	return res
+}
+
+// runCheck 检查项不响应 ctx 时也按超时返回
+func runCheck(ctx context.Context, c *check) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		done <- c.fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timeout after %s", c.timeout)
+	}
+}
+
+// LiveHandler /livez，存活检查失败时返回 503，只输出检查项的状态，不输出错误详情
+func (r *Registry) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		writeReport(rw, r.Live(req.Context()).withoutErrors())
+	})
+}
+
+// ReadyHandler /readyz，关键检查项失败或摘流时返回 503，只输出检查项的状态，不输出错误详情
+func (r *Registry) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		writeReport(rw, r.Ready(req.Context()).withoutErrors())
+	})
+}
+
+// DetailHandler 输出存活和就绪检查的错误详情，需要挂在有访问控制的路由上，hserver 的调试接口中为 /debug/health
+func (r *Registry) DetailHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(rw).Encode(map[string]Report{
+			"live":  r.Live(req.Context()),
+			"ready": r.Ready(req.Context()),
+		})
+	})
+}
+
+// DrainHandler POST 开始摘流，DELETE 取消摘流，需要挂在有访问控制的路由上，hserver 的调试接口中为 /debug/drain
+func (r *Registry) DrainHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			r.SetDraining(true)
+		case http.MethodDelete:
+			r.SetDraining(false)
+		case http.MethodGet:
+		default:
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(rw).Encode(map[string]bool{"draining": r.Draining()})
+	})
+}
+
+func writeReport(rw http.ResponseWriter, report Report) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	if report.Status == StatusFail || report.Status == StatusDraining {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(rw).Encode(report)
+}
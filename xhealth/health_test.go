@@ -0,0 +1,144 @@
+package xhealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func get(h http.Handler) (int, Report) {
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	var report Report
+	_ = json.Unmarshal(rw.Body.Bytes(), &report)
+	return rw.Code, report
+}
+
+func TestRegistry(t *testing.T) {
+	r := New(WithCacheTTL(time.Hour), WithDefaultTimeout(50*time.Millisecond))
+
+	var dbCalls int32
+	var dbErr atomic.Value
+	dbErr.Store("")
+	r.Register("db", func(ctx context.Context) error {
+		atomic.AddInt32(&dbCalls, 1)
+		if msg := dbErr.Load().(string); msg != "" {
+			return errors.New(msg)
+		}
+		return nil
+	})
+	r.Register("cache", func(ctx context.Context) error {
+		// 不响应 ctx 的检查也按超时返回
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}, NonCritical())
+	r.Register("goroutines", func(ctx context.Context) error { panic("boom") }, Liveness())
+
+	code, report := get(r.ReadyHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["db"].Status)
+	assert.Equal(t, StatusFail, report.Checks["cache"].Status)
+	assert.NotContains(t, report.Checks, "goroutines")
+	assert.Contains(t, r.Ready(context.Background()).Checks["cache"].Error, "timeout")
+
+	code, report = get(r.LiveHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Checks["goroutines"].Status)
+	assert.Empty(t, report.Checks["goroutines"].Error)
+
+	// 缓存期内不重复检查
+	dbErr.Store("connection refused")
+	get(r.ReadyHandler())
+	assert.EqualValues(t, 1, atomic.LoadInt32(&dbCalls))
+
+	r2 := New(WithCacheTTL(0))
+	r2.Register("db", func(ctx context.Context) error { return errors.New("connection refused") })
+	code, report = get(r2.ReadyHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Status)
+	// 无需认证的探针不输出错误详情
+	assert.Empty(t, report.Checks["db"].Error)
+
+	rw := httptest.NewRecorder()
+	r2.DetailHandler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	var detail map[string]Report
+	_ = json.Unmarshal(rw.Body.Bytes(), &detail)
+	assert.Equal(t, "connection refused", detail["ready"].Checks["db"].Error)
+}
+
+func TestCheckLogDedup(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+
+	var fail atomic.Value
+	fail.Store(true)
+	r := New(WithCacheTTL(0))
+	r.Register("db", func(ctx context.Context) error {
+		if fail.Load().(bool) {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		r.Ready(context.Background())
+	}
+	assert.Equal(t, 1, logs.FilterMessage("健康检查失败").Len())
+
+	fail.Store(false)
+	r.Ready(context.Background())
+	r.Ready(context.Background())
+	assert.Equal(t, 1, logs.FilterMessage("健康检查恢复").Len())
+}
+
+func TestDrain(t *testing.T) {
+	r := New()
+	drain := r.DrainHandler()
+
+	rw := httptest.NewRecorder()
+	drain.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.JSONEq(t, `{"draining":true}`, rw.Body.String())
+
+	code, report := get(r.ReadyHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusDraining, report.Status)
+	// 摘流不影响存活检查
+	code, _ = get(r.LiveHandler())
+	assert.Equal(t, http.StatusOK, code)
+
+	drain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/", nil))
+	code, _ = get(r.ReadyHandler())
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestCallerCanceled(t *testing.T) {
+	r := New(WithCacheTTL(time.Hour), WithDefaultTimeout(time.Second))
+	var calls int32
+	r.Register("db", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+			return nil
+		}
+	})
+
+	// 检查项不受调用方 ctx 影响，调用方断开时的结果不缓存
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, StatusOK, r.Ready(ctx).Status)
+	assert.Equal(t, StatusOK, r.Ready(context.Background()).Status)
+	assert.Equal(t, StatusOK, r.Ready(context.Background()).Status)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
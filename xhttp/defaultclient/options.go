@@ -5,85 +5,50 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/opentracing/opentracing-go"
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
+	"github.com/yituoshiniao/kit/xhttp/hclient"
 )
 
-var defaultServerOptions = options{
-	timeout:         3 * time.Second,
-	statusCodeGuard: false,
-	logicCodeGuard:  true,
-	durationFunc:    DurationToTimeMillisField,
-	transport:       http.DefaultTransport,
-	tlsConfig:       &tls.Config{},
-}
-
-type Option func(*options)
-
-type DurationToField func(duration time.Duration) zapcore.Field
-
-type options struct {
-	serviceName     string
-	target          string
-	timeout         time.Duration
-	statusCodeGuard bool
-	logicCodeGuard  bool
-	tracer          opentracing.Tracer
-	logger          *zap.Logger
-	durationFunc    DurationToField
-	tlsConfig       *tls.Config
-	transport       http.RoundTripper
-	metrics         bool
-}
+// Option 与 hclient 的 Option 相同，hclient.WithRetry 等也可以直接使用
+type Option = hclient.Option
 
 func WithTarget(target string) Option {
-	return func(o *options) {
-		o.target = target
-	}
+	return hclient.WithTarget(target)
 }
 
+// WithTimeout 单次请求的超时时间，默认 3s
 func WithTimeout(timeout time.Duration) Option {
-	return func(o *options) {
-		o.timeout = timeout
-	}
+	return hclient.WithTimeout(timeout)
 }
 
 func EnableStatusCodeGuard() Option {
-	return func(o *options) {
-		o.statusCodeGuard = true
-	}
+	return hclient.EnableStatusCodeGuard()
 }
 
+// DisableStatusCodeGuard 关闭状态码检查
+//
+// Deprecated: 状态码检查默认关闭，需要时使用 EnableStatusCodeGuard 开启
 func DisableStatusCodeGuard() Option {
-	return func(o *options) {
-		o.logicCodeGuard = false
-	}
-}
-
-func checkServiceName() Option {
-	return func(o *options) {
-		if o.serviceName == "" {
-			panic("http client 没有设置 serviceName，请使用 hclient.New(hclient.WithServiceName(\"passport\")) 设置")
-		}
-	}
+	return hclient.DisableStatusCodeGuard()
 }
 
 func WithServiceName(serviceName string) Option {
-	return func(o *options) {
-		o.serviceName = serviceName
-	}
+	return hclient.WithServiceName(serviceName)
 }
 
 func WithInsecure() Option {
-	return func(o *options) {
-		o.tlsConfig.InsecureSkipVerify = true
-	}
+	return hclient.WithInsecure()
+}
+
+func WithTLSConfig(conf *tls.Config) Option {
+	return hclient.WithTLSConfig(conf)
+}
+
+// WithRoundTripper 下一层的 http.RoundTripper，默认 http.DefaultTransport
+func WithRoundTripper(rt http.RoundTripper) Option {
+	return hclient.WithTransport(rt)
 }
 
 // WithMetrics 是否采集接口请求
 func WithMetrics(isMetrics bool) Option {
-	return func(o *options) {
-		o.metrics = isMetrics
-	}
+	return hclient.WithMetrics(isMetrics)
 }
@@ -2,35 +2,41 @@ package defaultclient
 
 import (
 	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/yituoshiniao/kit/xhttp/hclient"
 )
 
-// Transport will count requests.
+const (
+	HeaderJSON      = hclient.HeaderJSON
+	ContentTypeJson = hclient.ContentTypeJson
+)
+
+// Transport 使用 hclient 的中间件，可以用于接收 *http.Client 的第三方 SDK
+// 如 &http.Client{Transport: defaultclient.New(defaultclient.WithServiceName("wechat"))}
 type Transport struct {
-	N          int64             // number of requests passing this transport
-	rt         http.RoundTripper // next round-tripper or http.DefaultTransport if nil
-	serverName string
+	N  int64             // number of requests passing this transport
+	rt http.RoundTripper // hclient.NewTransport 组装的中间件
+}
+
+func New(opts ...Option) *Transport {
+	return &Transport{rt: hclient.NewTransport(opts...)}
 }
 
-var serverName = ""
+// NewClient 使用 Transport 的 *http.Client
+func NewClient(opts ...Option) *http.Client {
+	return &http.Client{Transport: New(opts...)}
+}
 
-//type RoundTripper interface {
-//	RoundTrip(*http.Request) (*http.Response, error)
-//}
+// RoundTrip implements a transport that will count requests.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.N, 1)
+	return t.rt.RoundTrip(req)
+}
 
-func New(opts ...Option) *Transport {
-	o := &defaultServerOptions
-	opts = append(opts, checkServiceName())
-	for _, opt := range opts {
-		opt(o)
-	}
-	serverName = o.serviceName
-
-	//var transport RoundTripper
-	transport := &Transport{}
-	transport.serverName = serverName
-	if transport.serverName == "" {
-		serverName = "http-client-api"
-	}
-
-	return transport
+func DurationToTimeMillisField(duration time.Duration) zapcore.Field {
+	return hclient.DurationToTimeMillisField(duration)
 }
@@ -0,0 +1,70 @@
+// Package hauth hserver/auth 和 hclient 共用的认证定义，包括调用方 Principal 和 HMAC 请求签名的格式，
+// 不依赖 hserver，hclient 引用时不会引入服务端的中间件
+package hauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// 认证方式
+const (
+	MethodJWT    = "jwt"
+	MethodHMAC   = "hmac"
+	MethodAPIKey = "apikey"
+)
+
+// Principal 认证通过的调用方
+type Principal struct {
+	// 用户 id、API Key 名称等
+	Subject string
+	// 认证方式，如 MethodJWT
+	Method string
+	Scopes []string
+	// JWT 的全部 claims，其他认证方式为空
+	Claims map[string]interface{}
+}
+
+// HasScope 是否拥有 scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// NewContext 将 principal 放入 ctx，之后的日志会带上 principal 字段
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey{}, p)
+	return xlog.NewCtxWithFields(ctx, zap.String("principal", p.Subject), zap.String("authMethod", p.Method))
+}
+
+// FromContext 获取认证通过的调用方
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// HMAC 签名使用的请求头
+const (
+	HeaderKeyId     = "X-Auth-Key"
+	HeaderTimestamp = "X-Auth-Timestamp"
+	HeaderNonce     = "X-Auth-Nonce"
+	HeaderSignature = "X-Auth-Signature"
+)
+
+// CanonicalString HMAC 签名内容，method、path?query、时间戳、nonce 和请求体的 sha256，以换行分隔
+func CanonicalString(r *http.Request, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return r.Method + "\n" + r.URL.RequestURI() + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,372 @@
+package hclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/yituoshiniao/kit/xhttp/hauth"
+	"github.com/yituoshiniao/kit/xlog"
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+// Token 访问下游使用的 token
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// TokenSource 获取 token，实现需要自己缓存
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// ClientCredentialsConfig OAuth2 client credentials 模式的配置
+type ClientCredentialsConfig struct {
+	TokenURL     string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+	// 请求 token 使用的客户端，默认 http.DefaultClient
+	Client *http.Client
+	// 过期前该时长内刷新 token，默认 1min
+	RefreshBefore time.Duration
+	// 不为空时 token 缓存在 redis 中，多个实例共享
+	Redis *redis.Client
+	// 默认 hclient:token:<ClientId>
+	RedisKey string
+}
+
+func (c ClientCredentialsConfig) withDefaults() ClientCredentialsConfig {
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	if c.RefreshBefore <= 0 {
+		c.RefreshBefore = time.Minute
+	}
+	if c.RedisKey == "" {
+		c.RedisKey = "hclient:token:" + c.ClientId
+	}
+	return c
+}
+
+// ClientCredentialsTokenSource 缓存 token，过期前刷新，同一时间只有一个请求在刷新
+type ClientCredentialsTokenSource struct {
+	conf ClientCredentialsConfig
+
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	token     *Token
+}
+
+func NewClientCredentialsTokenSource(conf ClientCredentialsConfig) *ClientCredentialsTokenSource {
+	return &ClientCredentialsTokenSource{conf: conf.withDefaults()}
+}
+
+func (s *ClientCredentialsTokenSource) Token(ctx context.Context) (*Token, error) {
+	if t := s.cached(); s.fresh(t) {
+		return t, nil
+	}
+
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	// 等待期间其他请求可能已经刷新
+	old := s.cached()
+	if s.fresh(old) {
+		return old, nil
+	}
+
+	t, err := s.refresh(ctx)
+	if err != nil {
+		// 刷新失败时继续使用未过期的 token
+		if old != nil && time.Now().Before(old.Expiry) {
+			xlog.S(ctx).Warnw("刷新 token 失败，使用未过期的 token", "clientId", s.conf.ClientId, "err", err)
+			return old, nil
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	s.token = t
+	s.mu.Unlock()
+	return t, nil
+}
+
+// Invalidate 下游返回 401 时丢弃 token，下一次请求重新获取
+func (s *ClientCredentialsTokenSource) Invalidate(ctx context.Context, token *Token) {
+	s.mu.Lock()
+	if s.token != nil && s.token.AccessToken == token.AccessToken {
+		s.token = nil
+	}
+	s.mu.Unlock()
+	if s.conf.Redis != nil {
+		if err := xrds.Trace(ctx, s.conf.Redis).Del(s.conf.RedisKey).Err(); err != nil {
+			xlog.S(ctx).Warnw("删除 redis 中的 token 失败", "key", s.conf.RedisKey, "err", err)
+		}
+	}
+}
+
+func (s *ClientCredentialsTokenSource) cached() *Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.token
+}
+
+func (s *ClientCredentialsTokenSource) fresh(t *Token) bool {
+	return t != nil && time.Now().Add(s.conf.RefreshBefore).Before(t.Expiry)
+}
+
+// refresh 优先使用 redis 中其他实例获取的 token
+func (s *ClientCredentialsTokenSource) refresh(ctx context.Context) (*Token, error) {
+	if s.conf.Redis != nil {
+		b, err := xrds.Trace(ctx, s.conf.Redis).Get(s.conf.RedisKey).Bytes()
+		if err != nil && err != redis.Nil {
+			xlog.S(ctx).Warnw("读取 redis 中的 token 失败", "key", s.conf.RedisKey, "err", err)
+		}
+		var t Token
+		if err == nil && json.Unmarshal(b, &t) == nil && s.fresh(&t) {
+			return &t, nil
+		}
+	}
+
+	t, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.conf.Redis != nil {
+		b, _ := json.Marshal(t)
+		if err := xrds.Trace(ctx, s.conf.Redis).Set(s.conf.RedisKey, b, time.Until(t.Expiry)).Err(); err != nil {
+			xlog.S(ctx).Warnw("保存 token 到 redis 失败", "key", s.conf.RedisKey, "err", err)
+		}
+	}
+	return t, nil
+}
+
+func (s *ClientCredentialsTokenSource) fetch(ctx context.Context) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.conf.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.conf.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.conf.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.conf.ClientId), url.QueryEscape(s.conf.ClientSecret))
+
+	resp, err := s.conf.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("hclient: 获取 token 失败 %d: %s", resp.StatusCode, body)
+	}
+
+	var ret struct {
+		AccessToken string      `json:"access_token"`
+		TokenType   string      `json:"token_type"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, fmt.Errorf("hclient: 解析 token 失败: %w", err)
+	}
+	if ret.AccessToken == "" {
+		return nil, fmt.Errorf("hclient: 获取 token 失败: %s", body)
+	}
+	expiresIn, _ := ret.ExpiresIn.Int64()
+	if expiresIn <= 0 {
+		expiresIn = 3600
+	}
+	return &Token{
+		AccessToken: ret.AccessToken,
+		TokenType:   ret.TokenType,
+		Expiry:      time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+// BearerToken 设置 Authorization: Bearer <token>，下游返回 401 时丢弃 token
+func BearerToken(src TokenSource) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			t, err := src.Token(req.Context())
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+t.AccessToken)
+			resp, err := next.RoundTrip(req)
+			if err == nil && resp.StatusCode == http.StatusUnauthorized {
+				if inv, ok := src.(interface {
+					Invalidate(ctx context.Context, token *Token)
+				}); ok {
+					inv.Invalidate(req.Context(), t)
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// APIKey 设置固定的 API Key 请求头
+func APIKey(header, key string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set(header, key)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// HMACConfig 请求签名配置，零值字段与 hserver/auth.HMACAuthenticator 的校验方式一致，见 hauth
+type HMACConfig struct {
+	KeyId  string
+	Secret []byte
+	// 默认 hauth.HeaderKeyId、hauth.HeaderTimestamp、hauth.HeaderNonce、hauth.HeaderSignature
+	KeyIdHeader     string
+	TimestampHeader string
+	NonceHeader     string
+	SignatureHeader string
+	// 签名内容，默认 hauth.CanonicalString
+	CanonicalString func(req *http.Request, timestamp, nonce string, body []byte) string
+	// 默认 sha256.New
+	Hash func() hash.Hash
+	// 签名编码，默认 base64
+	Encode func(sig []byte) string
+	// 时间戳，默认秒级 unix 时间
+	Timestamp func(t time.Time) string
+}
+
+func (c HMACConfig) withDefaults() HMACConfig {
+	if c.KeyIdHeader == "" {
+		c.KeyIdHeader = hauth.HeaderKeyId
+	}
+	if c.TimestampHeader == "" {
+		c.TimestampHeader = hauth.HeaderTimestamp
+	}
+	if c.NonceHeader == "" {
+		c.NonceHeader = hauth.HeaderNonce
+	}
+	if c.SignatureHeader == "" {
+		c.SignatureHeader = hauth.HeaderSignature
+	}
+	if c.CanonicalString == nil {
+		c.CanonicalString = hauth.CanonicalString
+	}
+	if c.Hash == nil {
+		c.Hash = sha256.New
+	}
+	if c.Encode == nil {
+		c.Encode = base64.StdEncoding.EncodeToString
+	}
+	if c.Timestamp == nil {
+		c.Timestamp = func(t time.Time) string {
+			return strconv.FormatInt(t.Unix(), 10)
+		}
+	}
+	return c
+}
+
+// HMACSign 为请求签名，每次重试重新生成时间戳和 nonce
+func HMACSign(conf HMACConfig) Middleware {
+	conf = conf.withDefaults()
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, err := peekBody(req)
+			if err != nil {
+				return nil, err
+			}
+			nonce := make([]byte, 16)
+			if _, err := rand.Read(nonce); err != nil {
+				return nil, err
+			}
+			ts := conf.Timestamp(time.Now())
+			n := hex.EncodeToString(nonce)
+
+			mac := hmac.New(conf.Hash, conf.Secret)
+			mac.Write([]byte(conf.CanonicalString(req, ts, n, body)))
+			req.Header.Set(conf.KeyIdHeader, conf.KeyId)
+			req.Header.Set(conf.TimestampHeader, ts)
+			req.Header.Set(conf.NonceHeader, n)
+			req.Header.Set(conf.SignatureHeader, conf.Encode(mac.Sum(nil)))
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// peekBody 读取请求体，不影响之后的发送
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	return b, nil
+}
+
+// 透传调用方身份使用的请求头
+const (
+	HeaderAuthSubject = "X-Auth-Subject"
+	HeaderAuthMethod  = "X-Auth-Method"
+)
+
+// IdentityFunc 从 ctx 中取出需要透传给下游的身份信息
+type IdentityFunc func(ctx context.Context) http.Header
+
+// PrincipalIdentity 透传 hserver/auth 认证通过的调用方
+func PrincipalIdentity() IdentityFunc {
+	return func(ctx context.Context) http.Header {
+		p, ok := hauth.FromContext(ctx)
+		if !ok {
+			return nil
+		}
+		return http.Header{HeaderAuthSubject: {p.Subject}, HeaderAuthMethod: {p.Method}}
+	}
+}
+
+// Identity 将 fn 返回的请求头设置到下游请求
+func Identity(fn IdentityFunc) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			for k, v := range fn(req.Context()) {
+				req.Header[http.CanonicalHeaderKey(k)] = v
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package hclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhttp/hserver/auth"
+)
+
+func newTokenServer(t *testing.T, calls *int32, expiresIn int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		assert.Equal(t, "app", id)
+		assert.Equal(t, "s3cret", secret)
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		n := atomic.AddInt32(calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+			"access_token": "token-" + string(rune('0'+n)),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+}
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	var calls int32
+	ts := newTokenServer(t, &calls, 3600)
+	defer ts.Close()
+
+	src := NewClientCredentialsTokenSource(ClientCredentialsConfig{TokenURL: ts.URL, ClientId: "app", ClientSecret: "s3cret"})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tk, err := src.Token(context.Background())
+			if assert.NoError(t, err) {
+				assert.Equal(t, "token-1", tk.AccessToken)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 下游返回 401 时重新获取
+	api := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			rw.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+	client := NewHTTPClient(WithServiceName("api"), WithTokenSource(src))
+	for _, code := range []int{http.StatusUnauthorized, http.StatusOK} {
+		resp, err := client.Get(api.URL)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, code, resp.StatusCode)
+		}
+	}
+}
+
+func TestClientCredentialsRefreshAndRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	var calls int32
+	ts := newTokenServer(t, &calls, 120)
+	defer ts.Close()
+
+	conf := ClientCredentialsConfig{TokenURL: ts.URL, ClientId: "app", ClientSecret: "s3cret", Redis: rds}
+	a, b := NewClientCredentialsTokenSource(conf), NewClientCredentialsTokenSource(conf)
+	ta, err := a.Token(context.Background())
+	assert.NoError(t, err)
+	tb, err := b.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ta.AccessToken, tb.AccessToken)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 剩余时间小于 RefreshBefore 时刷新
+	conf.RefreshBefore = 5 * time.Minute
+	c := NewClientCredentialsTokenSource(conf)
+	tc, err := c.Token(context.Background())
+	assert.NoError(t, err)
+	assert.NotEqual(t, ta.AccessToken, tc.AccessToken)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestHMACSignAndIdentity(t *testing.T) {
+	secret := []byte("secret")
+	m := auth.NewMiddleware([]auth.Authenticator{
+		auth.NewHMACAuthenticator(auth.StaticSecrets(map[string][]byte{"svc": secret}), nil),
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		m.ServeHTTP(rw, r, func(rw http.ResponseWriter, r *http.Request) {
+			p, _ := auth.FromContext(r.Context())
+			_ = json.NewEncoder(rw).Encode([]string{p.Subject, r.Header.Get(HeaderAuthSubject)})
+		})
+	}))
+	defer ts.Close()
+
+	client := New(WithServiceName("order"), WithTarget(ts.URL),
+		WithHMAC(HMACConfig{KeyId: "svc", Secret: secret}),
+		WithIdentity(PrincipalIdentity()),
+	)
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "user-1", Method: auth.MethodJWT})
+	req, err := client.New().Post("/orders?id=1").BodyJSON(map[string]int{"a": 1}).Request()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var ret []string
+	resp, err := client.Do(req.WithContext(ctx), &ret, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"svc", "user-1"}, ret)
+	}
+}
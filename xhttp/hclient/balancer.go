@@ -0,0 +1,426 @@
+package hclient
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dghubble/sling"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// NoEndpointError 没有可用的下游地址，经过 hserver 输出时为 503
+type NoEndpointError struct {
+	Target string
+	// target 无法解析等配置错误，为空时表示地址列表为空或全部不可用
+	Err error
+}
+
+func (e *NoEndpointError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("hclient: %s 没有可用的地址: %v", e.Target, e.Err)
+	}
+	return fmt.Sprintf("hclient: %s 没有可用的地址", e.Target)
+}
+
+func (e *NoEndpointError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NoEndpointError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, "下游服务不可用")
+}
+
+// Endpoint 下游地址
+type Endpoint struct {
+	Addr string
+
+	inflight int64
+	// 以下字段由 BalancerDoer.mu 保护
+	failures     int
+	ejectedUntil time.Time
+	unhealthy    bool
+}
+
+// Inflight 正在进行的请求数，响应体关闭后减少
+func (e *Endpoint) Inflight() int64 {
+	return atomic.LoadInt64(&e.inflight)
+}
+
+// Picker 从可用地址中选择一个，endpoints 不为空
+type Picker interface {
+	Pick(req *http.Request, endpoints []*Endpoint) *Endpoint
+}
+
+// PickerFunc 函数形式的 Picker
+type PickerFunc func(req *http.Request, endpoints []*Endpoint) *Endpoint
+
+func (f PickerFunc) Pick(req *http.Request, endpoints []*Endpoint) *Endpoint {
+	return f(req, endpoints)
+}
+
+// RoundRobin 轮询
+func RoundRobin() Picker {
+	var next uint64
+	return PickerFunc(func(req *http.Request, endpoints []*Endpoint) *Endpoint {
+		n := atomic.AddUint64(&next, 1) - 1
+		return endpoints[n%uint64(len(endpoints))]
+	})
+}
+
+// LeastInflight 选择进行中请求最少的地址，数量相同时随机选择
+func LeastInflight() Picker {
+	return PickerFunc(func(req *http.Request, endpoints []*Endpoint) *Endpoint {
+		offset := rand.Intn(len(endpoints))
+		var best *Endpoint
+		for i := range endpoints {
+			ep := endpoints[(offset+i)%len(endpoints)]
+			if best == nil || ep.Inflight() < best.Inflight() {
+				best = ep
+			}
+		}
+		return best
+	})
+}
+
+// ConsistentHash 按请求头做一致性哈希（rendezvous hashing），地址增减时只影响对应的请求，
+// 请求头为空时随机选择
+func ConsistentHash(header string) Picker {
+	return PickerFunc(func(req *http.Request, endpoints []*Endpoint) *Endpoint {
+		key := req.Header.Get(header)
+		if key == "" {
+			return endpoints[rand.Intn(len(endpoints))]
+		}
+		var best *Endpoint
+		var bestScore uint64
+		for _, ep := range endpoints {
+			h := fnv.New64a()
+			_, _ = io.WriteString(h, key)
+			_, _ = io.WriteString(h, ep.Addr)
+			if score := h.Sum64(); best == nil || score > bestScore {
+				best, bestScore = ep, score
+			}
+		}
+		return best
+	})
+}
+
+// HealthCheckConfig 主动健康检查，状态码不是 2xx 的地址不参与负载均衡
+type HealthCheckConfig struct {
+	// 默认 /readyz
+	Path string
+	// 默认 10s
+	Interval time.Duration
+	// 默认 1s
+	Timeout time.Duration
+	// 默认使用 http.DefaultTransport，hclient.New 中使用客户端的 transport
+	Client *http.Client
+}
+
+func (c HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if c.Path == "" {
+		c.Path = "/readyz"
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Client == nil {
+		c.Client = &http.Client{}
+	}
+	return c
+}
+
+// BalancerConfig 客户端负载均衡配置，零值字段使用默认值
+type BalancerConfig struct {
+	// 自定义服务发现，为空时按 target 的协议创建
+	Resolver Resolver
+	// 请求下游使用的协议，默认 http
+	Scheme string
+	// 默认 RoundRobin()
+	Picker Picker
+	// 轮询解析地址的间隔，static 不重新解析，dns 默认 30s，file 等其他默认 5s
+	RefreshInterval time.Duration
+	// 连续失败该次数后摘除地址，默认 5，小于 0 时不摘除
+	ConsecutiveFailures int
+	// 摘除时长，默认 30s
+	EjectionDuration time.Duration
+	// 最多摘除的地址比例，默认 50
+	MaxEjectionPercent int
+	// 请求是否失败，默认 err 不为空或状态码为 5xx，调用方取消不算失败
+	IsFailure func(resp *http.Response, err error) bool
+	// 为空时不做主动健康检查
+	HealthCheck *HealthCheckConfig
+}
+
+func (c BalancerConfig) withDefaults(target string) BalancerConfig {
+	if c.Scheme == "" {
+		c.Scheme = "http"
+	}
+	if c.Picker == nil {
+		c.Picker = RoundRobin()
+	}
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = 5 * time.Second
+		if scheme, _, _ := splitTarget(target); scheme == SchemeDNS {
+			c.RefreshInterval = 30 * time.Second
+		}
+	}
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = 5
+	}
+	if c.EjectionDuration <= 0 {
+		c.EjectionDuration = 30 * time.Second
+	}
+	if c.MaxEjectionPercent <= 0 {
+		c.MaxEjectionPercent = 50
+	}
+	if c.IsFailure == nil {
+		c.IsFailure = defaultIsFailure
+	}
+	if c.HealthCheck != nil {
+		hc := c.HealthCheck.withDefaults()
+		c.HealthCheck = &hc
+	}
+	return c
+}
+
+// BalancerDoer 通过 Resolver 获取下游地址，每次请求（包括重试）选择一个地址替换 URL 中的 host
+type BalancerDoer struct {
+	doer   sling.Doer
+	target string
+	conf   BalancerConfig
+	cancel context.CancelFunc
+
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+}
+
+// NewBalancerDoer 创建后在后台定时解析地址和健康检查，不再使用时调用 Close
+func NewBalancerDoer(doer sling.Doer, target string, conf BalancerConfig) (*BalancerDoer, error) {
+	conf = conf.withDefaults(target)
+	refresh := true
+	if conf.Resolver == nil {
+		r, err := NewResolver(target)
+		if err != nil {
+			return nil, err
+		}
+		conf.Resolver = r
+		scheme, _, _ := splitTarget(target)
+		refresh = scheme != SchemeStatic
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &BalancerDoer{doer: doer, target: target, conf: conf, cancel: cancel}
+	t.resolve(ctx)
+	if refresh {
+		go t.loop(ctx, conf.RefreshInterval, t.resolve)
+	}
+	if conf.HealthCheck != nil {
+		t.healthCheck(ctx)
+		go t.loop(ctx, conf.HealthCheck.Interval, t.healthCheck)
+	}
+	return t, nil
+}
+
+// Close 停止后台的解析和健康检查
+func (t *BalancerDoer) Close() {
+	t.cancel()
+}
+
+// Endpoints 当前的全部地址
+func (t *BalancerDoer) Endpoints() []*Endpoint {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]*Endpoint(nil), t.endpoints...)
+}
+
+func (t *BalancerDoer) Do(req *http.Request) (*http.Response, error) {
+	ep := t.pick(req)
+	if ep == nil {
+		return nil, &NoEndpointError{Target: t.target}
+	}
+
+	r := req.Clone(req.Context())
+	r.URL.Scheme = t.conf.Scheme
+	r.URL.Host = ep.Addr
+	r.Host = ""
+
+	atomic.AddInt64(&ep.inflight, 1)
+	resp, err := t.doer.Do(r)
+	// 与熔断一样，调用方取消不计入成功或失败
+	if !canceled(req, err) {
+		t.record(req.Context(), ep, t.conf.IsFailure(resp, err))
+	}
+	if err != nil || resp.Body == nil {
+		atomic.AddInt64(&ep.inflight, -1)
+		return resp, err
+	}
+	resp.Body = &inflightBody{ReadCloser: resp.Body, ep: ep}
+	return resp, nil
+}
+
+// pick 优先选择未摘除且健康的地址，全部不可用时在全部地址中选择，避免摘除导致完全不可用
+func (t *BalancerDoer) pick(req *http.Request) *Endpoint {
+	now := time.Now()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.endpoints) == 0 {
+		return nil
+	}
+	available := make([]*Endpoint, 0, len(t.endpoints))
+	for _, ep := range t.endpoints {
+		if !ep.unhealthy && !now.Before(ep.ejectedUntil) {
+			available = append(available, ep)
+		}
+	}
+	if len(available) == 0 {
+		available = t.endpoints
+	}
+	return t.conf.Picker.Pick(req, available)
+}
+
+// record 被动异常检测，连续失败的地址摘除 EjectionDuration
+func (t *BalancerDoer) record(ctx context.Context, ep *Endpoint, failed bool) {
+	if t.conf.ConsecutiveFailures < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failed {
+		ep.failures = 0
+		return
+	}
+	ep.failures++
+	if ep.failures < t.conf.ConsecutiveFailures {
+		return
+	}
+	ep.failures = 0
+
+	now := time.Now()
+	ejected := 0
+	for _, e := range t.endpoints {
+		if now.Before(e.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(t.endpoints)*t.conf.MaxEjectionPercent {
+		xlog.S(ctx).Warnw("摘除地址数达到上限", "target", t.target, "addr", ep.Addr, "ejected", ejected)
+		return
+	}
+	ep.ejectedUntil = now.Add(t.conf.EjectionDuration)
+	xlog.S(ctx).Warnw("连续失败摘除地址", "target", t.target, "addr", ep.Addr, "duration", t.conf.EjectionDuration)
+}
+
+func (t *BalancerDoer) loop(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+// resolve 解析失败或结果为空时保留原来的地址
+func (t *BalancerDoer) resolve(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := t.conf.Resolver.Resolve(ctx)
+	if err != nil || len(addrs) == 0 {
+		xlog.S(ctx).Warnw("解析下游地址失败", "target", t.target, "err", err)
+		return
+	}
+	addrs = normalizeAddrs(addrs)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(addrs) == len(t.endpoints) {
+		same := true
+		for i, ep := range t.endpoints {
+			if ep.Addr != addrs[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+	old := make(map[string]*Endpoint, len(t.endpoints))
+	for _, ep := range t.endpoints {
+		old[ep.Addr] = ep
+	}
+	endpoints := make([]*Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		ep, ok := old[addr]
+		if !ok {
+			ep = &Endpoint{Addr: addr}
+		}
+		endpoints = append(endpoints, ep)
+	}
+	t.endpoints = endpoints
+	xlog.S(ctx).Infow("下游地址变化", "target", t.target, "endpoints", addrs)
+}
+
+func (t *BalancerDoer) healthCheck(ctx context.Context) {
+	hc := t.conf.HealthCheck
+	endpoints := t.Endpoints()
+	healthy := make([]bool, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep *Endpoint) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.conf.Scheme+"://"+ep.Addr+hc.Path, nil)
+			if err != nil {
+				return
+			}
+			resp, err := hc.Client.Do(req)
+			if err != nil {
+				return
+			}
+			drain(resp.Body)
+			healthy[i] = resp.StatusCode >= 200 && resp.StatusCode < 300
+		}(i, ep)
+	}
+	wg.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, ep := range endpoints {
+		if ep.unhealthy == !healthy[i] {
+			continue
+		}
+		ep.unhealthy = !healthy[i]
+		xlog.S(ctx).Warnw("健康检查状态变化", "target", t.target, "addr", ep.Addr, "healthy", healthy[i])
+	}
+}
+
+// inflightBody 响应体关闭时减少进行中的请求数
+type inflightBody struct {
+	io.ReadCloser
+	ep   *Endpoint
+	once sync.Once
+}
+
+func (b *inflightBody) Close() error {
+	b.once.Do(func() { atomic.AddInt64(&b.ep.inflight, -1) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,197 @@
+package hclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEndpointServer(name string, status *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if status != nil {
+			rw.WriteHeader(int(atomic.LoadInt32(status)))
+		}
+		_, _ = rw.Write([]byte(`"` + name + `"`))
+	}))
+}
+
+func addr(ts *httptest.Server) string {
+	return ts.Listener.Addr().String()
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	a, b := newEndpointServer("a", nil), newEndpointServer("b", nil)
+	defer a.Close()
+	defer b.Close()
+
+	client := New(WithServiceName("peer"), WithTarget("static://"+addr(a)+","+addr(b)))
+	got := map[string]int{}
+	for i := 0; i < 4; i++ {
+		var name string
+		_, err := client.New().Get("/ping").ReceiveSuccess(&name)
+		if assert.NoError(t, err) {
+			got[name]++
+		}
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, got)
+}
+
+func TestBalancerConsistentHash(t *testing.T) {
+	doer, err := NewBalancerDoer(http.DefaultClient, "static://a:80,b:80,c:80", BalancerConfig{Picker: ConsistentHash("X-User-Id")})
+	assert.NoError(t, err)
+	defer doer.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://peer/", nil)
+	req.Header.Set("X-User-Id", "42")
+	first := doer.pick(req)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, doer.pick(req))
+	}
+}
+
+func TestBalancerOutlierEjection(t *testing.T) {
+	bad := int32(http.StatusInternalServerError)
+	a, b := newEndpointServer("a", nil), newEndpointServer("b", &bad)
+	defer a.Close()
+	defer b.Close()
+
+	doer, err := NewBalancerDoer(http.DefaultClient, "static://"+addr(a)+","+addr(b), BalancerConfig{ConsecutiveFailures: 2})
+	assert.NoError(t, err)
+	defer doer.Close()
+
+	var failures int
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://peer/", nil)
+		resp, err := doer.Do(req)
+		if assert.NoError(t, err) {
+			if resp.StatusCode != http.StatusOK {
+				failures++
+			}
+			resp.Body.Close()
+		}
+	}
+	assert.Equal(t, 2, failures)
+	for _, ep := range doer.Endpoints() {
+		assert.Equal(t, int64(0), ep.Inflight())
+	}
+}
+
+func TestBalancerIgnoresCanceled(t *testing.T) {
+	a, b := newEndpointServer("a", nil), newEndpointServer("b", nil)
+	defer a.Close()
+	defer b.Close()
+
+	doer, err := NewBalancerDoer(http.DefaultClient, "static://"+addr(a)+","+addr(b), BalancerConfig{ConsecutiveFailures: 1})
+	assert.NoError(t, err)
+	defer doer.Close()
+
+	// 调用方取消不算失败，不摘除地址
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://peer/", nil)
+		_, err := doer.Do(req)
+		assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	}
+	got := map[string]int{}
+	for i := 0; i < 4; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://peer/", nil)
+		resp, err := doer.Do(req)
+		if assert.NoError(t, err) {
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			got[string(b)]++
+		}
+	}
+	assert.Equal(t, map[string]int{`"a"`: 2, `"b"`: 2}, got)
+}
+
+func TestBalancerHealthCheckAndFileResolver(t *testing.T) {
+	unhealthy := int32(http.StatusServiceUnavailable)
+	a, b := newEndpointServer("a", nil), newEndpointServer("b", &unhealthy)
+	defer a.Close()
+	defer b.Close()
+
+	path := filepath.Join(t.TempDir(), "endpoints")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("# peer\n"+addr(a)+"\n"), 0644))
+
+	doer, err := NewBalancerDoer(http.DefaultClient, "file://"+path, BalancerConfig{
+		RefreshInterval: 10 * time.Millisecond,
+		HealthCheck:     &HealthCheckConfig{Interval: 10 * time.Millisecond},
+	})
+	assert.NoError(t, err)
+	defer doer.Close()
+	assert.Len(t, doer.Endpoints(), 1)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(addr(a)+"\n"+addr(b)+"\n"), 0644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, doer.Endpoints(), 2)
+
+	// b 健康检查失败，不参与负载均衡
+	for i := 0; i < 4; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://peer/", nil)
+		assert.Equal(t, addr(a), doer.pick(req).Addr)
+	}
+
+	// 文件删除时保留原来的地址
+	assert.NoError(t, os.Remove(path))
+	time.Sleep(30 * time.Millisecond)
+	assert.Len(t, doer.Endpoints(), 2)
+}
+
+func TestBalancerNoEndpoint(t *testing.T) {
+	doer, err := NewBalancerDoer(http.DefaultClient, "", BalancerConfig{Resolver: StaticResolver()})
+	assert.NoError(t, err)
+	defer doer.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://peer/", nil)
+	_, err = doer.Do(req)
+	var noEndpoint *NoEndpointError
+	assert.True(t, errors.As(err, &noEndpoint))
+	assert.False(t, DefaultRetryOn(nil, err))
+
+	_, err = NewResolver("dns://peer")
+	assert.True(t, strings.Contains(err.Error(), "缺少端口"))
+}
+
+func TestClientBadTargetAndClose(t *testing.T) {
+	// target 错误时 New 不会 panic，请求返回 *NoEndpointError
+	var noEndpoint *NoEndpointError
+	assert.NotPanics(t, func() {
+		_, err := New(WithServiceName("peer"), WithTarget("dns://peer")).Get("/").ReceiveSuccess(nil)
+		assert.True(t, errors.As(err, &noEndpoint))
+		assert.Contains(t, err.Error(), "缺少端口")
+	})
+
+	_, err := NewE(WithServiceName("peer"), WithTarget("dns://peer"))
+	assert.Contains(t, err.Error(), "缺少端口")
+
+	ts := newEndpointServer("a", nil)
+	defer ts.Close()
+	c, err := NewE(WithServiceName("peer"), WithTarget("static://"+addr(ts)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var name string
+	_, err = c.Get("/").ReceiveSuccess(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", name)
+
+	assert.NoError(t, c.Close())
+	_, err = c.Get("/").ReceiveSuccess(&name)
+	assert.True(t, errors.As(err, &noEndpoint))
+
+	rt := NewTransport(WithServiceName("peer"), WithTarget("static://"+addr(ts)))
+	assert.NoError(t, rt.(io.Closer).Close())
+}
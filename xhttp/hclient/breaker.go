@@ -0,0 +1,283 @@
+package hclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dghubble/sling"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitOpenError 熔断器打开时直接返回的错误，经过 hserver 输出时为 503
+type CircuitOpenError struct {
+	// serviceName/host
+	Name string
+	// 距离下一次探测的时间
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("hclient: %s 熔断中，%s 后重试", e.Name, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *CircuitOpenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, "下游服务不可用")
+}
+
+// BreakerConfig 熔断配置，零值字段使用默认值
+type BreakerConfig struct {
+	// 统计窗口，默认 10s
+	Window time.Duration
+	// 窗口内请求数达到该值才判断是否熔断，默认 20
+	MinRequests int
+	// 失败率达到该值时熔断，默认 0.5
+	ErrorRate float64
+	// 超过该时长的请求为慢请求，默认不统计
+	SlowCallDuration time.Duration
+	// 慢请求比例达到该值时熔断，默认 0.5
+	SlowCallRate float64
+	// 熔断后经过该时长进入半开状态，默认 5s
+	OpenDuration time.Duration
+	// 半开状态允许的探测请求数，全部成功后关闭熔断，默认 3
+	HalfOpenRequests int
+	// 请求是否失败，默认 err 不为空或状态码为 5xx；调用方取消的请求既不算成功也不算失败，不经过 IsFailure
+	IsFailure func(resp *http.Response, err error) bool
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.ErrorRate <= 0 {
+		c.ErrorRate = 0.5
+	}
+	if c.SlowCallRate <= 0 {
+		c.SlowCallRate = 0.5
+	}
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = 5 * time.Second
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = 3
+	}
+	if c.IsFailure == nil {
+		c.IsFailure = defaultIsFailure
+	}
+	return c
+}
+
+func defaultIsFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// canceled 调用方取消的请求，不能说明下游是否可用
+func canceled(req *http.Request, err error) bool {
+	return err != nil && (errors.Is(err, context.Canceled) || req.Context().Err() == context.Canceled)
+}
+
+// CircuitBreakerDoer 按 serviceName + host 熔断，状态变化记录日志和 HttpClientBreakerCounter
+type CircuitBreakerDoer struct {
+	doer        sling.Doer
+	serviceName string
+	conf        BreakerConfig
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func NewCircuitBreakerDoer(doer sling.Doer, serviceName string, conf BreakerConfig) *CircuitBreakerDoer {
+	return &CircuitBreakerDoer{
+		doer:        doer,
+		serviceName: serviceName,
+		conf:        conf.withDefaults(),
+		breakers:    map[string]*circuitBreaker{},
+	}
+}
+
+// State 返回 host 对应熔断器的状态
+func (t *CircuitBreakerDoer) State(host string) string {
+	return t.breaker(host).currentState(time.Now())
+}
+
+func (t *CircuitBreakerDoer) breaker(host string) *circuitBreaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.breakers[host]
+	if !ok {
+		b = newCircuitBreaker(t.serviceName+"/"+host, t.conf)
+		t.breakers[host] = b
+	}
+	return b
+}
+
+func (t *CircuitBreakerDoer) Do(req *http.Request) (*http.Response, error) {
+	b := t.breaker(req.URL.Host)
+	gen, err := b.allow(req.Context())
+	if err != nil {
+		countBreaker(b.name, "rejected")
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.doer.Do(req)
+	if canceled(req, err) {
+		b.release(gen)
+		return resp, err
+	}
+	b.record(req.Context(), gen, t.conf.IsFailure(resp, err), time.Since(start))
+	return resp, err
+}
+
+type breakerBucket struct {
+	epoch    int64
+	total    int
+	failures int
+	slow     int
+}
+
+// circuitBreaker 滑动窗口分为 10 个桶统计
+type circuitBreaker struct {
+	name string
+	conf BreakerConfig
+
+	mu         sync.Mutex
+	state      string
+	generation int64
+	openUntil  time.Time
+	probes     int
+	successes  int
+	buckets    [10]breakerBucket
+}
+
+func newCircuitBreaker(name string, conf BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{name: name, conf: conf, state: BreakerClosed}
+}
+
+func (b *circuitBreaker) currentState(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !now.Before(b.openUntil) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// allow 返回当前状态的版本，状态变化后旧版本的请求结果不再统计
+func (b *circuitBreaker) allow(ctx context.Context) (int64, error) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if now.Before(b.openUntil) {
+			return 0, &CircuitOpenError{Name: b.name, RetryAfter: b.openUntil.Sub(now)}
+		}
+		b.setState(ctx, BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.conf.HalfOpenRequests {
+			return 0, &CircuitOpenError{Name: b.name, RetryAfter: b.conf.OpenDuration}
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// release 不统计请求结果，半开状态时归还探测名额
+func (b *circuitBreaker) release(gen int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen == b.generation && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *circuitBreaker) record(ctx context.Context, gen int64, failed bool, d time.Duration) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.trip(ctx, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.conf.HalfOpenRequests {
+			b.setState(ctx, BreakerClosed)
+		}
+	case BreakerClosed:
+		bucketSize := int64(b.conf.Window) / int64(len(b.buckets))
+		epoch := now.UnixNano() / bucketSize
+		bucket := &b.buckets[epoch%int64(len(b.buckets))]
+		if bucket.epoch != epoch {
+			*bucket = breakerBucket{epoch: epoch}
+		}
+		bucket.total++
+		if failed {
+			bucket.failures++
+		}
+		slow := b.conf.SlowCallDuration > 0 && d >= b.conf.SlowCallDuration
+		if slow {
+			bucket.slow++
+		}
+		if !failed && !slow {
+			return
+		}
+
+		var total, failures, slowCalls int
+		for _, bk := range b.buckets {
+			if epoch-bk.epoch < int64(len(b.buckets)) {
+				total += bk.total
+				failures += bk.failures
+				slowCalls += bk.slow
+			}
+		}
+		if total < b.conf.MinRequests {
+			return
+		}
+		if float64(failures)/float64(total) >= b.conf.ErrorRate ||
+			(b.conf.SlowCallDuration > 0 && float64(slowCalls)/float64(total) >= b.conf.SlowCallRate) {
+			xlog.S(ctx).Warnw("熔断统计", "name", b.name, "total", total, "failures", failures, "slow", slowCalls)
+			b.trip(ctx, now)
+		}
+	}
+}
+
+func (b *circuitBreaker) trip(ctx context.Context, now time.Time) {
+	b.openUntil = now.Add(b.conf.OpenDuration)
+	b.setState(ctx, BreakerOpen)
+}
+
+func (b *circuitBreaker) setState(ctx context.Context, state string) {
+	xlog.S(ctx).Warnw("熔断器状态变化", "name", b.name, "from", b.state, "to", state)
+	countBreaker(b.name, state)
+	b.state = state
+	b.generation++
+	b.probes = 0
+	b.successes = 0
+	b.buckets = [10]breakerBucket{}
+}
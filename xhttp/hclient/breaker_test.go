@@ -0,0 +1,164 @@
+package hclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+func TestCircuitBreakerDoer(t *testing.T) {
+	var failing int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	doer := NewCircuitBreakerDoer(http.DefaultClient, "partner", BreakerConfig{
+		MinRequests:      4,
+		OpenDuration:     50 * time.Millisecond,
+		HalfOpenRequests: 2,
+	})
+	get := func() error {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		resp, err := doer.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	host := ts.Listener.Addr().String()
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, get())
+	}
+	assert.Equal(t, BreakerOpen, doer.State(host))
+
+	err := get()
+	var open *CircuitOpenError
+	if assert.True(t, errors.As(err, &open)) {
+		assert.Equal(t, "partner/"+host, open.Name)
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, herror.FromError(err).Status)
+
+	// 半开状态探测失败重新熔断
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, BreakerHalfOpen, doer.State(host))
+	assert.NoError(t, get())
+	assert.Equal(t, BreakerOpen, doer.State(host))
+
+	// 探测全部成功后恢复
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, get())
+	assert.NoError(t, get())
+	assert.Equal(t, BreakerClosed, doer.State(host))
+}
+
+func TestBulkheadDoer(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	defer ts.Close()
+
+	doer := NewBulkheadDoer(http.DefaultClient, "partner", BulkheadConfig{MaxConcurrent: 1, MaxWait: 10 * time.Millisecond})
+	done := make(chan error)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		resp, err := doer.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	<-started
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	_, err := doer.Do(req)
+	var full *BulkheadFullError
+	assert.True(t, errors.As(err, &full))
+	assert.False(t, DefaultRetryOn(nil, err))
+
+	close(release)
+	assert.NoError(t, <-done)
+}
+
+func TestBulkheadReleaseOnBodyClose(t *testing.T) {
+	fail := false
+	doer := NewBulkheadDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("ok"))}, nil
+	}), "partner", BulkheadConfig{MaxConcurrent: 1})
+	do := func() (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, "http://partner/", nil)
+		return doer.Do(req)
+	}
+
+	resp, err := do()
+	assert.NoError(t, err)
+	// 响应体未关闭时仍然占用名额
+	_, err = do()
+	var full *BulkheadFullError
+	assert.True(t, errors.As(err, &full))
+
+	assert.NoError(t, resp.Body.Close())
+	assert.NoError(t, resp.Body.Close())
+	fail = true
+	_, err = do()
+	assert.EqualError(t, err, "connection refused")
+	// 出错时立即释放
+	fail = false
+	resp, err = do()
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	var failing int32 = 1
+	doer := NewCircuitBreakerDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		status := http.StatusOK
+		if atomic.LoadInt32(&failing) == 1 {
+			status = http.StatusInternalServerError
+		}
+		return &http.Response{StatusCode: status, Body: http.NoBody}, nil
+	}), "partner", BreakerConfig{MinRequests: 1, OpenDuration: time.Millisecond, HalfOpenRequests: 1})
+	do := func(ctx context.Context) error {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://partner/", nil)
+		_, err := doer.Do(req)
+		return err
+	}
+
+	assert.NoError(t, do(context.Background()))
+	assert.Equal(t, BreakerOpen, doer.State("partner"))
+	time.Sleep(2 * time.Millisecond)
+
+	// 取消的探测请求不会关闭熔断，也不占用探测名额
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, do(ctx), context.Canceled)
+	assert.Equal(t, BreakerHalfOpen, doer.State("partner"))
+
+	atomic.StoreInt32(&failing, 0)
+	assert.NoError(t, do(context.Background()))
+	assert.Equal(t, BreakerClosed, doer.State("partner"))
+}
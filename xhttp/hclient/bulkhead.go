@@ -0,0 +1,119 @@
+package hclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dghubble/sling"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// BulkheadFullError 下游并发数达到上限，经过 hserver 输出时为 503
+type BulkheadFullError struct {
+	// serviceName/host
+	Name          string
+	MaxConcurrent int
+}
+
+func (e *BulkheadFullError) Error() string {
+	return fmt.Sprintf("hclient: %s 并发请求数达到上限 %d", e.Name, e.MaxConcurrent)
+}
+
+func (e *BulkheadFullError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, "下游服务繁忙")
+}
+
+// BulkheadConfig 每个下游的并发限制
+type BulkheadConfig struct {
+	// 最大并发请求数
+	MaxConcurrent int
+	// 达到上限时最多等待的时长，默认不等待
+	MaxWait time.Duration
+}
+
+// BulkheadDoer 按 serviceName + host 限制并发，避免单个下游变慢时占满 goroutine，
+// 名额在响应体关闭时释放，调用方需要关闭响应体
+type BulkheadDoer struct {
+	doer        sling.Doer
+	serviceName string
+	conf        BulkheadConfig
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+func NewBulkheadDoer(doer sling.Doer, serviceName string, conf BulkheadConfig) *BulkheadDoer {
+	if conf.MaxConcurrent <= 0 {
+		panic("hclient: BulkheadConfig.MaxConcurrent 必须大于 0")
+	}
+	return &BulkheadDoer{doer: doer, serviceName: serviceName, conf: conf, sems: map[string]chan struct{}{}}
+}
+
+func (t *BulkheadDoer) sem(host string) chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sem, ok := t.sems[host]
+	if !ok {
+		sem = make(chan struct{}, t.conf.MaxConcurrent)
+		t.sems[host] = sem
+	}
+	return sem
+}
+
+func (t *BulkheadDoer) Do(req *http.Request) (*http.Response, error) {
+	sem := t.sem(req.URL.Host)
+	select {
+	case sem <- struct{}{}:
+	default:
+		if !t.wait(req, sem) {
+			name := t.serviceName + "/" + req.URL.Host
+			countBreaker(name, "bulkhead_rejected")
+			xlog.S(req.Context()).Warnw("下游并发请求数达到上限", "name", name, "maxConcurrent", t.conf.MaxConcurrent)
+			return nil, &BulkheadFullError{Name: name, MaxConcurrent: t.conf.MaxConcurrent}
+		}
+	}
+	release := func() { <-sem }
+	resp, err := t.doer.Do(req)
+	if err != nil || resp == nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+	// 调用方读取响应体时仍然占用下游连接，关闭响应体时才释放名额
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody 响应体关闭时释放并发名额
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func (t *BulkheadDoer) wait(req *http.Request, sem chan struct{}) bool {
+	if t.conf.MaxWait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(t.conf.MaxWait)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-req.Context().Done():
+		return false
+	}
+}
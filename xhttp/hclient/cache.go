@@ -0,0 +1,505 @@
+package hclient
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/sling"
+	"github.com/go-redis/redis"
+
+	"github.com/yituoshiniao/kit/xhttp/hauth"
+	"github.com/yituoshiniao/kit/xlog"
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+// HeaderCache 从缓存返回的响应带有该响应头，值为 hit、stale 或 revalidated
+const HeaderCache = "X-Hclient-Cache"
+
+// CacheStore 缓存存储
+type CacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// NewMemoryCacheStore 内存 LRU，最多保存 maxEntries 个响应
+func NewMemoryCacheStore(maxEntries int) CacheStore {
+	return &memoryCacheStore{max: maxEntries, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+type memoryCacheItem struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+type memoryCacheStore struct {
+	max int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func (s *memoryCacheStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := el.Value.(*memoryCacheItem)
+	if !time.Now().Before(item.expireAt) {
+		s.ll.Remove(el)
+		delete(s.items, key)
+		return nil, false, nil
+	}
+	s.ll.MoveToFront(el)
+	return item.value, true, nil
+}
+
+func (s *memoryCacheStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := &memoryCacheItem{key: key, value: value, expireAt: time.Now().Add(ttl)}
+	if el, ok := s.items[key]; ok {
+		el.Value = item
+		s.ll.MoveToFront(el)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(item)
+	for s.max > 0 && s.ll.Len() > s.max {
+		el := s.ll.Back()
+		s.ll.Remove(el)
+		delete(s.items, el.Value.(*memoryCacheItem).key)
+	}
+	return nil
+}
+
+// NewRedisCacheStore 缓存在 redis 中，多个实例共享，prefix 建议带上 serviceName，如 hclient:cache:passport:
+func NewRedisCacheStore(client *redis.Client, prefix string) CacheStore {
+	return redisCacheStore{client: client, prefix: prefix}
+}
+
+type redisCacheStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s redisCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := xrds.Trace(ctx, s.client).Get(s.prefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+func (s redisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return xrds.Trace(ctx, s.client).Set(s.prefix+key, value, ttl).Err()
+}
+
+// CacheConfig 响应缓存配置，只缓存 GET、HEAD 请求状态码为 200 的响应，零值字段使用默认值
+type CacheConfig struct {
+	// 默认 NewMemoryCacheStore(1000)
+	Store CacheStore
+	// 响应没有 Cache-Control: max-age 和 Expires 时的缓存时长，默认不缓存
+	DefaultTTL time.Duration
+	// 过期后继续返回旧响应、同时在后台重新验证的时长，响应中有 stale-while-revalidate 时以响应为准
+	StaleWhileRevalidate time.Duration
+	// 带 ETag 或 Last-Modified 的响应过期后继续保存的时长，用于条件请求，默认 1h
+	RevalidateWindow time.Duration
+	// 超过该大小的响应不缓存，默认 1MB
+	MaxBodyBytes int64
+	// 缓存 key，默认 method + url，之后会追加请求凭证的摘要和响应 Vary 对应的请求头
+	Key func(req *http.Request) string
+	// 带 Authorization、Cookie 的请求默认不缓存，开启后按凭证的摘要区分缓存，
+	// WithTokenSource 等在缓存之前设置凭证，每次请求都变化的凭证无法命中缓存
+	KeyByCredential bool
+}
+
+func (c CacheConfig) withDefaults() CacheConfig {
+	if c.Store == nil {
+		c.Store = NewMemoryCacheStore(1000)
+	}
+	if c.RevalidateWindow <= 0 {
+		c.RevalidateWindow = time.Hour
+	}
+	if c.MaxBodyBytes <= 0 {
+		c.MaxBodyBytes = 1 << 20
+	}
+	if c.Key == nil {
+		c.Key = func(req *http.Request) string {
+			return req.Method + " " + req.URL.String()
+		}
+	}
+	return c
+}
+
+type cacheTTLCtxKey struct{}
+
+// NewCtxWithCacheTTL 单个请求的缓存时长，忽略响应中的 Cache-Control，ttl 为 0 时不使用缓存
+func NewCtxWithCacheTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheTTLCtxKey{}, ttl)
+}
+
+// credentialHeaders 区分调用方的请求头，hauth.HeaderKeyId 和透传的身份总是计入 key
+var credentialHeaders = []string{"Authorization", "Cookie", hauth.HeaderKeyId, HeaderAuthSubject, HeaderAuthMethod}
+
+// cacheEntry 缓存的响应，响应有 Vary 时主 key 只保存 VaryKeys，响应按对应请求头的值保存在另外的 key
+type cacheEntry struct {
+	VaryKeys []string `json:"varyKeys,omitempty"`
+
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// 响应 Vary 头对应的请求头
+	Vary       map[string]string `json:"vary,omitempty"`
+	StoredAt   time.Time         `json:"storedAt"`
+	FreshUntil time.Time         `json:"freshUntil"`
+	StaleUntil time.Time         `json:"staleUntil"`
+}
+
+func (e *cacheEntry) validator() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for k, v := range e.Vary {
+		if req.Header.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheDoer 按 Cache-Control、ETag、Last-Modified 缓存响应，结果记录在 HttpClientCacheCounter
+type CacheDoer struct {
+	doer        sling.Doer
+	serviceName string
+	conf        CacheConfig
+
+	revalidating sync.Map
+}
+
+func NewCacheDoer(doer sling.Doer, serviceName string, conf CacheConfig) *CacheDoer {
+	return &CacheDoer{doer: doer, serviceName: serviceName, conf: conf.withDefaults()}
+}
+
+func (t *CacheDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	ttl, override := ctx.Value(cacheTTLCtxKey{}).(time.Duration)
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || (override && ttl <= 0) ||
+		hasDirective(req.Header, "no-store") || (!t.conf.KeyByCredential && hasCredential(req)) {
+		countCache(t.serviceName, "bypass")
+		return t.doer.Do(req)
+	}
+
+	key := t.key(req)
+	entry := t.load(ctx, key)
+	if entry != nil && len(entry.VaryKeys) > 0 {
+		key = varyKey(key, entry.VaryKeys, req)
+		entry = t.load(ctx, key)
+	}
+	if entry != nil && !entry.matches(req) {
+		entry = nil
+	}
+	now := time.Now()
+	if entry != nil && !hasDirective(req.Header, "no-cache") {
+		if now.Before(entry.FreshUntil) {
+			countCache(t.serviceName, "hit")
+			return entry.response(req, "hit"), nil
+		}
+		if now.Before(entry.StaleUntil) {
+			countCache(t.serviceName, "stale")
+			resp := entry.response(req, "stale")
+			t.revalidateAsync(req, key, entry)
+			return resp, nil
+		}
+	}
+
+	resp, revalidated, err := t.fetch(req, key, entry)
+	if revalidated {
+		countCache(t.serviceName, "revalidated")
+	} else {
+		countCache(t.serviceName, "miss")
+	}
+	return resp, err
+}
+
+// fetch 有旧的缓存时发送条件请求，304 时刷新缓存并返回缓存的响应
+func (t *CacheDoer) fetch(req *http.Request, key string, entry *cacheEntry) (resp *http.Response, revalidated bool, err error) {
+	r := req
+	if entry != nil && entry.validator() {
+		r = req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if lm := entry.Header.Get("Last-Modified"); lm != "" {
+			r.Header.Set("If-Modified-Since", lm)
+		}
+	}
+
+	resp, err = t.doer.Do(r)
+	if err != nil {
+		return resp, false, err
+	}
+	if resp.StatusCode == http.StatusNotModified && entry != nil && r != req {
+		drain(resp.Body)
+		for k, v := range resp.Header {
+			entry.Header[k] = v
+		}
+		t.store(req, key, entry, resp.Header)
+		return entry.response(req, "revalidated"), true, nil
+	}
+	if resp.StatusCode != http.StatusOK || !t.cacheable(req, resp.Header) ||
+		resp.ContentLength > t.conf.MaxBodyBytes {
+		return resp, false, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, t.conf.MaxBodyBytes+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, false, err
+	}
+	if int64(len(body)) > t.conf.MaxBodyBytes {
+		// 超过大小限制，已读取的部分和剩余的响应体一起返回
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, false, nil
+	}
+	_ = resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	key = t.key(req)
+	entry = &cacheEntry{StatusCode: resp.StatusCode, Header: resp.Header.Clone(), Body: body}
+	if vary := resp.Header.Get("Vary"); vary != "" {
+		entry.Vary = map[string]string{}
+		var keys []string
+		for _, k := range strings.Split(vary, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			entry.Vary[k] = req.Header.Get(k)
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		// 主 key 记录 Vary，不同请求头的响应分别缓存
+		t.store(req, key, &cacheEntry{VaryKeys: keys, Header: entry.Header}, resp.Header)
+		key = varyKey(key, keys, req)
+	}
+	t.store(req, key, entry, resp.Header)
+	return resp, false, nil
+}
+
+// key 在 Key 之后追加凭证的摘要，不同调用方的响应不会互相命中
+func (t *CacheDoer) key(req *http.Request) string {
+	key := t.conf.Key(req)
+	h := sha256.New()
+	found := false
+	for _, k := range credentialHeaders {
+		for _, v := range req.Header.Values(k) {
+			found = true
+			_, _ = fmt.Fprintf(h, "%s: %s\n", k, v)
+		}
+	}
+	if !found {
+		return key
+	}
+	return key + " cred=" + hex.EncodeToString(h.Sum(nil))
+}
+
+func varyKey(key string, keys []string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, k := range keys {
+		b.WriteString(" " + k + "=" + strings.Join(req.Header.Values(k), ","))
+	}
+	return b.String()
+}
+
+// hasCredential 请求是否带有用户凭证
+func hasCredential(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+func (t *CacheDoer) cacheable(req *http.Request, header http.Header) bool {
+	if strings.TrimSpace(header.Get("Vary")) == "*" {
+		return false
+	}
+	if _, override := req.Context().Value(cacheTTLCtxKey{}).(time.Duration); override {
+		return true
+	}
+	// 缓存可能在多个调用方之间共享，private 的响应不缓存
+	if hasDirective(header, "no-store") || hasDirective(header, "private") {
+		return false
+	}
+	// 过期后需要重新验证的响应，没有 ETag、Last-Modified 时缓存没有意义
+	fresh, ok := t.freshness(req, header)
+	return ok && (fresh > 0 || header.Get("ETag") != "" || header.Get("Last-Modified") != "")
+}
+
+// freshness 响应的新鲜时长，请求指定的 ttl 优先，其次是 max-age、Expires 和 DefaultTTL
+func (t *CacheDoer) freshness(req *http.Request, header http.Header) (time.Duration, bool) {
+	if ttl, ok := req.Context().Value(cacheTTLCtxKey{}).(time.Duration); ok {
+		return ttl, true
+	}
+	if hasDirective(header, "no-cache") {
+		return 0, true
+	}
+	if v, ok := directive(header, "max-age"); ok {
+		sec, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, false
+		}
+		age, _ := strconv.Atoi(header.Get("Age"))
+		return time.Duration(sec-age) * time.Second, true
+	}
+	if v := header.Get("Expires"); v != "" {
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			return 0, false
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		return exp.Sub(date), true
+	}
+	return t.conf.DefaultTTL, t.conf.DefaultTTL > 0
+}
+
+// store 按最新的响应头计算过期时间后保存
+func (t *CacheDoer) store(req *http.Request, key string, entry *cacheEntry, header http.Header) {
+	ctx := req.Context()
+	now := time.Now()
+	fresh, _ := t.freshness(req, header)
+	swr := t.conf.StaleWhileRevalidate
+	if v, ok := directive(header, "stale-while-revalidate"); ok {
+		sec, _ := strconv.Atoi(v)
+		swr = time.Duration(sec) * time.Second
+	}
+	if hasDirective(header, "must-revalidate") || hasDirective(header, "no-cache") {
+		swr = 0
+	}
+	entry.StoredAt = now
+	entry.FreshUntil = now.Add(fresh)
+	entry.StaleUntil = entry.FreshUntil.Add(swr)
+
+	keep := entry.StaleUntil.Sub(now)
+	if entry.validator() {
+		keep += t.conf.RevalidateWindow
+	}
+	if keep <= 0 {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := t.conf.Store.Set(ctx, key, b, keep); err != nil {
+		xlog.S(ctx).Warnw("保存响应缓存失败", "key", key, "err", err)
+	}
+}
+
+func (t *CacheDoer) load(ctx context.Context, key string) *cacheEntry {
+	b, ok, err := t.conf.Store.Get(ctx, key)
+	if err != nil {
+		xlog.S(ctx).Warnw("读取响应缓存失败", "key", key, "err", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// revalidateAsync 同一个 key 同时只有一个后台请求
+func (t *CacheDoer) revalidateAsync(req *http.Request, key string, entry *cacheEntry) {
+	if _, loaded := t.revalidating.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	// 调用方的请求结束后 ctx 会被取消，后台请求保留日志字段和链路
+	ctx, cancel := context.WithTimeout(detachedContext{req.Context()}, 30*time.Second)
+	r := req.Clone(ctx)
+	go func() {
+		defer cancel()
+		defer t.revalidating.Delete(key)
+		resp, _, err := t.fetch(r, key, entry)
+		if err != nil {
+			xlog.S(ctx).Warnw("后台刷新响应缓存失败", "key", key, "err", err)
+			return
+		}
+		drain(resp.Body)
+	}()
+}
+
+func (e *cacheEntry) response(req *http.Request, result string) *http.Response {
+	header := e.Header.Clone()
+	header.Set(HeaderCache, result)
+	header.Set("Age", strconv.Itoa(int(time.Since(e.StoredAt).Seconds())))
+	body := e.Body
+	if req.Method == http.MethodHead {
+		body = nil
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// directive 读取 Cache-Control 中的指令
+func directive(header http.Header, name string) (string, bool) {
+	for _, cc := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(cc, ",") {
+			d = strings.TrimSpace(d)
+			if strings.EqualFold(d, name) {
+				return "", true
+			}
+			if i := strings.IndexByte(d, '='); i > 0 && strings.EqualFold(d[:i], name) {
+				return strings.Trim(d[i+1:], `"`), true
+			}
+		}
+	}
+	return "", false
+}
+
+func hasDirective(header http.Header, name string) bool {
+	_, ok := directive(header, name)
+	return ok
+}
+
+// detachedContext 保留 ctx 中的值，不继承取消和超时
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,202 @@
+package hclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhttp/hauth"
+)
+
+func TestCacheDoer(t *testing.T) {
+	var calls, version int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		v := fmt.Sprintf(`"v%d"`, atomic.LoadInt32(&version))
+		switch r.URL.Path {
+		case "/max-age":
+			rw.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			rw.Header().Set("Cache-Control", "no-cache")
+			rw.Header().Set("ETag", v)
+			if r.Header.Get("If-None-Match") == v {
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/no-store":
+			rw.Header().Set("Cache-Control", "no-store")
+		}
+		_, _ = rw.Write([]byte(v))
+	}))
+	defer ts.Close()
+
+	if HttpClientCacheCounter == nil {
+		InitHttpClientCacheMetrics()
+	}
+	client := NewHTTPClient(WithServiceName("dict"), WithCache(CacheConfig{
+		DefaultTTL:           20 * time.Millisecond,
+		StaleWhileRevalidate: time.Minute,
+	}))
+	get := func(ctx context.Context, path string) (string, string) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		resp, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return "", ""
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b), resp.Header.Get(HeaderCache)
+	}
+	reset := func() {
+		atomic.StoreInt32(&calls, 0)
+	}
+	ctx := context.Background()
+
+	// max-age 内直接返回缓存
+	_, result := get(ctx, "/max-age")
+	assert.Equal(t, "", result)
+	_, result = get(ctx, "/max-age")
+	assert.Equal(t, "hit", result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// no-cache 时每次通过 ETag 重新验证
+	reset()
+	get(ctx, "/etag")
+	body, result := get(ctx, "/etag")
+	assert.Equal(t, `"v0"`, body)
+	assert.Equal(t, "revalidated", result)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// 请求指定缓存时长时忽略 no-store
+	reset()
+	get(NewCtxWithCacheTTL(ctx, time.Minute), "/no-store")
+	_, result = get(NewCtxWithCacheTTL(ctx, time.Minute), "/no-store")
+	assert.Equal(t, "hit", result)
+	_, result = get(NewCtxWithCacheTTL(ctx, 0), "/no-store")
+	assert.Equal(t, "", result)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// 过期后返回旧响应并在后台刷新
+	reset()
+	get(ctx, "/default")
+	atomic.StoreInt32(&version, 1)
+	time.Sleep(30 * time.Millisecond)
+	body, result = get(ctx, "/default")
+	assert.Equal(t, `"v0"`, body)
+	assert.Equal(t, "stale", result)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	body, _ = get(ctx, "/default")
+	assert.Equal(t, `"v1"`, body)
+}
+
+func TestCacheCredentialAndVary(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/private":
+			rw.Header().Set("Cache-Control", "private, max-age=60")
+		case "/vary":
+			rw.Header().Set("Cache-Control", "max-age=60")
+			rw.Header().Set("Vary", "Accept-Language")
+		default:
+			rw.Header().Set("Cache-Control", "max-age=60")
+		}
+		_, _ = rw.Write([]byte(r.Header.Get("Authorization") + r.Header.Get(HeaderAuthSubject) + r.Header.Get("Accept-Language")))
+	}))
+	defer ts.Close()
+
+	get := func(client *http.Client, ctx context.Context, path string, header http.Header) (string, string) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return "", ""
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b), resp.Header.Get(HeaderCache)
+	}
+	ctx := context.Background()
+	client := NewHTTPClient(WithServiceName("dict"), WithCache(CacheConfig{}))
+
+	// 带凭证的请求和 private 的响应不缓存
+	for i := 0; i < 2; i++ {
+		_, result := get(client, ctx, "/user", http.Header{"Cookie": {"sid=1"}})
+		assert.Equal(t, "", result)
+		_, result = get(client, ctx, "/private", nil)
+		assert.Equal(t, "", result)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	// Vary 的请求头计入 key，不同取值分别缓存
+	atomic.StoreInt32(&calls, 0)
+	for i := 0; i < 2; i++ {
+		body, _ := get(client, ctx, "/vary", http.Header{"Accept-Language": {"zh"}})
+		assert.Equal(t, "zh", body)
+		body, _ = get(client, ctx, "/vary", http.Header{"Accept-Language": {"en"}})
+		assert.Equal(t, "en", body)
+	}
+	_, result := get(client, ctx, "/vary", http.Header{"Accept-Language": {"zh"}})
+	assert.Equal(t, "hit", result)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// 认证中间件设置的凭证和身份计入 key
+	atomic.StoreInt32(&calls, 0)
+	client = NewHTTPClient(WithServiceName("dict"), WithIdentity(PrincipalIdentity()),
+		WithCache(CacheConfig{KeyByCredential: true}))
+	alice := hauth.NewContext(ctx, &hauth.Principal{Subject: "alice", Method: hauth.MethodJWT})
+	bob := hauth.NewContext(ctx, &hauth.Principal{Subject: "bob", Method: hauth.MethodJWT})
+	for i := 0; i < 2; i++ {
+		body, _ := get(client, alice, "/user", nil)
+		assert.Equal(t, "alice", body)
+		body, _ = get(client, bob, "/user", nil)
+		assert.Equal(t, "bob", body)
+		body, _ = get(client, ctx, "/user", http.Header{"Authorization": {"Bearer t1"}})
+		assert.Equal(t, "Bearer t1", body)
+		body, _ = get(client, ctx, "/user", http.Header{"Authorization": {"Bearer t2"}})
+		assert.Equal(t, "Bearer t2", body)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestCacheStores(t *testing.T) {
+	ctx := context.Background()
+	lru := NewMemoryCacheStore(2)
+	for _, k := range []string{"a", "b", "c"} {
+		assert.NoError(t, lru.Set(ctx, k, []byte(k), time.Minute))
+	}
+	_, ok, _ := lru.Get(ctx, "a")
+	assert.False(t, ok)
+	v, ok, _ := lru.Get(ctx, "c")
+	assert.True(t, ok)
+	assert.Equal(t, "c", string(v))
+
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	store := NewRedisCacheStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "hclient:cache:dict:")
+	assert.NoError(t, store.Set(ctx, "k", []byte("v"), time.Minute))
+	v, ok, err = store.Get(ctx, "k")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v", string(v))
+	assert.True(t, mr.Exists("hclient:cache:dict:k"))
+	_, ok, err = store.Get(ctx, "missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
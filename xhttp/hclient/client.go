@@ -1,41 +1,146 @@
 package hclient
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/dghubble/sling"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/yituoshiniao/kit/xlog"
 )
 
+// New 创建客户端，负载均衡的 target 错误时不会 panic，请求返回 *NoEndpointError；
+// 负载均衡在第一次请求时启动后台解析和健康检查，New 创建的客户端无法停止，按需创建的客户端使用 NewE
 func New(opts ...Option) *sling.Sling {
-	o := &defaultServerOptions
+	return newClient(newOptions(opts)).Sling
+}
+
+// Client NewE 返回的客户端，不再使用时调用 Close
+type Client struct {
+	*sling.Sling
+	closers []io.Closer
+}
+
+// Close 停止负载均衡的后台解析和健康检查，之后的请求返回 *NoEndpointError
+func (c *Client) Close() error {
+	for _, closer := range c.closers {
+		_ = closer.Close()
+	}
+	return nil
+}
+
+// NewE 与 New 相同，target 无法解析时返回错误
+func NewE(opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return newClient(o), nil
+}
+
+func newClient(o options) *Client {
+	// 与 NewTransport 一样按单次请求计算超时，超时返回 ErrAttemptTimeout，可以被重试
+	client := &http.Client{Transport: Chain(o.httpTransport(), Timeout(o.timeout))}
+	mws, closers := o.middlewares()
+	rt := Chain(RoundTripperFunc(client.Do), mws...)
+
+	// 负载均衡时由 BalancerDoer 替换 host
+	base := o.target
+	if isResolverTarget(base) || (base == "" && o.balancer != nil) {
+		base = "http://" + o.serviceName
+	}
+
+	// JsonDecoder 在 failureV 为 *herror.Error 时返回对端的结构化错误
+	decoder := JsonDecoder{logicCodeGuard: o.logicCodeGuard, envelope: o.envelope}
+	s := sling.New().ResponseDecoder(decoder).Base(base).Doer(doerFunc(rt.RoundTrip))
+	return &Client{Sling: s, closers: closers}
+}
+
+func newOptions(opts []Option) options {
+	o := defaultServerOptions
 	opts = append(opts, checkServiceName())
 	for _, opt := range opts {
-		opt(o)
+		opt(&o)
 	}
+	return o
+}
+
+// validate 检查负载均衡的 target 能否解析
+func (o options) validate() error {
+	if o.balancer != nil && o.balancer.Resolver != nil {
+		return nil
+	}
+	if o.balancer != nil || isResolverTarget(o.target) {
+		_, err := NewResolver(o.target)
+		return err
+	}
+	return nil
+}
 
-	o.transport.(*http.Transport).TLSClientConfig = o.tlsConfig
+func newBalancerDoer(doer sling.Doer, o options) (*BalancerDoer, error) {
+	var conf BalancerConfig
+	if o.balancer != nil {
+		conf = *o.balancer
+	}
+	if conf.HealthCheck != nil && conf.HealthCheck.Client == nil {
+		hc := *conf.HealthCheck
+		hc.Client = &http.Client{Transport: o.transport}
+		conf.HealthCheck = &hc
+	}
+	return NewBalancerDoer(doer, o.target, conf)
+}
 
-	client := &http.Client{Transport: o.transport, Timeout: o.timeout}
+// errClientClosed Close 之后的请求
+var errClientClosed = errors.New("客户端已关闭")
 
-	var doer sling.Doer
+// lazyBalancer 第一次请求时才创建 BalancerDoer，创建失败时每次请求返回 *NoEndpointError
+type lazyBalancer struct {
+	next sling.Doer
+	o    options
 
-	// 先日志 修复 cancel 无法被记录情况
-	doer = LogDoer{doer: client, durationFunc: o.durationFunc}
-	doer = TraceDoer{doer: doer, operationName: o.serviceName}
+	mu   sync.Mutex
+	done bool
+	b    *BalancerDoer
+	err  error
+}
 
-	if o.metrics {
-		doer = MetricsDoer{doer: doer}
+func (l *lazyBalancer) get() (*BalancerDoer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.done {
+		l.done = true
+		l.b, l.err = newBalancerDoer(l.next, l.o)
+		if l.err != nil {
+			xlog.S(context.Background()).Errorw("创建负载均衡失败", "serviceName", l.o.serviceName, "target", l.o.target, "err", l.err)
+		}
 	}
+	return l.b, l.err
+}
 
-	if o.statusCodeGuard {
-		doer = StatusCodeGuardDoer{doer: doer}
+func (l *lazyBalancer) Do(req *http.Request) (*http.Response, error) {
+	b, err := l.get()
+	if err != nil {
+		return nil, &NoEndpointError{Target: l.o.target, Err: err}
 	}
+	return b.Do(req)
+}
 
-	// return sling.New().ResponseDecoder(JsonDecoder{logicCodeGuard: o.logicCodeGuard}).Base(o.target).Doer(doer)
-	return sling.New().Base(o.target).Doer(doer)
+func (l *lazyBalancer) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.b != nil {
+		l.b.Close()
+		l.b = nil
+	}
+	l.done = true
+	l.err = errClientClosed
+	return nil
 }
 
 // Deprecated
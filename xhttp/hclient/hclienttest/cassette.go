@@ -0,0 +1,298 @@
+package hclienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/yituoshiniao/kit/xhttp/hclient"
+)
+
+// Mode Recorder 的模式
+type Mode int
+
+const (
+	// ModeAuto 录像文件存在时回放，否则录制
+	ModeAuto Mode = iota
+	// ModeRecord 发送真实的请求并覆盖录像文件
+	ModeRecord
+	// ModeReplay 只回放，没有匹配的录像时返回错误
+	ModeReplay
+)
+
+// Redacted 脱敏后的值
+const Redacted = "[REDACTED]"
+
+// DefaultRedactHeaders 默认脱敏的请求头和响应头
+var DefaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Signature"}
+
+// DefaultRedactFields 默认脱敏的 JSON 字段和 query 参数
+var DefaultRedactFields = []string{"password", "secret", "client_secret", "token", "access_token", "refresh_token"}
+
+// Interaction 一次请求和响应
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Cassette 录像文件的内容
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Recorder 录制真实的请求写入录像文件，或者从录像文件回放，写入前按 RecorderOption 脱敏
+type Recorder struct {
+	path          string
+	mode          Mode
+	transport     http.RoundTripper
+	redactHeaders []string
+	redactFields  map[string]bool
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     map[*Interaction]bool
+}
+
+type RecorderOption func(*Recorder)
+
+// WithRedactHeaders 追加需要脱敏的请求头和响应头
+func WithRedactHeaders(headers ...string) RecorderOption {
+	return func(r *Recorder) {
+		r.redactHeaders = append(r.redactHeaders, headers...)
+	}
+}
+
+// WithRedactFields 追加需要脱敏的 JSON 字段和 query 参数
+func WithRedactFields(fields ...string) RecorderOption {
+	return func(r *Recorder) {
+		for _, f := range fields {
+			r.redactFields[strings.ToLower(f)] = true
+		}
+	}
+}
+
+// WithRealTransport 录制时使用的 http.RoundTripper，默认 http.DefaultTransport
+func WithRealTransport(rt http.RoundTripper) RecorderOption {
+	return func(r *Recorder) {
+		r.transport = rt
+	}
+}
+
+// NewRecorder 回放时读取 path，录制时调用 Close 写入 path
+func NewRecorder(path string, mode Mode, opts ...RecorderOption) (*Recorder, error) {
+	r := &Recorder{
+		path:          path,
+		mode:          mode,
+		transport:     http.DefaultTransport,
+		redactHeaders: append([]string(nil), DefaultRedactHeaders...),
+		redactFields:  map[string]bool{},
+		used:          map[*Interaction]bool{},
+	}
+	for _, f := range DefaultRedactFields {
+		r.redactFields[f] = true
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.mode == ModeAuto {
+		r.mode = ModeRecord
+		if _, err := os.Stat(path); err == nil {
+			r.mode = ModeReplay
+		}
+	}
+	if r.mode == ModeReplay {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &r.cassette); err != nil {
+			return nil, fmt.Errorf("hclienttest: 解析录像文件 %s 失败: %w", path, err)
+		}
+	}
+	return r, nil
+}
+
+// Option 使用 Recorder 发送请求
+func (r *Recorder) Option() hclient.Option {
+	return hclient.WithTransport(r)
+}
+
+// Recording 是否在录制
+func (r *Recorder) Recording() bool {
+	return r.mode == ModeRecord
+}
+
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	return r.RoundTrip(req)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+	}
+	recorded := r.redactRequest(req, body)
+
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded)
+	}
+
+	real := req.Clone(req.Context())
+	real.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	resp, err := r.transport.RoundTrip(real)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: r.redactHeader(resp.Header),
+			Body:   r.redactBody(resp.Header, respBody),
+		},
+	})
+	r.mu.Unlock()
+	return newResponse(req, resp.StatusCode, resp.Header, respBody), nil
+}
+
+// replay 按 method、path?query 和请求体查找第一个未使用的录像，不比较 host，录制时的地址可以与回放时不同
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	uri := requestURI(recorded.URL)
+	for _, it := range r.cassette.Interactions {
+		if r.used[it] || it.Request.Method != recorded.Method || requestURI(it.Request.URL) != uri {
+			continue
+		}
+		if it.Request.Body != recorded.Body && !jsonEqual([]byte(it.Request.Body), []byte(recorded.Body)) {
+			continue
+		}
+		r.used[it] = true
+		return newResponse(req, it.Response.Status, it.Response.Header, []byte(it.Response.Body)), nil
+	}
+	return nil, fmt.Errorf("hclienttest: 录像 %s 中没有 %s %s", r.path, recorded.Method, recorded.URL)
+}
+
+func requestURI(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.RequestURI()
+}
+
+// Close 录制时写入录像文件
+func (r *Recorder) Close() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, b, 0644)
+}
+
+func (r *Recorder) redactRequest(req *http.Request, body []byte) RecordedRequest {
+	u := *req.URL
+	q := u.Query()
+	for k := range q {
+		if r.redactFields[strings.ToLower(k)] {
+			q.Set(k, Redacted)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return RecordedRequest{
+		Method: req.Method,
+		URL:    u.String(),
+		Header: r.redactHeader(req.Header),
+		Body:   r.redactBody(req.Header, body),
+	}
+}
+
+func (r *Recorder) redactHeader(header http.Header) http.Header {
+	h := header.Clone()
+	for _, k := range r.redactHeaders {
+		if h.Get(k) != "" {
+			h.Set(k, Redacted)
+		}
+	}
+	return h
+}
+
+// redactBody JSON 和表单请求体中的字段脱敏
+func (r *Recorder) redactBody(header http.Header, body []byte) string {
+	contentType := header.Get("Content-Type")
+	switch {
+	case strings.Contains(contentType, "json"):
+		var v interface{}
+		if json.Unmarshal(body, &v) != nil {
+			return string(body)
+		}
+		b, _ := json.Marshal(r.redactJSON(v))
+		return string(b)
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return string(body)
+		}
+		for k := range form {
+			if r.redactFields[strings.ToLower(k)] {
+				form.Set(k, Redacted)
+			}
+		}
+		return form.Encode()
+	}
+	return string(body)
+}
+
+func (r *Recorder) redactJSON(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, child := range vv {
+			if r.redactFields[strings.ToLower(k)] {
+				vv[k] = Redacted
+				continue
+			}
+			vv[k] = r.redactJSON(child)
+		}
+	case []interface{}:
+		for i, child := range vv {
+			vv[i] = r.redactJSON(child)
+		}
+	}
+	return v
+}
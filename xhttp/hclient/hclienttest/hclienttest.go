@@ -0,0 +1,234 @@
+// Package hclienttest 测试使用 hclient 的代码，Mock 按声明的期望返回响应，Recorder 录制和回放真实的请求
+package hclienttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yituoshiniao/kit/xhttp/hclient"
+)
+
+// Mock 代替 http.Transport，请求仍然经过 hclient 的全部中间件，
+// 通过 hclient.New(..., mock.Option()) 使用，测试结束时调用 ExpectationsWereMet
+type Mock struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	unexpected   []string
+}
+
+func NewMock() *Mock {
+	return &Mock{}
+}
+
+// Option 使用 Mock 发送请求
+func (m *Mock) Option() hclient.Option {
+	return hclient.WithTransport(m)
+}
+
+// Expect 添加期望的请求，path 不包括 query，默认只匹配一次
+func (m *Mock) Expect(method, path string) *Expectation {
+	e := &Expectation{method: method, path: path, query: map[string]string{}, header: http.Header{}, times: 1, status: http.StatusOK, respHeader: http.Header{}}
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+	return e
+}
+
+// ExpectationsWereMet 所有期望的请求都已发生，且没有未声明的请求
+func (m *Mock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var msgs []string
+	for _, e := range m.expectations {
+		if e.calls < e.times {
+			msgs = append(msgs, fmt.Sprintf("期望 %s 调用 %d 次，实际 %d 次", e, e.times, e.calls))
+		}
+	}
+	for _, u := range m.unexpected {
+		msgs = append(msgs, "未声明的请求 "+u)
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("hclienttest: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+func (m *Mock) Do(req *http.Request) (*http.Response, error) {
+	return m.RoundTrip(req)
+}
+
+func (m *Mock) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+	}
+
+	m.mu.Lock()
+	var matched *Expectation
+	for _, e := range m.expectations {
+		if e.calls < e.times && e.match(req, body) {
+			matched = e
+			e.calls++
+			break
+		}
+	}
+	if matched == nil {
+		desc := req.Method + " " + req.URL.RequestURI()
+		m.unexpected = append(m.unexpected, desc)
+		m.mu.Unlock()
+		return nil, fmt.Errorf("hclienttest: 没有匹配的期望 %s", desc)
+	}
+	m.mu.Unlock()
+
+	if matched.delay > 0 {
+		select {
+		case <-time.After(matched.delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if matched.err != nil {
+		return nil, matched.err
+	}
+	return newResponse(req, matched.status, matched.respHeader, matched.respBody), nil
+}
+
+// Expectation 期望的请求和返回的响应
+type Expectation struct {
+	method string
+	path   string
+	query  map[string]string
+	header http.Header
+	body   func(body []byte) bool
+	times  int
+	calls  int
+
+	status     int
+	respHeader http.Header
+	respBody   []byte
+	delay      time.Duration
+	err        error
+}
+
+func (e *Expectation) String() string {
+	return e.method + " " + e.path
+}
+
+// WithQuery 请求的 query 参数
+func (e *Expectation) WithQuery(key, value string) *Expectation {
+	e.query[key] = value
+	return e
+}
+
+// WithHeader 请求头
+func (e *Expectation) WithHeader(key, value string) *Expectation {
+	e.header.Set(key, value)
+	return e
+}
+
+// WithJSONBody 请求体与 v 序列化后的 JSON 等价，忽略字段顺序和空白
+func (e *Expectation) WithJSONBody(v interface{}) *Expectation {
+	want, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return e.WithBody(func(body []byte) bool {
+		return jsonEqual(want, body)
+	})
+}
+
+// WithBody 自定义请求体匹配
+func (e *Expectation) WithBody(match func(body []byte) bool) *Expectation {
+	e.body = match
+	return e
+}
+
+// Times 期望的调用次数
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// WillReturnJSON 返回 JSON 响应
+func (e *Expectation) WillReturnJSON(status int, v interface{}) *Expectation {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	e.respHeader.Set("Content-Type", "application/json; charset=utf-8")
+	return e.WillReturnBody(status, b)
+}
+
+// WillReturnBody 返回任意响应体
+func (e *Expectation) WillReturnBody(status int, body []byte) *Expectation {
+	e.status = status
+	e.respBody = body
+	return e
+}
+
+// WillReturnHeader 响应头
+func (e *Expectation) WillReturnHeader(key, value string) *Expectation {
+	e.respHeader.Set(key, value)
+	return e
+}
+
+// WillDelay 延迟返回响应，请求的 ctx 取消时提前返回
+func (e *Expectation) WillDelay(d time.Duration) *Expectation {
+	e.delay = d
+	return e
+}
+
+// WillReturnError 返回连接错误等
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+func (e *Expectation) match(req *http.Request, body []byte) bool {
+	if req.Method != e.method || req.URL.Path != e.path {
+		return false
+	}
+	q := req.URL.Query()
+	for k, v := range e.query {
+		if q.Get(k) != v {
+			return false
+		}
+	}
+	for k := range e.header {
+		if req.Header.Get(k) != e.header.Get(k) {
+			return false
+		}
+	}
+	return e.body == nil || e.body(body)
+}
+
+func jsonEqual(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
+
+func newResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
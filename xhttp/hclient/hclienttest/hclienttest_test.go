@@ -0,0 +1,141 @@
+package hclienttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/sling"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhttp/hclient"
+)
+
+type user struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestMock(t *testing.T) {
+	scenarios := []struct {
+		desc   string
+		mockFn func(m *Mock)
+		testFn func(t *testing.T, client *sling.Sling)
+		unmet  bool
+	}{
+		{
+			desc: "JSON 请求和响应",
+			mockFn: func(m *Mock) {
+				m.Expect(http.MethodPost, "/users").
+					WithQuery("source", "app").
+					WithJSONBody(map[string]interface{}{"name": "tom"}).
+					WillReturnJSON(http.StatusOK, map[string]interface{}{"code": 0, "data": user{Id: 1, Name: "tom"}})
+			},
+			testFn: func(t *testing.T, client *sling.Sling) {
+				var u user
+				_, err := client.New().Post("/users?source=app").BodyJSON(map[string]string{"name": "tom"}).ReceiveSuccess(&u)
+				assert.NoError(t, err)
+				assert.Equal(t, user{Id: 1, Name: "tom"}, u)
+			},
+		},
+		{
+			desc: "返回错误和延迟",
+			mockFn: func(m *Mock) {
+				m.Expect(http.MethodGet, "/users/1").WillReturnError(errors.New("connection refused"))
+				m.Expect(http.MethodGet, "/users/2").WillDelay(time.Second)
+			},
+			testFn: func(t *testing.T, client *sling.Sling) {
+				_, err := client.New().Get("/users/1").ReceiveSuccess(nil)
+				assert.Contains(t, err.Error(), "connection refused")
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				req, _ := client.New().Get("/users/2").Request()
+				_, err = client.Do(req.WithContext(ctx), nil, nil)
+				assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+			},
+		},
+		{
+			desc: "未满足的期望和未声明的请求",
+			mockFn: func(m *Mock) {
+				m.Expect(http.MethodGet, "/users").Times(2)
+			},
+			testFn: func(t *testing.T, client *sling.Sling) {
+				_, err := client.New().Get("/users").ReceiveSuccess(nil)
+				assert.NoError(t, err)
+				_, err = client.New().Delete("/users").ReceiveSuccess(nil)
+				assert.Error(t, err)
+			},
+			unmet: true,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			m := NewMock()
+			scenario.mockFn(m)
+			client := hclient.New(hclient.WithServiceName("user"), hclient.WithTarget("http://user"),
+				hclient.WithEnvelope(hclient.EnvelopeConfig{}), m.Option())
+			scenario.testFn(t, client)
+			if scenario.unmet {
+				assert.Error(t, m.ExpectationsWereMet())
+			} else {
+				assert.NoError(t, m.ExpectationsWereMet())
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Set-Cookie", "session=abc")
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"access_token": "real-token", "user": user{Id: 1, Name: "tom"}})
+	}))
+	path := filepath.Join(t.TempDir(), "cassettes", "login.json")
+
+	login := func(r *Recorder) (map[string]interface{}, error) {
+		client := hclient.New(hclient.WithServiceName("passport"), hclient.WithTarget(ts.URL), r.Option())
+		var ret map[string]interface{}
+		_, err := client.New().Post("/login?token=t1").Set("Authorization", "Bearer secret").
+			BodyJSON(map[string]string{"name": "tom", "password": "123456"}).ReceiveSuccess(&ret)
+		return ret, err
+	}
+
+	r, err := NewRecorder(path, ModeAuto)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, r.Recording())
+	ret, err := login(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "real-token", ret["access_token"])
+	assert.NoError(t, r.Close())
+	ts.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	for _, secret := range []string{"real-token", "123456", "Bearer secret", "session=abc", "t1"} {
+		assert.False(t, strings.Contains(string(b), secret), secret)
+	}
+
+	// 服务关闭后从录像回放
+	r, err = NewRecorder(path, ModeAuto)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, r.Recording())
+	ret, err = login(r)
+	assert.NoError(t, err)
+	assert.Equal(t, Redacted, ret["access_token"])
+	assert.Equal(t, "tom", ret["user"].(map[string]interface{})["name"])
+
+	_, err = login(r)
+	assert.Error(t, err)
+}
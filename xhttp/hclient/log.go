@@ -2,10 +2,14 @@ package hclient
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dghubble/sling"
@@ -15,9 +19,64 @@ import (
 	"github.com/yituoshiniao/kit/xlog"
 )
 
+// BodyLogConfig 请求体和响应体日志，边读边记录前 MaxBytes 字节，不会把完整的 body 读到内存
+type BodyLogConfig struct {
+	// 最多记录的字节数，默认 4KB，小于 0 时不记录
+	MaxBytes int
+	// 不记录 body 的 Content-Type 前缀，body 原样返回，默认 DefaultSkipContentTypes
+	SkipContentTypes []string
+}
+
+// DefaultSkipContentTypes 文件下载、上传和流式响应不记录 body
+var DefaultSkipContentTypes = []string{
+	"application/octet-stream",
+	"application/zip",
+	"application/pdf",
+	"image/",
+	"audio/",
+	"video/",
+	"multipart/",
+	"text/event-stream",
+}
+
+func (c BodyLogConfig) withDefaults() BodyLogConfig {
+	if c.MaxBytes == 0 {
+		c.MaxBytes = 4 << 10
+	}
+	if c.SkipContentTypes == nil {
+		c.SkipContentTypes = DefaultSkipContentTypes
+	}
+	return c
+}
+
+func (c BodyLogConfig) skip(ctx context.Context, contentType string) bool {
+	if c.MaxBytes < 0 {
+		return true
+	}
+	if skip, _ := ctx.Value(bodyLogCtxKey{}).(bool); skip {
+		return true
+	}
+	contentType = strings.ToLower(contentType)
+	for _, t := range c.SkipContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+type bodyLogCtxKey struct{}
+
+// NewCtxWithoutBodyLog 单个请求不记录请求体和响应体
+func NewCtxWithoutBodyLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bodyLogCtxKey{}, true)
+}
+
+// LogDoer 收到响应时立即记录状态码和耗时，响应体需要记录时，在响应体读完或关闭后另外记录一条"接收响应体"日志
 type LogDoer struct {
 	doer         sling.Doer
 	durationFunc DurationToField
+	bodyLog      BodyLogConfig
 }
 
 const (
@@ -27,126 +86,174 @@ const (
 
 func (l LogDoer) Do(req *http.Request) (resp *http.Response, err error) {
 	startTime := time.Now()
-	var reqBody []byte
-	var respBody []byte
-	if req.Body != nil {
-		b, bErr := ioutil.ReadAll(req.Body)
-		if bErr != nil {
-			zap.L().Error("读取req.body失败", zap.Error(bErr))
-			return nil, bErr
-		} else {
-			reqBody = b
-			req.Body = ioutil.NopCloser(bytes.NewBuffer(b))
-		}
-	}
+	conf := l.bodyLog.withDefaults()
 
 	reqFs := xlog.ExtFields(req.Context())
 	reqFs = append(reqFs, zap.String("method", req.Method), zap.String("url", req.URL.String()), zap.Reflect("header", req.Header))
-	if len(reqBody) > 0 && !xlog.IsSecrecyMsg(string(reqBody)) {
-		if strings.Contains(req.Header.Get(ContentTypeJson), HeaderJSON) {
-			reqFs = append(reqFs, zap.Object("reqBody", &jsonMarshaler{b: reqBody}))
-		} else {
-			reqBody, err := url.QueryUnescape(string(reqBody))
-			if err != nil {
-				zap.L().Error("QueryUnescape-错误", zap.Error(err))
+
+	// 可以重复读取的请求体在发送前记录，否则在发送时记录
+	var reqCapture *captureBody
+	if req.Body != nil && req.Body != http.NoBody && !conf.skip(req.Context(), req.Header.Get(ContentTypeJson)) {
+		if req.GetBody != nil {
+			if body, bErr := req.GetBody(); bErr == nil {
+				b, _ := ioutil.ReadAll(io.LimitReader(body, int64(conf.MaxBytes)+1))
+				_ = body.Close()
+				truncated := len(b) > conf.MaxBytes
+				if truncated {
+					b = b[:conf.MaxBytes]
+				}
+				reqFs = append(reqFs, reqBodyFields(req.Header, b, truncated)...)
 			}
-			reqFs = append(reqFs, zap.String("reqBody", reqBody))
+		} else {
+			reqCapture = newCaptureBody(req.Body, conf.MaxBytes, nil)
+			req.Body = reqCapture
 		}
 	}
 
 	zap.L().Debug("发送请求[http.client]", reqFs...)
 
 	resp, err = l.doer.Do(req)
-
-	if resp != nil && resp.Body != nil {
-		b, bErr := ioutil.ReadAll(resp.Body)
-		if bErr != nil {
-			zap.L().Error("读取req.body失败", zap.Error(bErr))
-			return nil, bErr
-		} else {
-			respBody = b
-			resp.Body = ioutil.NopCloser(bytes.NewBuffer(b))
-		}
-	}
+	duration := time.Since(startTime)
 
 	level := zap.DebugLevel
 	if err != nil || (resp != nil && (resp.StatusCode < 200 || 299 < resp.StatusCode)) {
 		level = zap.ErrorLevel
 	}
 
-	statusF := zap.Skip()
-	statusCodeF := zap.Skip()
-	contentLengthF := zap.Skip()
-	respF := zap.Skip()
-	// path := zap.Skip()
 	path := zap.String("path", req.URL.Path)
 	rawQuery := zap.String("rawQuery", req.URL.RawQuery)
 	tmpRawQuery, errUrl := url.QueryUnescape(req.URL.RawQuery)
 	if errUrl != nil {
-		xlog.S(req.Context()).Errorw("url.QueryUnescape错误", "err", err)
+		xlog.S(req.Context()).Errorw("url.QueryUnescape错误", "err", errUrl)
 	}
 	if errUrl == nil {
 		rawQuery = zap.String("rawQuery", tmpRawQuery)
 	}
 
-	if resp != nil {
-		statusF = zap.String("status", resp.Status)
-		statusCodeF = zap.Int("statusCode", resp.StatusCode)
-		contentLengthF = zap.Int64("contentLength", resp.ContentLength)
-
-	}
-
-	if resp != nil && strings.Contains(resp.Header.Get(ContentTypeJson), HeaderJSON) {
-		respF = zap.Object("resp", &jsonMarshaler{b: respBody})
-	} else {
-		// respF = zap.Object("resp", &jsonMarshaler{b: respBody})
-		// 错误响应处理
-		// respF = zap.ByteString("resp", respBody)
-
-		if isExcludeRoutePath(req.URL.Path, excludeRoutePath) {
-			respF = zap.Int("respLen", len(respBody))
-		} else {
-			respF = zap.ByteString("respString", respBody)
-		}
-	}
-
 	respFs := xlog.ExtFields(req.Context())
-
 	respFs = append(respFs,
 		zap.Error(err),
-		statusF,
-		statusCodeF,
-		contentLengthF,
-		l.durationFunc(time.Since(startTime)),
-		respF,
+		l.durationFunc(duration),
 		path,
 		rawQuery,
 	)
 	if resp != nil {
-		respFs = append(respFs, zap.Reflect("header", resp.Header))
+		respFs = append(respFs,
+			zap.String("status", resp.Status),
+			zap.Int("statusCode", resp.StatusCode),
+			zap.Int64("contentLength", resp.ContentLength),
+			zap.Reflect("header", resp.Header),
+		)
+	}
+	if attempt := attemptFromContext(req.Context()); attempt > 0 {
+		respFs = append(respFs, zap.Int("attempt", attempt))
+	}
+	if reqCapture != nil {
+		b, truncated, _ := reqCapture.snapshot()
+		respFs = append(respFs, reqBodyFields(req.Header, b, truncated)...)
 	}
 
 	zap.L().Check(level, "接收响应[http.client]").Write(respFs...)
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody ||
+		conf.skip(req.Context(), resp.Header.Get(ContentTypeJson)) {
+		return
+	}
 
+	resp.Body = newCaptureBody(resp.Body, conf.MaxBytes, func(c *captureBody) {
+		b, truncated, n := c.snapshot()
+		bodyFs := xlog.ExtFields(req.Context())
+		bodyFs = append(bodyFs, path, zap.Int("statusCode", resp.StatusCode))
+		bodyFs = append(bodyFs, respBodyFields(resp.Header, b, truncated)...)
+		bodyFs = append(bodyFs, zap.Int64("respLen", n))
+		zap.L().Check(level, "接收响应体[http.client]").Write(bodyFs...)
+	})
 	return
 }
 
-var (
-	excludeRoutePath []string = []string{
-		"/file/public/download",
+func reqBodyFields(header http.Header, b []byte, truncated bool) []zap.Field {
+	if len(b) == 0 || xlog.IsSecrecyMsg(string(b)) {
+		return nil
 	}
-)
+	var fs []zap.Field
+	if strings.Contains(header.Get(ContentTypeJson), HeaderJSON) && !truncated && json.Valid(b) {
+		fs = append(fs, zap.Object("reqBody", &jsonMarshaler{b: b}))
+	} else {
+		reqBody, err := url.QueryUnescape(string(b))
+		if err != nil {
+			reqBody = string(b)
+		}
+		fs = append(fs, zap.String("reqBody", reqBody))
+	}
+	if truncated {
+		fs = append(fs, zap.Bool("reqTruncated", true))
+	}
+	return fs
+}
 
-func isExcludeRoutePath(path string, excludeRoutePath []string) bool {
-	if path == "" {
-		return false
+func respBodyFields(header http.Header, b []byte, truncated bool) []zap.Field {
+	var fs []zap.Field
+	if strings.Contains(header.Get(ContentTypeJson), HeaderJSON) && !truncated && json.Valid(b) {
+		fs = append(fs, zap.Object("resp", &jsonMarshaler{b: b}))
+	} else {
+		fs = append(fs, zap.ByteString("respString", b))
 	}
-	for _, v := range excludeRoutePath {
-		if v == path {
-			return true
+	if truncated {
+		fs = append(fs, zap.Bool("respTruncated", true))
+	}
+	return fs
+}
+
+// captureBody 读取时保留前 limit 字节，读完或关闭时调用一次 done
+type captureBody struct {
+	io.ReadCloser
+	limit int
+	done  func(c *captureBody)
+	once  sync.Once
+
+	// 请求体由 transport 的 goroutine 读取
+	mu  sync.Mutex
+	buf bytes.Buffer
+	n   int64
+}
+
+func newCaptureBody(body io.ReadCloser, limit int, done func(c *captureBody)) *captureBody {
+	return &captureBody{ReadCloser: body, limit: limit, done: done}
+}
+
+func (c *captureBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.mu.Lock()
+	c.n += int64(n)
+	if rest := c.limit - c.buf.Len(); rest > 0 {
+		if n < rest {
+			rest = n
 		}
+		c.buf.Write(p[:rest])
 	}
-	return false
+	c.mu.Unlock()
+	if err == io.EOF {
+		c.finish()
+	}
+	return n, err
+}
+
+func (c *captureBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.finish()
+	return err
+}
+
+func (c *captureBody) finish() {
+	if c.done != nil {
+		c.once.Do(func() { c.done(c) })
+	}
+}
+
+// snapshot 返回已记录的内容、是否截断和已读取的总字节数
+func (c *captureBody) snapshot() ([]byte, bool, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.buf.Bytes()...), c.n > int64(c.buf.Len()), c.n
 }
 
 type jsonMarshaler struct {
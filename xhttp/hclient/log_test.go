@@ -0,0 +1,95 @@
+package hclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogDoerBody(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	file := bytes.Repeat([]byte("f"), 1<<20)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			rw.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = rw.Write(file)
+		case "/echo":
+			rw.Header().Set("Content-Type", "application/json")
+			_, _ = io.Copy(rw, r.Body)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewHTTPClient(WithServiceName("file"), WithBodyLog(BodyLogConfig{MaxBytes: 8}))
+	last := func(msg string) map[string]interface{} {
+		entries := logs.FilterMessage(msg).All()
+		if len(entries) == 0 {
+			return nil
+		}
+		fields, _ := entries[len(entries)-1].ContextMap()["xlog"].(map[string]interface{})
+		return fields
+	}
+	lastResp := func() map[string]interface{} {
+		return last("接收响应[http.client]")
+	}
+	lastBody := func() map[string]interface{} {
+		return last("接收响应体[http.client]")
+	}
+
+	// 文件下载不经过缓冲，原样返回
+	resp, err := client.Get(ts.URL + "/download")
+	if assert.NoError(t, err) {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, file, b)
+		assert.Equal(t, int64(http.StatusOK), lastResp()["statusCode"])
+		assert.Nil(t, lastBody())
+	}
+
+	// 超过 MaxBytes 时截断，请求体在发送时记录
+	body := `{"name":"a-very-long-name"}`
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/echo", ioutil.NopCloser(strings.NewReader(body)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = client.Do(req)
+	if assert.NoError(t, err) {
+		// 状态码和耗时在收到响应时立即记录，不等待读取响应体
+		fields := lastResp()
+		assert.Equal(t, int64(http.StatusOK), fields["statusCode"])
+		assert.Equal(t, `{"name":`, fields["reqBody"])
+		assert.Equal(t, true, fields["reqTruncated"])
+		assert.Nil(t, lastBody())
+
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, body, string(b))
+
+		fields = lastBody()
+		assert.Equal(t, `{"name":`, fields["respString"])
+		assert.Equal(t, true, fields["respTruncated"])
+		assert.Equal(t, int64(len(body)), fields["respLen"])
+		assert.Equal(t, "/echo", fields["path"])
+	}
+
+	// 单个请求关闭 body 日志
+	req, _ = http.NewRequestWithContext(NewCtxWithoutBodyLog(context.Background()), http.MethodPost, ts.URL+"/echo", strings.NewReader("{}"))
+	resp, err = client.Do(req)
+	if assert.NoError(t, err) {
+		_, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NotContains(t, lastResp(), "reqBody")
+		assert.Equal(t, 1, logs.FilterMessage("接收响应体[http.client]").Len())
+	}
+}
@@ -54,3 +54,67 @@ func (t MetricsDoer) Do(req *http.Request) (resp *http.Response, err error) {
 	).Add(1)
 	return
 }
+
+// HttpClientBreakerCounter 熔断器状态变化、熔断拒绝和并发限制拒绝的次数
+var HttpClientBreakerCounter *kitprometheus.Counter
+
+const (
+	HttpClientBreakerCounterName  string = "name"
+	HttpClientBreakerCounterEvent string = "event"
+)
+
+func InitHttpClientBreakerMetrics() {
+	HttpClientBreakerCounter = kitprometheus.NewCounterFrom(
+		stdprometheus.CounterOpts{
+			Namespace: "http_client",
+			Name:      "breaker_count",
+			Help:      "http client circuit breaker and bulkhead events",
+		},
+		[]string{
+			HttpClientBreakerCounterName,
+			HttpClientBreakerCounterEvent,
+		})
+}
+
+// countBreaker 没有调用 InitHttpClientBreakerMetrics 时不统计
+func countBreaker(name, event string) {
+	if HttpClientBreakerCounter == nil {
+		return
+	}
+	HttpClientBreakerCounter.With(
+		HttpClientBreakerCounterName, name,
+		HttpClientBreakerCounterEvent, event,
+	).Add(1)
+}
+
+// HttpClientCacheCounter 响应缓存的结果，result 为 hit、stale、revalidated、miss 或 bypass
+var HttpClientCacheCounter *kitprometheus.Counter
+
+const (
+	HttpClientCacheCounterName   string = "name"
+	HttpClientCacheCounterResult string = "result"
+)
+
+func InitHttpClientCacheMetrics() {
+	HttpClientCacheCounter = kitprometheus.NewCounterFrom(
+		stdprometheus.CounterOpts{
+			Namespace: "http_client",
+			Name:      "cache_count",
+			Help:      "http client response cache results",
+		},
+		[]string{
+			HttpClientCacheCounterName,
+			HttpClientCacheCounterResult,
+		})
+}
+
+// countCache 没有调用 InitHttpClientCacheMetrics 时不统计
+func countCache(name, result string) {
+	if HttpClientCacheCounter == nil {
+		return
+	}
+	HttpClientCacheCounter.With(
+		HttpClientCacheCounterName, name,
+		HttpClientCacheCounterResult, result,
+	).Add(1)
+}
@@ -13,10 +13,9 @@ import (
 var defaultServerOptions = options{
 	timeout:         3 * time.Second,
 	statusCodeGuard: false,
-	logicCodeGuard:  true,
+	logicCodeGuard:  false,
 	durationFunc:    DurationToTimeMillisField,
 	transport:       http.DefaultTransport,
-	tlsConfig:       &tls.Config{},
 }
 
 type Option func(*options)
@@ -35,8 +34,20 @@ type options struct {
 	tlsConfig       *tls.Config
 	transport       http.RoundTripper
 	metrics         bool
+	retry           *RetryConfig
+	breaker         *BreakerConfig
+	bulkhead        *BulkheadConfig
+	envelope        *EnvelopeConfig
+	statusPredicate func(statusCode int) bool
+	balancer        *BalancerConfig
+	bodyLog         BodyLogConfig
+	extra           []Middleware
+	cache           *CacheConfig
 }
 
+// WithTarget 下游地址，如 http://passport:8080，也可以是 static://a:80,b:80、dns://passport:8080、
+// file:///etc/passport/endpoints，此时开启客户端负载均衡；file 按 RefreshInterval 轮询读取文件，不监听文件变化，
+// 地址在第一次请求时解析，解析失败时请求返回 *NoEndpointError，需要在启动时检查的使用 NewE
 func WithTarget(target string) Option {
 	return func(o *options) {
 		o.target = target
@@ -49,18 +60,53 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// EnableStatusCodeGuard 开启状态码检查，4xx、5xx 返回 *ErrStatus
 func EnableStatusCodeGuard() Option {
 	return func(o *options) {
 		o.statusCodeGuard = true
 	}
 }
 
+// WithStatusCodePredicate 开启状态码检查，predicate 返回 true 的状态码返回 *ErrStatus，默认 4xx、5xx
+func WithStatusCodePredicate(predicate func(statusCode int) bool) Option {
+	return func(o *options) {
+		o.statusCodeGuard = true
+		o.statusPredicate = predicate
+	}
+}
+
+// DisableStatusCodeGuard 关闭状态码检查
+//
+// Deprecated: 状态码检查默认关闭，需要时使用 EnableStatusCodeGuard 开启
 func DisableStatusCodeGuard() Option {
+	return func(o *options) {
+		o.statusCodeGuard = false
+	}
+}
+
+// EnableLogicCodeGuard 检查实现了 Response 接口的响应中的业务码，不为 0 时 Receive 返回 *LogicCodeError，默认关闭
+func EnableLogicCodeGuard() Option {
+	return func(o *options) {
+		o.logicCodeGuard = true
+	}
+}
+
+// DisableLogicCodeGuard 不检查实现了 Response 接口的响应中的业务码
+func DisableLogicCodeGuard() Option {
 	return func(o *options) {
 		o.logicCodeGuard = false
 	}
 }
 
+// WithEnvelope 按 code/msg/data 结构解析响应，业务码不是成功时返回 *LogicCodeError，
+// 成功时 Receive 的 successV 只接收 data 字段，字段名默认与 hserver 一致
+func WithEnvelope(conf EnvelopeConfig) Option {
+	return func(o *options) {
+		conf = conf.withDefaults()
+		o.envelope = &conf
+	}
+}
+
 func checkServiceName() Option {
 	return func(o *options) {
 		if o.serviceName == "" {
@@ -77,10 +123,29 @@ func WithServiceName(serviceName string) Option {
 
 func WithInsecure() Option {
 	return func(o *options) {
+		if o.tlsConfig == nil {
+			o.tlsConfig = &tls.Config{}
+		} else {
+			o.tlsConfig = o.tlsConfig.Clone()
+		}
 		o.tlsConfig.InsecureSkipVerify = true
 	}
 }
 
+// WithTLSConfig 设置后复制 transport 使用该配置
+func WithTLSConfig(conf *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = conf
+	}
+}
+
+// WithTransport 最内层的 http.RoundTripper，默认 http.DefaultTransport
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = rt
+	}
+}
+
 // WithMetrics 是否采集接口请求
 func WithMetrics(isMetrics bool) Option {
 	return func(o *options) {
@@ -88,6 +153,77 @@ func WithMetrics(isMetrics bool) Option {
 	}
 }
 
+// WithRetry 开启失败重试，只重试幂等方法、带 Idempotency-Key 或通过 NewCtxWithRetry 标记的请求
+func WithRetry(conf RetryConfig) Option {
+	return func(o *options) {
+		o.retry = &conf
+	}
+}
+
+// WithCircuitBreaker 按 serviceName + host 熔断，熔断时返回 *CircuitOpenError
+func WithCircuitBreaker(conf BreakerConfig) Option {
+	return func(o *options) {
+		o.breaker = &conf
+	}
+}
+
+// WithBulkhead 按 serviceName + host 限制并发请求数，超出时返回 *BulkheadFullError
+func WithBulkhead(conf BulkheadConfig) Option {
+	return func(o *options) {
+		o.bulkhead = &conf
+	}
+}
+
+// WithBodyLog 请求体和响应体日志的长度和跳过的 Content-Type，单个请求可以通过 NewCtxWithoutBodyLog 关闭
+func WithBodyLog(conf BodyLogConfig) Option {
+	return func(o *options) {
+		o.bodyLog = conf
+	}
+}
+
+// WithMiddleware 自定义中间件，在日志之后、发送请求之前执行，每次重试都会执行，
+// 中间件设置的请求头不会出现在日志中
+func WithMiddleware(mws ...Middleware) Option {
+	return func(o *options) {
+		o.extra = append(o.extra, mws...)
+	}
+}
+
+// WithTokenSource 设置 Authorization: Bearer <token>
+func WithTokenSource(src TokenSource) Option {
+	return WithMiddleware(BearerToken(src))
+}
+
+// WithHMAC 为请求签名
+func WithHMAC(conf HMACConfig) Option {
+	return WithMiddleware(HMACSign(conf))
+}
+
+// WithAPIKey 设置固定的 API Key 请求头
+func WithAPIKey(header, key string) Option {
+	return WithMiddleware(APIKey(header, key))
+}
+
+// WithIdentity 透传调用方身份，如 WithIdentity(PrincipalIdentity())
+func WithIdentity(fn IdentityFunc) Option {
+	return WithMiddleware(Identity(fn))
+}
+
+// WithCache 按 Cache-Control、ETag、Last-Modified 缓存 GET、HEAD 请求的响应，单个请求可以通过 NewCtxWithCacheTTL 指定缓存时长；
+// 缓存在 WithMiddleware 等认证中间件之后执行，带 Authorization、Cookie 的请求和 private 的响应默认不缓存
+func WithCache(conf CacheConfig) Option {
+	return func(o *options) {
+		o.cache = &conf
+	}
+}
+
+// WithBalancer 开启客户端负载均衡，地址来自 conf.Resolver 或 WithTarget 的 static://、dns://、file://
+func WithBalancer(conf BalancerConfig) Option {
+	return func(o *options) {
+		o.balancer = &conf
+	}
+}
+
 // Deprecated
 // 不再需要，调用的地方直接使用 opentracing.GlobalTracer()
 func WithTracer(tracer opentracing.Tracer) Option {
@@ -0,0 +1,136 @@
+package hclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Resolver 解析下游地址，返回 host:port 列表
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// ResolverFunc 函数形式的 Resolver
+type ResolverFunc func(ctx context.Context) ([]string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// 支持服务发现的 target 协议
+const (
+	// static://a:80,b:80
+	SchemeStatic = "static"
+	// dns://svc:8080，按 BalancerConfig.RefreshInterval 重新解析
+	SchemeDNS = "dns"
+	// file:///etc/endpoints，每行一个 host:port，# 开头为注释，按 BalancerConfig.RefreshInterval 轮询读取，不监听文件变化
+	SchemeFile = "file"
+)
+
+// isResolverTarget target 是否需要通过 Resolver 解析
+func isResolverTarget(target string) bool {
+	scheme, _, ok := splitTarget(target)
+	if !ok {
+		return false
+	}
+	switch scheme {
+	case SchemeStatic, SchemeDNS, SchemeFile:
+		return true
+	}
+	return false
+}
+
+func splitTarget(target string) (scheme, addr string, ok bool) {
+	i := strings.Index(target, "://")
+	if i < 0 {
+		return "", "", false
+	}
+	return target[:i], target[i+3:], true
+}
+
+// NewResolver 按 target 的协议创建 Resolver
+func NewResolver(target string) (Resolver, error) {
+	scheme, addr, _ := splitTarget(target)
+	switch scheme {
+	case SchemeStatic:
+		return StaticResolver(strings.Split(addr, ",")...), nil
+	case SchemeDNS:
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("hclient: dns target %s 缺少端口: %w", target, err)
+		}
+		return DNSResolver(host, port), nil
+	case SchemeFile:
+		return FileResolver(addr), nil
+	}
+	return nil, fmt.Errorf("hclient: 不支持的 target %s", target)
+}
+
+// StaticResolver 固定的地址列表
+func StaticResolver(addrs ...string) Resolver {
+	endpoints := normalizeAddrs(addrs)
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		return endpoints, nil
+	})
+}
+
+// DNSResolver 通过 A/AAAA 记录解析 host
+func DNSResolver(host, port string) Resolver {
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+		return normalizeAddrs(addrs), nil
+	})
+}
+
+// FileResolver 每次 Resolve 重新读取文件，BalancerDoer 按 RefreshInterval 轮询，文件变化最多延迟一个间隔生效
+func FileResolver(path string) Resolver {
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var addrs []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			addrs = append(addrs, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return normalizeAddrs(addrs), nil
+	})
+}
+
+// normalizeAddrs 去掉空白和重复地址并排序，方便比较地址是否变化
+func normalizeAddrs(addrs []string) []string {
+	seen := make(map[string]bool, len(addrs))
+	ret := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr == "" || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		ret = append(ret, addr)
+	}
+	sort.Strings(ret)
+	return ret
+}
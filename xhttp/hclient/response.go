@@ -1,31 +1,102 @@
 package hclient
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/pkg/errors"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xtrace"
 )
 
+// EnvelopeConfig 响应结构 {"code":0,"msg":"succ","data":{}} 的字段名，零值字段使用 hserver 的默认值
+type EnvelopeConfig struct {
+	// 默认 code
+	CodeKey string
+	// 默认 msg
+	MsgKey string
+	// 默认 data
+	DataKey string
+	// 表示成功的业务码，字符串类型的业务码按原文比较，默认 0
+	SuccessCodes []string
+}
+
+func (c EnvelopeConfig) withDefaults() EnvelopeConfig {
+	if c.CodeKey == "" {
+		c.CodeKey = "code"
+	}
+	if c.MsgKey == "" {
+		c.MsgKey = "msg"
+	}
+	if c.DataKey == "" {
+		c.DataKey = "data"
+	}
+	if len(c.SuccessCodes) == 0 {
+		c.SuccessCodes = []string{"0"}
+	}
+	return c
+}
+
+// LogicCodeError 响应中的业务码不是成功，可以通过 herror.As 取得对应的 *herror.Error
+type LogicCodeError struct {
+	StatusCode int
+	// 业务码，非数字的业务码为 0，原文见 RawCode
+	Code    int
+	RawCode string
+	Msg     string
+	// 对端响应头中的 trace-id，没有时为本次请求的 trace id
+	TraceId string
+}
+
+func (e *LogicCodeError) Error() string {
+	return fmt.Sprintf("hclient: 业务码错误 code=%s msg=%s traceId=%s", e.RawCode, e.Msg, e.TraceId)
+}
+
+func (e *LogicCodeError) Unwrap() error {
+	return herror.New(e.StatusCode, e.Code, e.Msg)
+}
+
 // jsonDecoder decodes http response JSON into a JSON-tagged struct value.
 type JsonDecoder struct {
 	logicCodeGuard bool
+	// 不为空时 v 只接收 data 字段
+	envelope *EnvelopeConfig
 }
 
 // 验证 返回code是正常
 // Decode decodes the Response Body into the value pointed to by v.
 // Caller must provide a non-nil v and close the resp.Body.
+// v 为 *herror.Error 时（通常作为 sling 的 failureV），解码后直接返回该错误，保留对端的业务码
 func (d JsonDecoder) Decode(resp *http.Response, v interface{}) error {
+	if d.envelope != nil {
+		return d.decodeEnvelope(resp, v)
+	}
+
 	err := errors.WithStack(json.NewDecoder(resp.Body).Decode(v))
 	if err != nil {
 		return err
 	}
 
+	if e, ok := v.(*herror.Error); ok {
+		e.Status = resp.StatusCode
+		if e.Code == 0 && resp.StatusCode >= 400 {
+			e.Code = resp.StatusCode
+		}
+		if e.Code != 0 {
+			return e
+		}
+		return nil
+	}
+
 	if d.logicCodeGuard {
 		ret, ok := v.(Response)
 		if ok {
 			if ret.GetCode() != 0 {
-				return errors.New(ret.GetMsg())
+				return newLogicCodeError(resp, strconv.Itoa(int(ret.GetCode())), ret.GetMsg())
 			}
 		}
 	}
@@ -33,6 +104,71 @@ func (d JsonDecoder) Decode(resp *http.Response, v interface{}) error {
 	return nil
 }
 
+// decodeEnvelope 检查业务码后将 data 解析到 v，v 为 *herror.Error 时按字段名还原错误
+func (d JsonDecoder) decodeEnvelope(resp *http.Response, v interface{}) error {
+	var env map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return errors.WithStack(err)
+	}
+	conf := d.envelope
+	code := rawCode(env[conf.CodeKey])
+	var msg string
+	_ = json.Unmarshal(env[conf.MsgKey], &msg)
+
+	if e, ok := v.(*herror.Error); ok {
+		e.Status = resp.StatusCode
+		e.Code, _ = strconv.Atoi(code)
+		e.Message = msg
+		if e.Code == 0 && resp.StatusCode >= 400 {
+			e.Code = resp.StatusCode
+		}
+		if e.Code != 0 {
+			return e
+		}
+		return nil
+	}
+
+	if code != "" && !conf.success(code) {
+		return newLogicCodeError(resp, code, msg)
+	}
+	data := env[conf.DataKey]
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	return errors.WithStack(json.Unmarshal(data, v))
+}
+
+func (c *EnvelopeConfig) success(code string) bool {
+	for _, s := range c.SuccessCodes {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// rawCode 数字或字符串类型的业务码原文
+func rawCode(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func newLogicCodeError(resp *http.Response, code, msg string) *LogicCodeError {
+	e := &LogicCodeError{StatusCode: resp.StatusCode, RawCode: code, Msg: msg}
+	e.Code, _ = strconv.Atoi(code)
+	e.TraceId = resp.Header.Get("trace-id")
+	if e.TraceId == "" && resp.Request != nil {
+		e.TraceId = xtrace.TraceIdFromContext(resp.Request.Context())
+	}
+	return e
+}
+
 type Response interface {
 	GetCode() int32
 	GetMsg() string
@@ -0,0 +1,110 @@
+package hclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xhttp/hserver"
+)
+
+type user struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestEnvelopeWithHServer(t *testing.T) {
+	s := hserver.New()
+	s.GET("/users/:id", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return user{Id: 1, Name: "tom"}, nil
+	})
+	s.GET("/missing", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return nil, herror.ErrNotFound.WithMessage("用户不存在")
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	client := New(WithServiceName("user"), WithTarget(ts.URL), WithEnvelope(EnvelopeConfig{}))
+
+	var u user
+	_, err := client.New().Get("/users/1").Receive(&u, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, user{Id: 1, Name: "tom"}, u)
+
+	failure := new(herror.Error)
+	resp, err := client.New().Get("/missing").Receive(&u, failure)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	if e, ok := herror.As(err); assert.True(t, ok) {
+		assert.Equal(t, http.StatusNotFound, e.Code)
+		assert.Equal(t, "用户不存在", e.Message)
+	}
+}
+
+func TestEnvelopeThirdParty(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("trace-id", "t-1")
+		if r.URL.Path == "/fail" {
+			_, _ = rw.Write([]byte(`{"errcode":"E1001","errmsg":"余额不足"}`))
+			return
+		}
+		_, _ = rw.Write([]byte(`{"errcode":"0000","errmsg":"ok","result":{"id":2,"name":"jerry"}}`))
+	}))
+	defer ts.Close()
+
+	client := New(WithServiceName("pay"), WithTarget(ts.URL), WithEnvelope(EnvelopeConfig{
+		CodeKey:      "errcode",
+		MsgKey:       "errmsg",
+		DataKey:      "result",
+		SuccessCodes: []string{"0000"},
+	}))
+
+	var u user
+	_, err := client.New().Get("/ok").Receive(&u, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "jerry", u.Name)
+
+	_, err = client.New().Get("/fail").Receive(&u, nil)
+	if e, ok := err.(*LogicCodeError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, "E1001", e.RawCode)
+		assert.Equal(t, "余额不足", e.Msg)
+		assert.Equal(t, "t-1", e.TraceId)
+	}
+	if e, ok := herror.As(err); assert.True(t, ok) {
+		assert.Equal(t, "余额不足", e.Message)
+	}
+}
+
+type legacyResp struct {
+	Code int32  `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (r *legacyResp) GetCode() int32 { return r.Code }
+func (r *legacyResp) GetMsg() string { return r.Msg }
+
+func TestLogicCodeGuard(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = rw.Write([]byte(`{"code":1002,"msg":"参数错误"}`))
+	}))
+	defer ts.Close()
+
+	// 默认不检查业务码，与之前的行为一致
+	var r legacyResp
+	_, err := New(WithServiceName("legacy"), WithTarget(ts.URL)).Get("/").Receive(&r, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1002, r.Code)
+
+	_, err = New(WithServiceName("legacy"), WithTarget(ts.URL), EnableLogicCodeGuard()).Get("/").Receive(&r, nil)
+	if e, ok := err.(*LogicCodeError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 1002, e.Code)
+	}
+
+	// DisableStatusCodeGuard 保持原来的含义，只关闭状态码检查
+	_, err = New(WithServiceName("legacy"), WithTarget(ts.URL), EnableLogicCodeGuard(), DisableStatusCodeGuard()).Get("/").Receive(&r, nil)
+	assert.Error(t, err)
+}
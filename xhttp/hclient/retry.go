@@ -0,0 +1,306 @@
+package hclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dghubble/sling"
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// IdempotencyKeyHeader 带有该请求头的非幂等请求也会重试，与 hserver.IdempotencyMiddleware 配合使用
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// errRetryBudgetExhausted 重试预算用完，只用于日志，调用方得到最后一次请求的结果
+var errRetryBudgetExhausted = errors.New("hclient: 重试预算已用完")
+
+// RetryConfig 重试配置，零值字段使用默认值
+type RetryConfig struct {
+	// 最多请求次数，包含第一次，默认 3
+	MaxAttempts int
+	// 第一次重试的退避时间，之后每次翻倍并加上随机抖动，默认 100ms
+	BaseDelay time.Duration
+	// 最长退避时间，Retry-After 超过该时间时不再重试，默认 2s
+	MaxDelay time.Duration
+	// 是否需要重试，默认连接错误和 429、502、503、504 重试
+	RetryOn func(resp *http.Response, err error) bool
+	// 重试预算，每个请求增加 BudgetRatio 个令牌，每次重试消耗 1 个，默认 0.2
+	BudgetRatio float64
+	// 每秒补充的令牌，保证请求量较少时也可以重试，默认 10
+	BudgetMinPerSecond int
+}
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 3
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = 100 * time.Millisecond
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 2 * time.Second
+	}
+	if c.RetryOn == nil {
+		c.RetryOn = DefaultRetryOn
+	}
+	if c.BudgetRatio <= 0 {
+		c.BudgetRatio = 0.2
+	}
+	if c.BudgetMinPerSecond <= 0 {
+		c.BudgetMinPerSecond = 10
+	}
+	return c
+}
+
+// DefaultRetryOn 连接错误、单次请求超时 ErrAttemptTimeout 和 429、502、503、504 重试，
+// 调用方取消或超时、熔断、并发限制和没有可用地址不重试
+func DefaultRetryOn(resp *http.Response, err error) bool {
+	if err != nil {
+		var open *CircuitOpenError
+		var full *BulkheadFullError
+		var noEndpoint *NoEndpointError
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+			!errors.As(err, &open) && !errors.As(err, &full) && !errors.As(err, &noEndpoint)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+type retryCtxKey struct{}
+
+type attemptCtxKey struct{}
+
+// NewCtxWithRetry 单个请求是否重试，enable 为 true 时非幂等请求也会重试，为 false 时不重试
+func NewCtxWithRetry(ctx context.Context, enable bool) context.Context {
+	return context.WithValue(ctx, retryCtxKey{}, enable)
+}
+
+// attemptFromContext 当前是第几次重试，第一次请求为 0
+func attemptFromContext(ctx context.Context) int {
+	n, _ := ctx.Value(attemptCtxKey{}).(int)
+	return n
+}
+
+// RetryDoer 失败时按指数退避重试，每次请求经过内层的 TraceDoer、LogDoer，分别记录 span 和日志
+type RetryDoer struct {
+	doer   sling.Doer
+	conf   RetryConfig
+	budget *retryBudget
+}
+
+func NewRetryDoer(doer sling.Doer, conf RetryConfig) *RetryDoer {
+	conf = conf.withDefaults()
+	return &RetryDoer{doer: doer, conf: conf, budget: newRetryBudget(conf.BudgetRatio, conf.BudgetMinPerSecond)}
+}
+
+func (t *RetryDoer) Do(req *http.Request) (resp *http.Response, err error) {
+	ctx := req.Context()
+	t.budget.deposit()
+	if !t.retryable(req) {
+		return t.doer.Do(req)
+	}
+	if err := bufferBody(req); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if req, err = rewind(req, attempt); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = t.doer.Do(req)
+		// 调用方的 ctx 已结束时不再重试，自定义的 RetryOn 也不需要判断
+		if attempt+1 >= t.conf.MaxAttempts || ctx.Err() != nil || !t.conf.RetryOn(resp, err) {
+			return resp, err
+		}
+
+		delay, ok := t.delay(attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		if deadline, has := ctx.Deadline(); has && time.Until(deadline) < delay {
+			return resp, err
+		}
+		if !t.budget.withdraw() {
+			xlog.S(ctx).Warnw("重试请求[http.client]", "url", req.URL.String(), "attempt", attempt+1, "err", errRetryBudgetExhausted)
+			return resp, err
+		}
+
+		fields := []interface{}{"method", req.Method, "url", req.URL.String(), "attempt", attempt + 1, "delay", delay.String()}
+		if err != nil {
+			fields = append(fields, "err", err.Error())
+		} else {
+			fields = append(fields, "statusCode", resp.StatusCode)
+			drain(resp.Body)
+		}
+		xlog.S(ctx).Warnw("重试请求[http.client]", fields...)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable 幂等方法、带 Idempotency-Key 或通过 NewCtxWithRetry 标记的请求可以重试
+func (t *RetryDoer) retryable(req *http.Request) bool {
+	if enable, ok := req.Context().Value(retryCtxKey{}).(bool); ok {
+		return enable
+	}
+	if req.Header.Get(IdempotencyKeyHeader) != "" {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// delay 带抖动的指数退避，响应带有 Retry-After 时取较大值，超过 MaxDelay 时不重试
+func (t *RetryDoer) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	backoff := t.conf.BaseDelay << uint(attempt)
+	if backoff <= 0 || backoff > t.conf.MaxDelay {
+		backoff = t.conf.MaxDelay
+	}
+	// 在 [backoff/2, backoff) 之间随机
+	delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if after > t.conf.MaxDelay {
+				return 0, false
+			}
+			if after > delay {
+				delay = after
+			}
+		}
+	}
+	return delay, true
+}
+
+// retryAfter 解析秒数或 HTTP 日期格式的 Retry-After
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// bufferBody 请求体不能重复读取时读入内存，重试时重新设置
+func bufferBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	return nil
+}
+
+// rewind 复制请求并重置请求体，请求头中的 trace 信息由 TraceDoer 重新注入
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	ctx := context.WithValue(req.Context(), attemptCtxKey{}, attempt)
+	if sp := opentracing.SpanFromContext(ctx); sp != nil {
+		sp.LogKV("event", "retry", "attempt", attempt)
+	}
+	r := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// drain 读取剩余的响应体以复用连接
+func drain(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	_, _ = io.CopyN(ioutil.Discard, body, 4<<10)
+	_ = body.Close()
+}
+
+// retryBudget 令牌桶，避免下游故障时重试放大请求量
+type retryBudget struct {
+	mu        sync.Mutex
+	ratio     float64
+	perSecond float64
+	max       float64
+	tokens    float64
+	last      time.Time
+}
+
+func newRetryBudget(ratio float64, minPerSecond int) *retryBudget {
+	max := float64(minPerSecond)
+	if max < 10 {
+		max = 10
+	}
+	return &retryBudget{ratio: ratio, perSecond: float64(minPerSecond), max: max, tokens: max, last: time.Now()}
+}
+
+func (b *retryBudget) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	b.last = now
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
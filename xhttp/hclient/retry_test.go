@@ -0,0 +1,130 @@
+package hclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDoer(t *testing.T) {
+	var calls int32
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/later":
+			rw.Header().Set("Retry-After", "60")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"code":0}`))
+	}))
+	defer ts.Close()
+
+	doer := NewRetryDoer(http.DefaultClient, RetryConfig{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond})
+	do := func(ctx context.Context, method, path, body string, header ...string) *http.Response {
+		req, _ := http.NewRequestWithContext(ctx, method, ts.URL+path, strings.NewReader(body))
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		resp, err := doer.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+		return resp
+	}
+	reset := func() {
+		atomic.StoreInt32(&calls, 0)
+		bodies = nil
+	}
+
+	assert.Equal(t, http.StatusOK, do(context.Background(), http.MethodGet, "/flaky", "").StatusCode)
+	assert.EqualValues(t, 3, calls)
+
+	// 非幂等请求不重试
+	reset()
+	assert.Equal(t, http.StatusServiceUnavailable, do(context.Background(), http.MethodPost, "/flaky", "a").StatusCode)
+	assert.EqualValues(t, 1, calls)
+
+	// 带 Idempotency-Key 的请求重试时重新发送请求体
+	reset()
+	assert.Equal(t, http.StatusOK, do(context.Background(), http.MethodPost, "/flaky", "a", IdempotencyKeyHeader, "k1").StatusCode)
+	assert.Equal(t, []string{"a", "a", "a"}, bodies)
+
+	reset()
+	assert.Equal(t, http.StatusOK, do(NewCtxWithRetry(context.Background(), true), http.MethodPatch, "/flaky", "b").StatusCode)
+	assert.EqualValues(t, 3, calls)
+
+	reset()
+	do(NewCtxWithRetry(context.Background(), false), http.MethodGet, "/flaky", "")
+	assert.EqualValues(t, 1, calls)
+
+	// Retry-After 超过 MaxDelay 时不重试
+	reset()
+	assert.Equal(t, http.StatusTooManyRequests, do(context.Background(), http.MethodGet, "/later", "").StatusCode)
+	assert.EqualValues(t, 1, calls)
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(0.5, 1)
+	b.tokens = 0
+	b.last = time.Now()
+	assert.False(t, b.withdraw())
+	b.deposit()
+	b.deposit()
+	assert.True(t, b.withdraw())
+	assert.False(t, b.withdraw())
+
+	d, ok := retryAfter(time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.True(t, d > time.Second && d <= 2*time.Second)
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 || r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		_, _ = rw.Write([]byte(`"ok"`))
+	}))
+	defer ts.Close()
+
+	client := New(WithServiceName("peer"), WithTarget(ts.URL),
+		WithTimeout(50*time.Millisecond), WithRetry(RetryConfig{BaseDelay: time.Millisecond}))
+
+	// New 与 NewTransport 一样，单次请求超时后重试
+	var got string
+	_, err := client.New().Get("/").ReceiveSuccess(&got)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", got)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+	// 调用方的 ctx 超时不重试
+	atomic.StoreInt32(&calls, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	req, err := client.New().Get("/slow").Request()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = client.Do(req.WithContext(ctx), nil, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
@@ -1,36 +1,86 @@
 package hclient
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/dghubble/sling"
-	"github.com/pkg/errors"
 
+	"github.com/yituoshiniao/kit/xhttp/herror"
 	"github.com/yituoshiniao/kit/xlog"
 )
 
+// 错误响应中保留的最大响应体
+const errBodyLimit = 4 << 10
+
+// ErrStatus 状态码异常，通过 errors.As 判断。
+// Unwrap 为 herror.ErrBadGateway，handler 原样返回时本服务响应 502，不透传下游的状态码和错误信息，
+// 需要透传时使用 Downstream
 type ErrStatus struct {
 	StatusCode int
-	Message    string
+	// 状态码说明，如 Service Unavailable
+	Message string
+	Method  string
+	URL     string
+	Header  http.Header
+	// 响应体，最多 4KB，不包含在 Error() 中
+	Body []byte
 }
 
 func (e *ErrStatus) Error() string {
-	return e.Message
+	return fmt.Sprintf("hclient: %s %s 状态码异常 %d", e.Method, e.URL, e.StatusCode)
+}
+
+func (e *ErrStatus) Unwrap() error {
+	return herror.ErrBadGateway.WithCause(fmt.Errorf("下游状态码 %d", e.StatusCode))
+}
+
+// Downstream 按 herror.Envelope 还原下游返回的 *herror.Error，响应体不是错误结构时返回 false
+func (e *ErrStatus) Downstream() (*herror.Error, bool) {
+	he := herror.Decode(e.StatusCode, e.Body)
+	return he, he != nil
+}
+
+// DefaultStatusCodePredicate 4xx、5xx 为错误
+func DefaultStatusCodePredicate(statusCode int) bool {
+	return statusCode >= 400
 }
 
 type StatusCodeGuardDoer struct {
-	doer sling.Doer
+	doer      sling.Doer
+	predicate func(statusCode int) bool
 }
 
 // 判断http-code是否正常
 func (t StatusCodeGuardDoer) Do(req *http.Request) (resp *http.Response, err error) {
 	resp, err = t.doer.Do(req)
+	if err != nil {
+		return
+	}
 
-	if err == nil && resp.StatusCode >= 400 {
-		err = errors.Wrap(errors.New(fmt.Sprintf("StatusCode:%d", resp.StatusCode)), "http状态码异常")
-		xlog.S(req.Context()).Errorw("http-code错误", "err", err)
+	predicate := t.predicate
+	if predicate == nil {
+		predicate = DefaultStatusCodePredicate
+	}
+	if !predicate(resp.StatusCode) {
+		return
 	}
 
-	return
+	e := &ErrStatus{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Header:     resp.Header,
+	}
+	if resp.Body != nil {
+		e.Body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, errBodyLimit))
+		drain(resp.Body)
+		resp.Body = ioutil.NopCloser(bytes.NewReader(e.Body))
+	}
+	xlog.S(req.Context()).Errorw("http-code错误", "err", e)
+	return resp, e
 }
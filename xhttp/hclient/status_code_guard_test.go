@@ -0,0 +1,69 @@
+package hclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+func TestStatusCodeGuardDoer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/envelope":
+			rw.WriteHeader(http.StatusNotFound)
+			_, _ = rw.Write([]byte(`{"code":40401,"msg":"用户不存在"}`))
+		case "/large":
+			rw.WriteHeader(http.StatusBadGateway)
+			_, _ = rw.Write([]byte(strings.Repeat("x", errBodyLimit*2)))
+		case "/moved":
+			rw.WriteHeader(http.StatusNotModified)
+		}
+	}))
+	defer ts.Close()
+
+	client := New(WithServiceName("user"), WithTarget(ts.URL), EnableStatusCodeGuard())
+
+	resp, err := client.New().Get("/envelope").ReceiveSuccess(nil)
+	var es *ErrStatus
+	if assert.True(t, errors.As(err, &es), "%v", err) {
+		assert.Equal(t, http.StatusNotFound, es.StatusCode)
+		assert.Equal(t, http.MethodGet, es.Method)
+		assert.Equal(t, ts.URL+"/envelope", es.URL)
+		// 响应体不出现在错误信息中
+		assert.NotContains(t, es.Error(), "用户不存在")
+		if e, ok := es.Downstream(); assert.True(t, ok) {
+			assert.Equal(t, 40401, e.Code)
+			assert.Equal(t, "用户不存在", e.Message)
+		}
+	}
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	// 原样返回时本服务响应 502，不透传下游的状态码和错误信息
+	e := herror.FromError(err)
+	assert.Equal(t, http.StatusBadGateway, e.Status)
+	assert.Equal(t, herror.ErrBadGateway.Message, e.Message)
+
+	_, err = client.New().Get("/large").ReceiveSuccess(nil)
+	if assert.True(t, errors.As(err, &es)) {
+		assert.Len(t, es.Body, errBodyLimit)
+		assert.Equal(t, http.StatusBadGateway, herror.FromError(err).Status)
+	}
+
+	_, err = client.New().Get("/moved").ReceiveSuccess(nil)
+	assert.NoError(t, err)
+
+	client = New(WithServiceName("user"), WithTarget(ts.URL), WithStatusCodePredicate(func(statusCode int) bool {
+		return statusCode >= 300
+	}))
+	_, err = client.New().Get("/moved").ReceiveSuccess(nil)
+	if assert.True(t, errors.As(err, &es)) {
+		assert.Equal(t, http.StatusNotModified, es.StatusCode)
+		_, ok := es.Downstream()
+		assert.False(t, ok)
+	}
+}
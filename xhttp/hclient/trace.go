@@ -31,6 +31,9 @@ func (t TraceDoer) Do(req *http.Request) (resp *http.Response, err error) {
 		ext.HTTPMethod.Set(span, req.Method)
 		//ext.SpanKind.Set(span, "client")
 		ext.SpanKindRPCClient.Set(span)
+		if attempt := attemptFromContext(req.Context()); attempt > 0 {
+			span.SetTag("http.retry_attempt", attempt)
+		}
 
 		defer span.Finish()
 
@@ -0,0 +1,230 @@
+package hclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dghubble/sling"
+)
+
+// RoundTripperFunc 函数形式的 http.RoundTripper
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// doerFunc 函数形式的 sling.Doer
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware http.RoundTripper 中间件，hclient.New 和 NewTransport 使用同一组中间件
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Chain 按顺序包装 rt，第一个中间件在最外层
+func Chain(rt http.RoundTripper, mws ...Middleware) http.RoundTripper {
+	for i := len(mws) - 1; i >= 0; i-- {
+		rt = mws[i](rt)
+	}
+	return rt
+}
+
+func doerMiddleware(wrap func(next sling.Doer) sling.Doer) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(wrap(doerFunc(next.RoundTrip)).Do)
+	}
+}
+
+// Logging 记录请求和响应日志，bodyLog 零值时使用默认配置
+func Logging(durationFunc DurationToField, bodyLog BodyLogConfig) Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return LogDoer{doer: next, durationFunc: durationFunc, bodyLog: bodyLog.withDefaults()}
+	})
+}
+
+// Tracing 上下文中有 span 时创建子 span 并注入请求头
+func Tracing(operationName string) Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return TraceDoer{doer: next, operationName: operationName}
+	})
+}
+
+// Metrics 请求数统计，需要先调用 InitHttpClientAPICounterMetrics
+func Metrics() Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return MetricsDoer{doer: next}
+	})
+}
+
+// CircuitBreaker 见 NewCircuitBreakerDoer
+func CircuitBreaker(serviceName string, conf BreakerConfig) Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return NewCircuitBreakerDoer(next, serviceName, conf)
+	})
+}
+
+// Bulkhead 见 NewBulkheadDoer
+func Bulkhead(serviceName string, conf BulkheadConfig) Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return NewBulkheadDoer(next, serviceName, conf)
+	})
+}
+
+// Cache 见 NewCacheDoer
+func Cache(serviceName string, conf CacheConfig) Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return NewCacheDoer(next, serviceName, conf)
+	})
+}
+
+// Retry 见 NewRetryDoer
+func Retry(conf RetryConfig) Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return NewRetryDoer(next, conf)
+	})
+}
+
+// StatusGuard predicate 返回 true 的状态码返回 *ErrStatus，predicate 为空时检查 4xx、5xx
+func StatusGuard(predicate func(statusCode int) bool) Middleware {
+	return doerMiddleware(func(next sling.Doer) sling.Doer {
+		return StatusCodeGuardDoer{doer: next, predicate: predicate}
+	})
+}
+
+// ErrAttemptTimeout 单次请求超过 Timeout，与调用方的超时不同，会被 DefaultRetryOn 重试
+var ErrAttemptTimeout = errors.New("hclient: 单次请求超时")
+
+// Timeout 单次请求的超时时间，包括读取响应体，重试时每次单独计算
+func Timeout(timeout time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if timeout <= 0 {
+			return next
+		}
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			resp, err := next.RoundTrip(req.WithContext(ctx))
+			if err != nil && ctx.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+				err = fmt.Errorf("%w: %v", ErrAttemptTimeout, err)
+			}
+			if err != nil || resp.Body == nil {
+				cancel()
+				return resp, err
+			}
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		})
+	}
+}
+
+// cancelBody 响应体读完或关闭时释放超时的 context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (b *cancelBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.cancel)
+	}
+	return n, err
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.cancel)
+	return err
+}
+
+// middlewares 按 options 组装中间件，熔断按选中的地址统计，重试时重新选择地址，熔断和并发限制的错误不会重试，
+// closers 为需要停止的后台任务
+func (o options) middlewares() (mws []Middleware, closers []io.Closer) {
+	if o.statusCodeGuard {
+		mws = append(mws, StatusGuard(o.statusPredicate))
+	}
+	if o.retry != nil {
+		mws = append(mws, Retry(*o.retry))
+	}
+	if o.bulkhead != nil {
+		mws = append(mws, Bulkhead(o.serviceName, *o.bulkhead))
+	}
+	if o.balancer != nil || isResolverTarget(o.target) {
+		lb := &lazyBalancer{o: o}
+		closers = append(closers, lb)
+		mws = append(mws, doerMiddleware(func(next sling.Doer) sling.Doer {
+			lb.next = next
+			return lb
+		}))
+	}
+	if o.breaker != nil {
+		mws = append(mws, CircuitBreaker(o.serviceName, *o.breaker))
+	}
+	if o.metrics {
+		mws = append(mws, Metrics())
+	}
+	// 先日志 修复 cancel 无法被记录情况
+	mws = append(mws, Tracing(o.serviceName), Logging(o.durationFunc, o.bodyLog))
+	mws = append(mws, o.extra...)
+	// 缓存在认证之后，按认证中间件设置的凭证区分缓存
+	if o.cache != nil {
+		mws = append(mws, Cache(o.serviceName, *o.cache))
+	}
+	return mws, closers
+}
+
+// httpTransport 设置了 tls 配置时复制一份 transport，不修改 http.DefaultTransport
+func (o options) httpTransport() http.RoundTripper {
+	if t, ok := o.transport.(*http.Transport); ok && o.tlsConfig != nil {
+		t = t.Clone()
+		t.TLSClientConfig = o.tlsConfig
+		return t
+	}
+	return o.transport
+}
+
+// NewTransport 按 Option 组装日志、链路、监控、熔断、重试、状态码检查等中间件，
+// 可以用于任何接收 *http.Client 或 http.RoundTripper 的第三方 SDK，WithTimeout 为单次请求的超时时间
+func NewTransport(opts ...Option) http.RoundTripper {
+	o := newOptions(opts)
+	rt := Chain(o.httpTransport(), Timeout(o.timeout))
+	mws, closers := o.middlewares()
+	return &transport{rt: Chain(rt, mws...), closers: closers}
+}
+
+// NewHTTPClient 使用 NewTransport 的 *http.Client
+func NewHTTPClient(opts ...Option) *http.Client {
+	return &http.Client{Transport: NewTransport(opts...)}
+}
+
+// transport 中间件会修改请求头和请求体，按 http.RoundTripper 的约定先复制请求，
+// 通过 io.Closer 断言调用 Close 停止负载均衡的后台任务
+type transport struct {
+	rt      http.RoundTripper
+	closers []io.Closer
+}
+
+func (t *transport) Close() error {
+	for _, closer := range t.closers {
+		_ = closer.Close()
+	}
+	return nil
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req.Clone(req.Context()))
+	if err != nil && resp != nil {
+		// http.Client 会忽略同时返回的响应，*ErrStatus 中保留了响应体
+		drain(resp.Body)
+		return nil, err
+	}
+	return resp, err
+}
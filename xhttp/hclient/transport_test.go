@@ -0,0 +1,68 @@
+package hclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	rt := Chain(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "base")
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}), mw("a"), mw("b"))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://peer/", nil)
+	_, err := rt.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "base"}, order)
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		rw.WriteHeader(http.StatusConflict)
+		_, _ = rw.Write([]byte(`{"code":40901,"msg":"重复提交"}`))
+	}))
+	defer ts.Close()
+
+	client := NewHTTPClient(
+		WithServiceName("sdk"),
+		WithTimeout(50*time.Millisecond),
+		WithRetry(RetryConfig{BaseDelay: time.Millisecond}),
+		EnableStatusCodeGuard(),
+		WithInsecure(),
+	)
+
+	// 第一次请求超时后重试，超时按单次请求计算
+	resp, err := client.Get(ts.URL)
+	assert.Nil(t, resp)
+	var es *ErrStatus
+	if assert.True(t, errors.As(err, &es), "%v", err) {
+		assert.Equal(t, http.StatusConflict, es.StatusCode)
+		assert.Equal(t, `{"code":40901,"msg":"重复提交"}`, string(es.Body))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// WithInsecure 不修改 http.DefaultTransport
+	if conf := http.DefaultTransport.(*http.Transport).TLSClientConfig; conf != nil {
+		assert.False(t, conf.InsecureSkipVerify)
+	}
+}
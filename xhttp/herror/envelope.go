@@ -0,0 +1,50 @@
+package herror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Envelope hserver 默认的响应结构 {"code":0,"msg":"succ","data":{}}
+type Envelope struct {
+	Code      int                    `json:"code"`
+	Msg       string                 `json:"msg"`
+	Data      interface{}            `json:"data"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Retryable bool                   `json:"retryable,omitempty"`
+}
+
+// NewEnvelope 将错误转换为响应结构，只包含可以返回给调用方的信息
+func NewEnvelope(err error) Envelope {
+	e := FromError(err)
+	return Envelope{
+		Code:      e.Code,
+		Msg:       e.Message,
+		Details:   e.Details,
+		Retryable: e.Retryable,
+	}
+}
+
+// Decode 从响应中还原 *Error，body 不是错误响应时返回 nil
+func Decode(httpStatus int, body []byte) *Error {
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil || (env.Code == 0 && env.Msg == "") {
+		if httpStatus < 400 {
+			return nil
+		}
+		return New(httpStatus, httpStatus, http.StatusText(httpStatus))
+	}
+	if env.Code == 0 && httpStatus < 400 {
+		return nil
+	}
+	if env.Code == 0 {
+		env.Code = httpStatus
+	}
+	return &Error{
+		Status:    httpStatus,
+		Code:      env.Code,
+		Message:   env.Msg,
+		Details:   env.Details,
+		Retryable: env.Retryable,
+	}
+}
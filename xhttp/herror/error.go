@@ -0,0 +1,231 @@
+package herror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrBadRequest      = New(http.StatusBadRequest, http.StatusBadRequest, "请求参数错误")
+	ErrUnauthorized    = New(http.StatusUnauthorized, http.StatusUnauthorized, "未登录或登录已过期")
+	ErrForbidden       = New(http.StatusForbidden, http.StatusForbidden, "没有访问权限")
+	ErrNotFound        = New(http.StatusNotFound, http.StatusNotFound, "资源不存在")
+	ErrConflict        = New(http.StatusConflict, http.StatusConflict, "资源冲突")
+	ErrEntityTooLarge  = New(http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, "请求体过大")
+	ErrTooManyRequests = New(http.StatusTooManyRequests, http.StatusTooManyRequests, "请求过于频繁").WithRetryable(true)
+	ErrInternal        = New(http.StatusInternalServerError, http.StatusInternalServerError, "服务内部错误")
+	ErrBadGateway      = New(http.StatusBadGateway, http.StatusBadGateway, "依赖的服务异常")
+	ErrUnavailable     = New(http.StatusServiceUnavailable, http.StatusServiceUnavailable, "服务暂不可用").WithRetryable(true)
+	ErrTimeout         = New(http.StatusGatewayTimeout, http.StatusGatewayTimeout, "请求超时").WithRetryable(true)
+)
+
+// Error 服务间传递的结构化错误，Message 会返回给调用方，cause 只用于日志
+type Error struct {
+	// HTTP 状态码
+	Status int `json:"-"`
+	// 业务码，0 表示成功
+	Code int `json:"code"`
+	// 可以展示给用户的错误信息
+	Message string `json:"msg"`
+	// 附加信息，如参数校验失败的字段
+	Details map[string]interface{} `json:"details,omitempty"`
+	// 调用方是否可以重试
+	Retryable bool `json:"retryable,omitempty"`
+
+	cause error
+}
+
+// New 创建错误，status 为 HTTP 状态码，code 为业务码
+func New(status, code int, msg string) *Error {
+	return &Error{Status: status, Code: code, Message: msg}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("code=%d msg=%s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("code=%d msg=%s", e.Code, e.Message)
+}
+
+// WithCause 返回携带内部原因的副本，原因不会返回给调用方
+func (e *Error) WithCause(err error) *Error {
+	c := *e
+	c.cause = err
+	return &c
+}
+
+// WithMessage 返回替换了错误信息的副本
+func (e *Error) WithMessage(format string, args ...interface{}) *Error {
+	c := *e
+	c.Message = fmt.Sprintf(format, args...)
+	return &c
+}
+
+// WithDetail 返回追加了附加信息的副本
+func (e *Error) WithDetail(key string, value interface{}) *Error {
+	c := *e
+	c.Details = make(map[string]interface{}, len(e.Details)+1)
+	for k, v := range e.Details {
+		c.Details[k] = v
+	}
+	c.Details[key] = value
+	return &c
+}
+
+// WithRetryable 返回设置了是否可重试的副本
+func (e *Error) WithRetryable(retryable bool) *Error {
+	c := *e
+	c.Retryable = retryable
+	return &c
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Cause 兼容 github.com/pkg/errors
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+// Is 业务码和状态码相同即视为同一错误，方便 errors.Is(err, herror.ErrNotFound)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code && e.Status == t.Status
+}
+
+// GRPCStatus 使 status.Code(err) 等 grpc 工具函数能识别该错误
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(CodeFromHTTPStatus(e.Status), e.Message)
+}
+
+// As 从错误链中查找 *Error
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// FromError 将任意错误转换为 *Error，未知错误统一视为内部错误，不暴露原始信息；
+// grpc status 只有 4xx 类的信息会返回给调用方
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := As(err); ok {
+		return e
+	}
+
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		st := se.GRPCStatus()
+		httpStatus := HTTPStatusFromCode(st.Code())
+		if httpStatus < http.StatusInternalServerError {
+			return New(httpStatus, httpStatus, st.Message()).WithCause(err)
+		}
+		// 5xx 的信息可能包含 SQL、驱动等内部错误，使用固定的错误信息
+		switch httpStatus {
+		case http.StatusServiceUnavailable:
+			return ErrUnavailable.WithCause(err)
+		case http.StatusGatewayTimeout:
+			return ErrTimeout.WithCause(err)
+		case http.StatusNotImplemented:
+			return New(httpStatus, httpStatus, "接口未实现").WithCause(err)
+		}
+		return ErrInternal.WithCause(err)
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout.WithCause(err)
+	case errors.Is(err, context.Canceled):
+		return New(http.StatusRequestTimeout, http.StatusRequestTimeout, "请求已取消").WithCause(err)
+	}
+	return ErrInternal.WithCause(err)
+}
+
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return http.StatusRequestTimeout
+	case codes.Unknown:
+		return http.StatusInternalServerError
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		// Note, this deliberately doesn't translate to the similarly named '412 Precondition Failed' HTTP response status.
+		return http.StatusBadRequest
+	case codes.Aborted:
+		return http.StatusConflict
+	case codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Internal:
+		return http.StatusInternalServerError
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DataLoss:
+		return http.StatusInternalServerError
+	}
+	return http.StatusInternalServerError
+}
+
+// CodeFromHTTPStatus HTTPStatusFromCode 的逆向转换
+func CodeFromHTTPStatus(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusRequestTimeout:
+		return codes.Canceled
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	switch {
+	case httpStatus >= 200 && httpStatus < 300:
+		return codes.OK
+	case httpStatus >= 400 && httpStatus < 500:
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
@@ -0,0 +1,66 @@
+package herror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   int
+		msg    string
+	}{
+		{ErrNotFound.WithCause(errors.New("record not found")), http.StatusNotFound, http.StatusNotFound, "资源不存在"},
+		{pkgerrors.Wrap(New(http.StatusBadRequest, 10001, "手机号格式错误"), "check"), http.StatusBadRequest, 10001, "手机号格式错误"},
+		{status.Error(codes.PermissionDenied, "denied"), http.StatusForbidden, http.StatusForbidden, "denied"},
+		{pkgerrors.Wrap(status.Error(codes.Unavailable, "down"), "call"), http.StatusServiceUnavailable, http.StatusServiceUnavailable, "服务暂不可用"},
+		{status.Error(codes.Internal, "Error 1054: Unknown column 'pwd'"), http.StatusInternalServerError, http.StatusInternalServerError, "服务内部错误"},
+		{status.Error(codes.DataLoss, "checksum mismatch"), http.StatusInternalServerError, http.StatusInternalServerError, "服务内部错误"},
+		{status.Error(codes.Unknown, "sql: no rows"), http.StatusInternalServerError, http.StatusInternalServerError, "服务内部错误"},
+		{pkgerrors.Wrap(context.DeadlineExceeded, "query"), http.StatusGatewayTimeout, http.StatusGatewayTimeout, "请求超时"},
+		{errors.New("dial tcp 10.0.0.1:3306: connection refused"), http.StatusInternalServerError, http.StatusInternalServerError, "服务内部错误"},
+	}
+	for _, c := range cases {
+		e := FromError(c.err)
+		assert.Equal(t, c.status, e.Status, c.err.Error())
+		assert.Equal(t, c.code, e.Code, c.err.Error())
+		assert.Equal(t, c.msg, e.Message, c.err.Error())
+	}
+	assert.Nil(t, FromError(nil))
+}
+
+func TestErrorIs(t *testing.T) {
+	err := pkgerrors.Wrap(ErrNotFound.WithCause(errors.New("record not found")), "get user")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrForbidden))
+	assert.Equal(t, codes.NotFound, status.Code(ErrNotFound))
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	src := New(http.StatusConflict, 20003, "订单已支付").WithDetail("orderId", "123").WithRetryable(true).WithCause(errors.New("duplicate key"))
+	body, err := json.Marshal(NewEnvelope(src))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(body), "duplicate key")
+
+	e := Decode(http.StatusConflict, body)
+	if assert.NotNil(t, e) {
+		assert.Equal(t, http.StatusConflict, e.Status)
+		assert.Equal(t, 20003, e.Code)
+		assert.Equal(t, "订单已支付", e.Message)
+		assert.Equal(t, "123", e.Details["orderId"])
+		assert.True(t, e.Retryable)
+	}
+
+	assert.Nil(t, Decode(http.StatusOK, []byte(`{"code":0,"msg":"succ","data":{}}`)))
+	assert.Equal(t, http.StatusBadGateway, Decode(http.StatusBadGateway, []byte("<html>")).Code)
+}
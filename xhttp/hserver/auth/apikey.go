@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+)
+
+// ErrInvalidAPIKey API Key 不存在
+var ErrInvalidAPIKey = errors.New("API Key 无效")
+
+// APIKey 静态配置的 API Key
+type APIKey struct {
+	Key string
+	// 调用方名称，作为 Principal.Subject
+	Name   string
+	Scopes []string
+}
+
+// APIKeyAuthenticator 校验请求头中的 API Key，默认使用 X-Api-Key
+type APIKeyAuthenticator struct {
+	header string
+	// 按 sha256 查找，避免比较 key 的耗时泄露 key 的内容
+	keys map[[sha256.Size]byte]APIKey
+}
+
+type APIKeyOption func(*APIKeyAuthenticator)
+
+// WithAPIKeyHeader 设置读取 API Key 的请求头
+func WithAPIKeyHeader(header string) APIKeyOption {
+	return func(a *APIKeyAuthenticator) {
+		a.header = header
+	}
+}
+
+func NewAPIKeyAuthenticator(keys []APIKey, opts ...APIKeyOption) *APIKeyAuthenticator {
+	a := &APIKeyAuthenticator{header: "X-Api-Key", keys: make(map[[sha256.Size]byte]APIKey, len(keys))}
+	for _, k := range keys {
+		a.keys[sha256.Sum256([]byte(k.Key))] = k
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(a.header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	k, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	return &Principal{Subject: k.Name, Method: MethodAPIKey, Scopes: k.Scopes}, nil
+}
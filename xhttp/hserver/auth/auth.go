@@ -0,0 +1,154 @@
+// Package auth hserver 的认证中间件，内置 JWT、HMAC 请求签名和 API Key 三种认证方式
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xhttp/hauth"
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xhttp/hserver"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// 认证方式
+const (
+	MethodJWT    = hauth.MethodJWT
+	MethodHMAC   = hauth.MethodHMAC
+	MethodAPIKey = hauth.MethodAPIKey
+)
+
+// ErrNoCredentials 请求没有携带该认证方式的凭证，Middleware 会尝试下一个 Authenticator
+var ErrNoCredentials = errors.New("auth: no credentials")
+
+// Principal 认证通过的调用方，定义在 hauth 中，hclient 透传身份时不需要引用 hserver
+type Principal = hauth.Principal
+
+// Authenticator 认证方式
+type Authenticator interface {
+	// Authenticate 认证请求，未携带凭证时返回 ErrNoCredentials，凭证无效时返回其他错误
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// NewContext 将 principal 放入 ctx，之后的日志会带上 principal 字段
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return hauth.NewContext(ctx, p)
+}
+
+// FromContext 获取认证通过的调用方
+func FromContext(ctx context.Context) (*Principal, bool) {
+	return hauth.FromContext(ctx)
+}
+
+// Middleware 依次尝试 Authenticator，第一个识别到凭证的 Authenticator 决定认证结果，
+// 失败时通过 hserver.WriteError 输出 codes.Unauthenticated 或 codes.PermissionDenied
+type Middleware struct {
+	authenticators []Authenticator
+	optional       bool
+	scopes         []string
+}
+
+type Option func(*Middleware)
+
+// WithOptional 未携带凭证的请求直接放行，handler 通过 FromContext 判断是否登录
+func WithOptional() Option {
+	return func(m *Middleware) {
+		m.optional = true
+	}
+}
+
+// WithScopes 要求调用方拥有全部 scope
+func WithScopes(scopes ...string) Option {
+	return func(m *Middleware) {
+		m.scopes = append(m.scopes, scopes...)
+	}
+}
+
+func NewMiddleware(authenticators []Authenticator, opts ...Option) *Middleware {
+	m := &Middleware{authenticators: authenticators}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Middleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	ctx := r.Context()
+	var p *Principal
+	for _, a := range m.authenticators {
+		var err error
+		p, err = a.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			xlog.S(ctx).Infow("认证失败", "err", err)
+			hserver.WriteError(rw, r, authError(err))
+			return
+		}
+		break
+	}
+
+	if p == nil {
+		if m.optional {
+			next(rw, r)
+			return
+		}
+		hserver.WriteError(rw, r, status.Error(codes.Unauthenticated, "缺少认证信息"))
+		return
+	}
+	if !checkScopes(rw, r, p, m.scopes) {
+		return
+	}
+
+	if sp := opentracing.SpanFromContext(ctx); sp != nil {
+		sp.SetTag("auth.principal", p.Subject)
+	}
+	hserver.AddLogFields(ctx, zap.String("principal", p.Subject))
+	next(rw, r.WithContext(NewContext(ctx, p)))
+}
+
+// authError 已经是 herror 或 grpc status 的错误原样输出，如存储不可用，其他错误视为凭证无效
+func authError(err error) error {
+	if _, ok := herror.As(err); ok {
+		return err
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Unauthenticated, err.Error())
+}
+
+// RequireScopes 路由级别的权限校验，需要在 Middleware 之后执行，配合 hserver.WithRouteMiddleware 使用
+func RequireScopes(scopes ...string) hserver.RouteOption {
+	return hserver.WithRouteMiddleware(scopeMiddleware(scopes))
+}
+
+type scopeMiddleware []string
+
+func (s scopeMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	p, ok := FromContext(r.Context())
+	if !ok {
+		hserver.WriteError(rw, r, status.Error(codes.Unauthenticated, "缺少认证信息"))
+		return
+	}
+	if checkScopes(rw, r, p, s) {
+		next(rw, r)
+	}
+}
+
+func checkScopes(rw http.ResponseWriter, r *http.Request, p *Principal, scopes []string) bool {
+	for _, scope := range scopes {
+		if !p.HasScope(scope) {
+			hserver.WriteError(rw, r, status.Errorf(codes.PermissionDenied, "缺少权限 %s", scope))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+
+	"github.com/yituoshiniao/kit/xhttp/hserver"
+)
+
+// run 执行中间件，返回响应和 handler 中获取到的 Principal
+func run(m negroni.Handler, r *http.Request) (*httptest.ResponseRecorder, *Principal) {
+	rw := httptest.NewRecorder()
+	var p *Principal
+	m.ServeHTTP(rw, r, func(rw http.ResponseWriter, r *http.Request) {
+		p, _ = FromContext(r.Context())
+		_, _ = ioutil.ReadAll(r.Body)
+	})
+	return rw, p
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("secret")
+	m := NewMiddleware([]Authenticator{NewJWTAuthenticator(HMACKey(secret), WithIssuer("kit"))}, WithScopes("read"))
+
+	token, err := Sign("HS256", "", secret, map[string]interface{}{
+		"sub": "u1", "iss": "kit", "scope": "read write", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	assert.NoError(t, err)
+	rw, p := run(m, bearer(token))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	if assert.NotNil(t, p) {
+		assert.Equal(t, "u1", p.Subject)
+		assert.Equal(t, MethodJWT, p.Method)
+		assert.True(t, p.HasScope("write"))
+	}
+
+	expired, _ := Sign("HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "kit", "exp": time.Now().Add(-time.Minute).Unix()})
+	rw, _ = run(m, bearer(expired))
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	assert.Contains(t, rw.Body.String(), ErrTokenExpired.Error())
+
+	forged, _ := Sign("HS256", "", []byte("other"), map[string]interface{}{"sub": "u1", "iss": "kit"})
+	rw, _ = run(m, bearer(forged))
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+
+	noScope, _ := Sign("HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "kit", "exp": time.Now().Add(time.Hour).Unix()})
+	rw, _ = run(m, bearer(noScope))
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+
+	// 默认拒绝没有 exp 的 token
+	noExp, _ := Sign("HS256", "", secret, map[string]interface{}{"sub": "u1", "iss": "kit", "scope": "read"})
+	rw, _ = run(m, bearer(noExp))
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	_, err = NewJWTAuthenticator(HMACKey(secret), WithRequireExp(false)).Parse(noExp)
+	assert.NoError(t, err)
+
+	rw, _ = run(m, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	rw, p = run(NewMiddleware([]Authenticator{NewJWTAuthenticator(HMACKey(secret))}, WithOptional()), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Nil(t, p)
+}
+
+func writeJWKS(t *testing.T, path string, keys map[string]*rsa.PrivateKey) {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, k := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+	b, _ := json.Marshal(set)
+	assert.NoError(t, ioutil.WriteFile(path, b, 0600))
+}
+
+func TestJWKSRotation(t *testing.T) {
+	k1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	k2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	dir, err := ioutil.TempDir("", "jwks")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"k1": k1})
+
+	keys, err := NewJWKSFile(path, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := NewJWTAuthenticator(keys, WithRequireExp(false))
+
+	t1, _ := Sign("RS256", "k1", k1, map[string]interface{}{"sub": "u1"})
+	_, err = a.Parse(t1)
+	assert.NoError(t, err)
+	t2, _ := Sign("RS256", "k2", k2, map[string]interface{}{"sub": "u1"})
+	_, err = a.Parse(t2)
+	assert.Equal(t, ErrUnknownKey, err)
+
+	// 密钥轮换
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"k1": k1, "k2": k2})
+	future := time.Now().Add(time.Second)
+	assert.NoError(t, os.Chtimes(path, future, future))
+	_, err = a.Parse(t2)
+	assert.NoError(t, err)
+
+	// HS 算法不能使用 RSA 公钥
+	pub, _ := keys.Key("k1")
+	forged, _ := Sign("HS256", "k1", pub.(*rsa.PublicKey).N.Bytes(), map[string]interface{}{"sub": "u1"})
+	_, err = a.Parse(forged)
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestHMAC(t *testing.T) {
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	secret := []byte("secret")
+	m := NewMiddleware([]Authenticator{NewHMACAuthenticator(StaticSecrets(map[string][]byte{"svc": secret}), client)})
+
+	newReq := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/orders?id=1", strings.NewReader(`{"a":1}`))
+		assert.NoError(t, SignRequest(r, "svc", secret))
+		return r
+	}
+
+	r := newReq()
+	replay := r.Clone(r.Context())
+	replay.Body = ioutil.NopCloser(strings.NewReader(`{"a":1}`))
+	rw, p := run(m, r)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	if assert.NotNil(t, p) {
+		assert.Equal(t, "svc", p.Subject)
+	}
+	rw, _ = run(m, replay)
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	assert.Contains(t, rw.Body.String(), ErrReplayedRequest.Error())
+
+	tampered := newReq()
+	tampered.Body = ioutil.NopCloser(strings.NewReader(`{"a":2}`))
+	rw, _ = run(m, tampered)
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+
+	old := newReq()
+	old.Header.Set(HeaderTimestamp, "1")
+	rw, _ = run(m, old)
+	assert.Contains(t, rw.Body.String(), ErrRequestExpired.Error())
+
+	// 签名校验前读取的请求体有大小限制
+	limited := NewMiddleware([]Authenticator{NewHMACAuthenticator(StaticSecrets(map[string][]byte{"svc": secret}), client, WithHMACMaxBodyBytes(4))})
+	rw, _ = run(limited, newReq())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+
+	// redis 不可用时返回 503
+	mr.Close()
+	rw, _ = run(m, newReq())
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+}
+
+func TestAPIKey(t *testing.T) {
+	m := NewMiddleware([]Authenticator{
+		NewJWTAuthenticator(HMACKey([]byte("secret"))),
+		NewAPIKeyAuthenticator([]APIKey{{Key: "k-1", Name: "crm", Scopes: []string{"orders"}}}),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Api-Key", "k-1")
+	rw, p := run(m, r)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	if assert.NotNil(t, p) {
+		assert.Equal(t, "crm", p.Subject)
+		assert.Equal(t, MethodAPIKey, p.Method)
+	}
+
+	r.Header.Set("X-Api-Key", "k-2")
+	rw, _ = run(m, r)
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+
+	// 路由级别的 scope 校验
+	rw = httptest.NewRecorder()
+	ctx := NewContext(r.Context(), p)
+	scopeMiddleware{"admin"}.ServeHTTP(rw, r.WithContext(ctx), func(http.ResponseWriter, *http.Request) {})
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+}
+
+func TestCORSPreflightSkipsAuth(t *testing.T) {
+	s := hserver.New(
+		hserver.WithCORS(hserver.CORSConfig{AllowOrigins: []string{"https://app.example.com"}}),
+		hserver.WithMiddleware(NewMiddleware([]Authenticator{NewAPIKeyAuthenticator([]APIKey{{Key: "k-1", Name: "crm"}})})),
+	)
+	s.POST("/x", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return "ok", nil
+	})
+
+	// 预检请求不带凭证，在认证之前返回
+	req := httptest.NewRequest(http.MethodOptions, "/x", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+	assert.Equal(t, "https://app.example.com", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "OPTIONS, POST", rw.Header().Get("Access-Control-Allow-Methods"))
+
+	// 实际请求仍然需要认证
+	req = httptest.NewRequest(http.MethodPost, "/x", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/yituoshiniao/kit/xhttp/hauth"
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+// HMAC 签名使用的请求头
+const (
+	HeaderKeyId     = hauth.HeaderKeyId
+	HeaderTimestamp = hauth.HeaderTimestamp
+	HeaderNonce     = hauth.HeaderNonce
+	HeaderSignature = hauth.HeaderSignature
+)
+
+var (
+	ErrInvalidSignature = errors.New("签名无效")
+	ErrRequestExpired   = errors.New("请求时间戳已过期")
+	ErrReplayedRequest  = errors.New("请求重复提交")
+)
+
+// SecretFunc 根据 key id 查找签名密钥
+type SecretFunc func(ctx context.Context, keyId string) ([]byte, error)
+
+// StaticSecrets 固定的 key id 和密钥
+func StaticSecrets(secrets map[string][]byte) SecretFunc {
+	return func(_ context.Context, keyId string) ([]byte, error) {
+		if secret, ok := secrets[keyId]; ok {
+			return secret, nil
+		}
+		return nil, ErrInvalidSignature
+	}
+}
+
+// SignRequest 调用方为请求签名，签名内容见 hauth.CanonicalString
+func SignRequest(r *http.Request, keyId string, secret []byte) error {
+	body, err := readBody(r, 0)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+	r.Header.Set(HeaderKeyId, keyId)
+	r.Header.Set(HeaderTimestamp, ts)
+	r.Header.Set(HeaderNonce, n)
+	r.Header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(signature(r, ts, n, body, secret)))
+	return nil
+}
+
+func signature(r *http.Request, ts, nonce string, body, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(hauth.CanonicalString(r, ts, nonce, body)))
+	return mac.Sum(nil)
+}
+
+// readBody 读取请求体后放回，之后的 handler 仍然可以读取，max 大于 0 时超出返回 herror.ErrEntityTooLarge
+func readBody(r *http.Request, max int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	reader := r.Body
+	if max > 0 {
+		reader = http.MaxBytesReader(nil, r.Body, max)
+	}
+	body, err := ioutil.ReadAll(reader)
+	_ = r.Body.Close()
+	if err != nil && max > 0 && int64(len(body)) >= max {
+		return nil, herror.ErrEntityTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// HMACAuthenticator 校验 SignRequest 生成的签名，时间戳超出 maxSkew 或 nonce 重复的请求被拒绝。
+// nonce 记录在 redis 中，client 为 nil 时记录在内存中，只适合单实例部署
+type HMACAuthenticator struct {
+	secrets SecretFunc
+	client  *redis.Client
+	maxSkew time.Duration
+	prefix  string
+	maxBody int64
+	now     func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	purged time.Time
+}
+
+type HMACOption func(*HMACAuthenticator)
+
+// WithMaxSkew 允许的时间戳误差，默认 5 分钟，nonce 保存 2 倍的时长
+func WithMaxSkew(d time.Duration) HMACOption {
+	return func(a *HMACAuthenticator) {
+		a.maxSkew = d
+	}
+}
+
+// WithNonceKeyPrefix 设置 nonce 在 redis 中的 key 前缀，默认 auth:nonce:
+func WithNonceKeyPrefix(prefix string) HMACOption {
+	return func(a *HMACAuthenticator) {
+		a.prefix = prefix
+	}
+}
+
+// WithHMACMaxBodyBytes 校验签名时读取的最大请求体，超出时返回 413，默认 1MB
+func WithHMACMaxBodyBytes(n int64) HMACOption {
+	return func(a *HMACAuthenticator) {
+		a.maxBody = n
+	}
+}
+
+func NewHMACAuthenticator(secrets SecretFunc, client *redis.Client, opts ...HMACOption) *HMACAuthenticator {
+	a := &HMACAuthenticator{
+		secrets: secrets,
+		client:  client,
+		maxSkew: 5 * time.Minute,
+		prefix:  "auth:nonce:",
+		maxBody: 1 << 20,
+		now:     time.Now,
+		nonces:  map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	keyId := r.Header.Get(HeaderKeyId)
+	if keyId == "" {
+		return nil, ErrNoCredentials
+	}
+	ctx := r.Context()
+
+	ts := r.Header.Get(HeaderTimestamp)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if d := a.now().Sub(time.Unix(sec, 0)); d > a.maxSkew || d < -a.maxSkew {
+		return nil, ErrRequestExpired
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" || len(nonce) > 64 {
+		return nil, ErrInvalidSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	secret, err := a.secrets(ctx, keyId)
+	if err != nil {
+		xlog.S(ctx).Infow("获取签名密钥失败", "keyId", keyId, "err", err)
+		return nil, ErrInvalidSignature
+	}
+	// 签名校验前读取请求体，限制大小避免未认证的请求占用内存
+	body, err := readBody(r, a.maxBody)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signature(r, ts, nonce, body, secret), sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	// 签名通过后再记录 nonce，避免伪造的请求占用 nonce
+	fresh, err := a.useNonce(ctx, keyId+":"+nonce)
+	if err != nil {
+		return nil, herror.ErrUnavailable.WithCause(err)
+	}
+	if !fresh {
+		return nil, ErrReplayedRequest
+	}
+	return &Principal{Subject: keyId, Method: MethodHMAC}, nil
+}
+
+// useNonce nonce 第一次使用时返回 true
+func (a *HMACAuthenticator) useNonce(ctx context.Context, nonce string) (bool, error) {
+	ttl := 2 * a.maxSkew
+	if a.client != nil {
+		return xrds.Trace(ctx, a.client).SetNX(a.prefix+nonce, 1, ttl).Result()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	if exp, ok := a.nonces[nonce]; ok && now.Before(exp) {
+		return false, nil
+	}
+	if now.Sub(a.purged) > ttl {
+		a.purged = now
+		for k, exp := range a.nonces {
+			if !now.Before(exp) {
+				delete(a.nonces, k)
+			}
+		}
+	}
+	a.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// JWKSFile 从 JWKS 文件加载密钥，文件修改后自动重新加载，轮换密钥时只需更新文件。
+// 支持 kty 为 RSA 和 oct 的密钥
+type JWKSFile struct {
+	path     string
+	interval time.Duration
+
+	mu      sync.RWMutex
+	keys    map[string]interface{}
+	modTime time.Time
+	checked time.Time
+}
+
+// NewJWKSFile 加载 JWKS 文件，之后每隔 interval 检查一次文件是否修改
+func NewJWKSFile(path string, interval time.Duration) (*JWKSFile, error) {
+	f := &JWKSFile{path: path, interval: interval}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *JWKSFile) Key(kid string) (interface{}, error) {
+	f.mu.RLock()
+	stale := time.Since(f.checked) >= f.interval
+	f.mu.RUnlock()
+	if stale {
+		f.reload()
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if key, ok := f.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// reload 文件修改时间变化才重新解析，失败时继续使用旧的密钥
+func (f *JWKSFile) reload() {
+	f.mu.Lock()
+	f.checked = time.Now()
+	modTime := f.modTime
+	f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+	if err := f.load(); err != nil {
+		xlog.S(context.Background()).Warnw("重新加载 JWKS 文件失败", "path", f.path, "err", err)
+	}
+}
+
+func (f *JWKSFile) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(b)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = keys
+	f.modTime = info.ModTime()
+	f.checked = time.Now()
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS 解析 JWKS，返回 kid 到密钥的映射，忽略用途不是签名的密钥
+func ParseJWKS(b []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("auth: jwk %s: invalid n: %w", k.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("auth: jwk %s: invalid e: %w", k.Kid, err)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("auth: jwk %s: invalid k: %w", k.Kid, err)
+			}
+			keys[k.Kid] = secret
+		}
+	}
+	return keys, nil
+}
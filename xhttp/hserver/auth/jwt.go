@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("token 无效")
+	ErrTokenExpired = errors.New("token 已过期")
+	ErrUnknownKey   = errors.New("token 签名密钥不存在")
+)
+
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// KeySet 根据 JWT 头中的 kid 查找验签密钥，HS 算法为 []byte，RS 算法为 *rsa.PublicKey
+type KeySet interface {
+	Key(kid string) (interface{}, error)
+}
+
+// StaticKeys 固定的密钥，key 为 kid，kid 为空的密钥匹配所有 token
+type StaticKeys map[string]interface{}
+
+func (s StaticKeys) Key(kid string) (interface{}, error) {
+	if key, ok := s[kid]; ok {
+		return key, nil
+	}
+	if key, ok := s[""]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// HMACKey 使用单个 HS 密钥
+func HMACKey(secret []byte) StaticKeys {
+	return StaticKeys{"": secret}
+}
+
+// JWTAuthenticator 校验 Authorization: Bearer 中的 JWT，支持 HS256/384/512 和 RS256/384/512
+type JWTAuthenticator struct {
+	keys     KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	cookie   string
+	// 拒绝没有 exp 的 token，默认开启
+	requireExp bool
+	now        func() time.Time
+}
+
+type JWTOption func(*JWTAuthenticator)
+
+// WithIssuer 校验 iss
+func WithIssuer(issuer string) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.issuer = issuer
+	}
+}
+
+// WithAudience 校验 aud 包含 audience
+func WithAudience(audience string) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.audience = audience
+	}
+}
+
+// WithLeeway 校验 exp、nbf 时允许的时钟误差
+func WithLeeway(leeway time.Duration) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.leeway = leeway
+	}
+}
+
+// WithTokenCookie 没有 Authorization 头时从 cookie 中读取 token
+func WithTokenCookie(name string) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.cookie = name
+	}
+}
+
+// WithRequireExp 是否拒绝没有 exp 的 token，默认拒绝，永久有效的 token 泄露后无法失效
+func WithRequireExp(require bool) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.requireExp = require
+	}
+}
+
+func NewJWTAuthenticator(keys KeySet, opts ...JWTOption) *JWTAuthenticator {
+	a := &JWTAuthenticator{keys: keys, requireExp: true, now: time.Now}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if token == "" && a.cookie != "" {
+		if c, err := r.Cookie(a.cookie); err == nil {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	return &Principal{Subject: sub, Method: MethodJWT, Scopes: claimScopes(claims), Claims: claims}, nil
+}
+
+// Parse 校验签名、exp、nbf、iss、aud，返回 claims，默认拒绝没有 exp 的 token，见 WithRequireExp
+func (a *JWTAuthenticator) Parse(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	key, err := a.keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !verify(header.Alg, hash, key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := a.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) validate(claims map[string]interface{}) error {
+	now := a.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok && a.requireExp {
+		return ErrInvalidToken
+	}
+	if ok && now.After(time.Unix(int64(exp), 0).Add(a.leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return ErrInvalidToken
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return ErrInvalidToken
+	}
+	if a.audience != "" && !hasAudience(claims["aud"], a.audience) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// verify 密钥类型必须与算法匹配，避免用 RSA 公钥作为 HS 密钥伪造签名
+func verify(alg string, hash crypto.Hash, key interface{}, signed, sig []byte) bool {
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(alg, "HS") {
+			return false
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return false
+		}
+		h := hash.New()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(k, hash, h.Sum(nil), sig) == nil
+	}
+	return false
+}
+
+// Sign 签发 JWT，HS 算法 key 为 []byte，RS 算法 key 为 *rsa.PrivateKey
+func Sign(alg, kid string, key interface{}, claims map[string]interface{}) (string, error) {
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return "", fmt.Errorf("auth: unsupported alg %s", alg)
+	}
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		d := hash.New()
+		d.Write([]byte(signed))
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, d.Sum(nil)); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("auth: unsupported key type %T", key)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimScopes 支持空格分隔的 scope 和数组形式的 scp、scopes
+func claimScopes(claims map[string]interface{}) []string {
+	if s, ok := claims["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	for _, name := range []string{"scp", "scopes"} {
+		if arr, ok := claims[name].([]interface{}); ok {
+			var scopes []string
+			for _, v := range arr {
+				if s, ok := v.(string); ok {
+					scopes = append(scopes, s)
+				}
+			}
+			return scopes
+		}
+	}
+	return nil
+}
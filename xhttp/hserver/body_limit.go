@@ -0,0 +1,59 @@
+package hserver
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+// BodyLimitMiddleware 限制所有请求的请求体大小，单个路由可以使用 WithRouteMaxBodyBytes
+type BodyLimitMiddleware struct {
+	maxBytes int64
+}
+
+func NewBodyLimitMiddleware(maxBytes int64) *BodyLimitMiddleware {
+	return &BodyLimitMiddleware{maxBytes: maxBytes}
+}
+
+func (m *BodyLimitMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if limitBody(rw, r, m.maxBytes) {
+		next(rw, r)
+	}
+}
+
+// limitBody Content-Length 已超出限制时直接返回 413，否则限制 r.Body 的可读长度，
+// 读取超出部分时返回 herror.ErrEntityTooLarge，handler 原样返回该错误即可得到 413 响应
+func limitBody(rw http.ResponseWriter, r *http.Request, maxBytes int64) bool {
+	if r.ContentLength > maxBytes {
+		WriteError(rw, r, herror.ErrEntityTooLarge)
+		return false
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &maxBytesReader{ReadCloser: r.Body, remain: maxBytes}
+	}
+	return true
+}
+
+type maxBytesReader struct {
+	io.ReadCloser
+	remain int64
+}
+
+func (b *maxBytesReader) Read(p []byte) (int, error) {
+	if b.remain < 0 {
+		return 0, herror.ErrEntityTooLarge
+	}
+	// 多读一个字节用来判断是否超出限制
+	if int64(len(p)) > b.remain+1 {
+		p = p[:b.remain+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remain {
+		n = int(b.remain)
+		b.remain = -1
+		return n, herror.ErrEntityTooLarge
+	}
+	b.remain -= int64(n)
+	return n, err
+}
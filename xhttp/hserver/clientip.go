@@ -0,0 +1,104 @@
+package hserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP 获取连接的 RemoteAddr，不信任 X-Forwarded-For、X-Real-Ip，
+// 服务在反向代理之后时使用 TrustedProxies.ClientIP
+func ClientIP(r *http.Request) string {
+	return (*TrustedProxies)(nil).ClientIP(r)
+}
+
+// TrustedProxies 可信的反向代理，连接来自这些地址时才使用 X-Forwarded-For、X-Real-Ip，
+// 否则客户端可以伪造请求头绕过按 IP 的限流
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// NewTrustedProxies cidrs 为反向代理的 IP 或 CIDR，如 10.0.0.0/8
+func NewTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	nets, err := parseIPNets(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return &TrustedProxies{nets: nets}, nil
+}
+
+// ClientIP RemoteAddr 为可信代理时，从右向左取 X-Forwarded-For 中第一个不是可信代理的地址，
+// 没有 X-Forwarded-For 时使用 X-Real-Ip，其他情况使用 RemoteAddr
+func (p *TrustedProxies) ClientIP(r *http.Request) string {
+	remote := remoteHost(r)
+	if !p.trusted(remote) {
+		return remote
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				// 无法解析的地址之前的内容不可信
+				return remote
+			}
+			if !p.trusted(hop) || i == 0 {
+				return hop
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-Ip")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return remote
+}
+
+// KeyByIP 按 ClientIP 限流
+func (p *TrustedProxies) KeyByIP(r *http.Request) string {
+	return p.ClientIP(r)
+}
+
+func (p *TrustedProxies) trusted(host string) bool {
+	return p != nil && containsIP(p.nets, net.ParseIP(host))
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseIPNets 解析 IP 或 CIDR，单个 IP 视为 /32 或 /128
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("hserver: 无效的 IP 或 CIDR %s: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package hserver
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+// ErrCodecUnsupported 响应数据不支持该编码，协商时会尝试下一个可接受的编码
+var ErrCodecUnsupported = errors.New("hserver: codec unsupported for response")
+
+// Codec 除 JSON 以外的响应编码，JSON 始终由 SuccRespFactory、ErrRespFactory 处理
+type Codec interface {
+	// ContentTypes 支持的媒体类型，第一个作为响应的 Content-Type
+	ContentTypes() []string
+	// Succ 编码成功响应
+	Succ(data interface{}) ([]byte, error)
+	// Err 编码错误响应
+	Err(env herror.Envelope) ([]byte, error)
+}
+
+// MsgpackCodec 使用 msgp 编码 code/msg/data 结构，data 需要实现 msgp.Marshaler 或为基础类型
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack"}
+}
+
+func (MsgpackCodec) Succ(data interface{}) ([]byte, error) {
+	b := msgp.AppendMapHeader(nil, 3)
+	b = msgp.AppendString(b, "code")
+	b = msgp.AppendInt(b, 0)
+	b = msgp.AppendString(b, "msg")
+	b = msgp.AppendString(b, "succ")
+	b = msgp.AppendString(b, "data")
+	b, err := msgp.AppendIntf(b, data)
+	if err != nil {
+		if _, ok := err.(*msgp.ErrUnsupportedType); ok {
+			return nil, ErrCodecUnsupported
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (MsgpackCodec) Err(env herror.Envelope) ([]byte, error) {
+	size := uint32(3)
+	if len(env.Details) > 0 {
+		size++
+	}
+	if env.Retryable {
+		size++
+	}
+	b := msgp.AppendMapHeader(nil, size)
+	b = msgp.AppendString(b, "code")
+	b = msgp.AppendInt(b, env.Code)
+	b = msgp.AppendString(b, "msg")
+	b = msgp.AppendString(b, env.Msg)
+	b = msgp.AppendString(b, "data")
+	b = msgp.AppendNil(b)
+	if len(env.Details) > 0 {
+		b = msgp.AppendString(b, "details")
+		var err error
+		if b, err = msgp.AppendMapStrIntf(b, env.Details); err != nil {
+			return nil, ErrCodecUnsupported
+		}
+	}
+	if env.Retryable {
+		b = msgp.AppendString(b, "retryable")
+		b = msgp.AppendBool(b, true)
+	}
+	return b, nil
+}
+
+// ProtobufCodec data 为 proto.Message 时直接输出 protobuf 编码，不包含 code/msg 结构，
+// 错误响应仍然使用 JSON
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentTypes() []string {
+	return []string{"application/x-protobuf", "application/protobuf"}
+}
+
+func (ProtobufCodec) Succ(data interface{}) ([]byte, error) {
+	m, ok := data.(proto.Message)
+	if !ok {
+		return nil, ErrCodecUnsupported
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Err(herror.Envelope) ([]byte, error) {
+	return nil, ErrCodecUnsupported
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept 解析 Accept 头，按 q 值从高到低排序
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// negotiate 按 Accept 依次尝试编码，返回 nil 表示使用 JSON 工厂
+func negotiate(r *http.Request, codecs []Codec, encode func(Codec) ([]byte, error)) (body []byte, contentType string, err error) {
+	if len(codecs) == 0 {
+		return nil, "", nil
+	}
+	for _, ar := range parseAccept(r.Header.Get("Accept")) {
+		if ar.mediaType == "*/*" || ar.mediaType == "application/*" || ar.mediaType == "application/json" {
+			return nil, "", nil
+		}
+		for _, c := range codecs {
+			if !containsMediaType(c.ContentTypes(), ar.mediaType) {
+				continue
+			}
+			body, err := encode(c)
+			if err == ErrCodecUnsupported {
+				break
+			}
+			return body, c.ContentTypes()[0], err
+		}
+	}
+	return nil, "", nil
+}
+
+func containsMediaType(types []string, mediaType string) bool {
+	for _, t := range types {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
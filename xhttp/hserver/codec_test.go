@@ -0,0 +1,74 @@
+package hserver
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+
+	"github.com/yituoshiniao/kit/xtype"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	observeLogs(t)
+	s := New(WithCodecs(MsgpackCodec{}, ProtobufCodec{}), WithETag())
+	s.GET("/page", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return &xtype.Pager{Cursor: 2, Size: 10}, nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Header.Set("Accept", "application/x-protobuf, application/msgpack;q=0.9, application/json;q=0.5")
+	rw := serve(s, req)
+	assert.Equal(t, "application/msgpack", rw.Header().Get("Content-Type"))
+
+	v, err := msgp.NewReader(rw.Body).ReadIntf()
+	if assert.NoError(t, err) {
+		m := v.(map[string]interface{})
+		assert.EqualValues(t, 0, m["code"])
+		assert.EqualValues(t, 2, m["data"].(map[string]interface{})["Cursor"])
+	}
+
+	rw = serve(s, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.Equal(t, "application/json; charset=utf-8", rw.Header().Get("Content-Type"))
+	etag := rw.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	req = httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Header.Set("If-None-Match", etag)
+	rw = serve(s, req)
+	assert.Equal(t, http.StatusNotModified, rw.Code)
+	assert.Empty(t, rw.Body.String())
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	observeLogs(t)
+	s := New(WithMiddleware(NewCompressMiddleware(WithCompressMinSize(64))))
+	s.GET("/big", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return strings.Repeat("a", 100), nil
+	})
+	s.GET("/small", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return "a", nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/big", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip")
+	rw := serve(s, req)
+	assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(rw.Body)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(zr)
+		assert.Contains(t, string(body), strings.Repeat("a", 100))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rw = serve(s, req)
+	assert.Empty(t, rw.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, `{"code":0,"msg":"succ","data":"a"}`, rw.Body.String())
+}
@@ -0,0 +1,220 @@
+package hserver
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 默认开始压缩的响应体大小
+const defaultCompressMinSize = 1024
+
+// CompressMiddleware 根据 Accept-Encoding 使用 gzip 或 deflate 压缩响应，
+// 响应体小于 minSize、已经设置 Content-Encoding 或内容类型不适合压缩时原样输出
+type CompressMiddleware struct {
+	level   int
+	minSize int
+}
+
+type CompressOption func(*CompressMiddleware)
+
+// WithCompressLevel 设置压缩级别，取值同 compress/flate
+func WithCompressLevel(level int) CompressOption {
+	return func(m *CompressMiddleware) {
+		m.level = level
+	}
+}
+
+// WithCompressMinSize 设置开始压缩的响应体大小
+func WithCompressMinSize(size int) CompressOption {
+	return func(m *CompressMiddleware) {
+		m.minSize = size
+	}
+}
+
+func NewCompressMiddleware(opts ...CompressOption) *CompressMiddleware {
+	m := &CompressMiddleware{level: flate.DefaultCompression, minSize: defaultCompressMinSize}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *CompressMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	encoding := acceptEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || r.Method == http.MethodHead {
+		next(rw, r)
+		return
+	}
+
+	rw.Header().Add("Vary", "Accept-Encoding")
+	cw := &compressWriter{ResponseWriter: rw, encoding: encoding, level: m.level, minSize: m.minSize}
+	defer cw.Close()
+
+	next(cw, r)
+}
+
+// acceptEncoding 选择支持的压缩算法，优先 gzip
+func acceptEncoding(header string) string {
+	var deflate bool
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		if len(params) > 1 {
+			q := strings.TrimSpace(params[1])
+			if strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+					continue
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter 缓存 minSize 字节后决定是否压缩
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide 写出响应头和缓存的数据，compress 为 false 或响应不适合压缩时原样输出
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	h := w.Header()
+	if compress && w.compressible() {
+		switch w.encoding {
+		case "gzip":
+			w.encoder, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level)
+		case "deflate":
+			w.encoder, _ = flate.NewWriter(w.ResponseWriter, w.level)
+		}
+	}
+	if w.encoder != nil {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// 压缩后内容不同，强 ETag 改为弱 ETag
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := strings.ToLower(h.Get("Content-Type"))
+	if ct == "" {
+		return true
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/octet-stream"} {
+		if strings.HasPrefix(ct, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close 输出剩余数据，未达到 minSize 的响应不压缩
+func (w *compressWriter) Close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			return
+		}
+		_ = w.decide(false)
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+	}
+}
+
+// Flush 流式响应立即开始输出，不再等待 minSize
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		_ = w.decide(true)
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+	}
+	w.decided = true
+	return hijacker.Hijack()
+}
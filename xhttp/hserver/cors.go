@@ -0,0 +1,177 @@
+package hserver
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	// 允许的来源，* 表示全部，支持 https://*.example.com 形式的通配
+	AllowOrigins []string
+	// 预检请求返回的允许方法，为空时使用路由实际注册的方法
+	AllowMethods []string
+	// 预检请求返回的允许请求头，为空时允许浏览器请求的所有请求头
+	AllowHeaders []string
+	// 允许前端读取的响应头
+	ExposeHeaders []string
+	// 是否允许携带 cookie，不能与 AllowOrigins 中的 * 同时使用
+	AllowCredentials bool
+	// 预检结果的缓存时间
+	MaxAge time.Duration
+}
+
+// CORSMiddleware 为跨域请求设置响应头，预检请求直接返回，不经过之后的认证、限流等中间件；
+// 通过 WithCORS 使用时路由不存在的路径返回 404
+type CORSMiddleware struct {
+	conf CORSConfig
+	// methods 返回路径已注册的方法，为空时使用 AllowMethods 或预检请求的方法
+	methods func(path string) []string
+}
+
+// NewCORSMiddleware AllowCredentials 与 AllowOrigins 中的 * 同时使用时 panic，
+// 否则任意网站都可以携带用户的 cookie 发起跨域请求
+func NewCORSMiddleware(conf CORSConfig) *CORSMiddleware {
+	if conf.AllowCredentials {
+		for _, o := range conf.AllowOrigins {
+			if o == "*" {
+				panic("hserver: CORS AllowCredentials 不能与 AllowOrigins * 同时使用")
+			}
+		}
+	}
+	if len(conf.ExposeHeaders) == 0 {
+		conf.ExposeHeaders = []string{"trace-id", "X-Request-Id"}
+	}
+	return &CORSMiddleware{conf: conf}
+}
+
+func (m *CORSMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if isPreflight(r) {
+		m.servePreflight(rw, r)
+		return
+	}
+	origin := r.Header.Get("Origin")
+	h := rw.Header()
+	h.Add("Vary", "Origin")
+	if origin != "" && m.allowOrigin(origin) {
+		m.setAllowOrigin(h, origin)
+		if len(m.conf.ExposeHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(m.conf.ExposeHeaders, ", "))
+		}
+	}
+	next(rw, r)
+}
+
+// isPreflight 浏览器的预检请求，不带 cookie 和 Authorization
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (m *CORSMiddleware) servePreflight(rw http.ResponseWriter, r *http.Request) {
+	var allow string
+	if m.methods != nil {
+		methods := m.methods(r.URL.Path)
+		if len(methods) == 0 {
+			WriteError(rw, r, herror.New(http.StatusNotFound, http.StatusNotFound, "404 page not found"))
+			return
+		}
+		methods = append(methods, http.MethodOptions)
+		sort.Strings(methods)
+		allow = strings.Join(methods, ", ")
+		rw.Header().Set("Allow", allow)
+	}
+	m.preflight(rw, r, allow)
+}
+
+// Preflight 处理预检请求，httprouter 在调用前已经设置了 Allow 头
+func (m *CORSMiddleware) Preflight() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		m.preflight(rw, r, rw.Header().Get("Allow"))
+	})
+}
+
+// preflight allow 为路径已注册的方法
+func (m *CORSMiddleware) preflight(rw http.ResponseWriter, r *http.Request, allow string) {
+	h := rw.Header()
+	origin := r.Header.Get("Origin")
+	reqMethod := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" || reqMethod == "" || !m.allowOrigin(origin) {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	m.setAllowOrigin(h, origin)
+
+	methods := allow
+	if len(m.conf.AllowMethods) > 0 {
+		methods = strings.Join(m.conf.AllowMethods, ", ")
+	} else if methods == "" {
+		methods = reqMethod
+	}
+	h.Set("Access-Control-Allow-Methods", methods)
+
+	if len(m.conf.AllowHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(m.conf.AllowHeaders, ", "))
+	} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if m.conf.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(m.conf.MaxAge.Seconds())))
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (m *CORSMiddleware) setAllowOrigin(h http.Header, origin string) {
+	if m.conf.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+	for _, o := range m.conf.AllowOrigins {
+		if o == "*" {
+			h.Set("Access-Control-Allow-Origin", "*")
+			return
+		}
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+}
+
+func (m *CORSMiddleware) allowOrigin(origin string) bool {
+	for _, o := range m.conf.AllowOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if i := strings.Index(o, "*"); i >= 0 {
+			prefix, suffix := o[:i], o[i+1:]
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// routeMethods 按 httprouter 的 Allow 头格式返回路径已注册的方法
+func routeMethods(router *httprouter.Router) func(path string) []string {
+	return func(path string) []string {
+		var methods []string
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodTrace} {
+			if h, _, _ := router.Lookup(method, path); h != nil {
+				methods = append(methods, method)
+			}
+		}
+		return methods
+	}
+}
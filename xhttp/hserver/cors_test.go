@@ -0,0 +1,63 @@
+package hserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	observeLogs(t)
+	s := New(WithCORS(CORSConfig{
+		AllowOrigins:     []string{"https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+	s.POST("/orders", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return "ok", nil
+	})
+
+	req := httptest.NewRequest(http.MethodOptions, "/orders", nil)
+	req.Header.Set("Origin", "https://m.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type, X-User-Id")
+	rw := serve(s, req)
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+	assert.Equal(t, "https://m.example.com", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rw.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "OPTIONS, POST", rw.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, X-User-Id", rw.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rw.Header().Get("Access-Control-Max-Age"))
+
+	req = httptest.NewRequest(http.MethodPost, "/orders", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	req.Header.Set("X-Request-Id", "req-1")
+	rw = serve(s, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Empty(t, rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "req-1", rw.Header().Get("X-Request-Id"))
+
+	req = httptest.NewRequest(http.MethodOptions, "/missing", nil)
+	req.Header.Set("Origin", "https://m.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, serve(s, req).Code)
+}
+
+func TestCORSCredentialsWithWildcard(t *testing.T) {
+	assert.Panics(t, func() {
+		NewCORSMiddleware(CORSConfig{AllowOrigins: []string{"https://a.example.com", "*"}, AllowCredentials: true})
+	})
+
+	// 不带 cookie 时 * 不回显请求的 Origin
+	m := NewCORSMiddleware(CORSConfig{AllowOrigins: []string{"*"}})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, req, func(http.ResponseWriter, *http.Request) {})
+	assert.Equal(t, "*", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rw.Header().Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,215 @@
+package hserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+var startTime = time.Now()
+
+// 配置中 key 包含以下内容时值会被隐藏，不区分大小写
+var defaultMaskKeys = []string{"password", "passwd", "secret", "token", "dsn", "credential", "apikey", "accesskey", "privatekey"}
+
+// DebugConfig 调试接口配置，包含 pprof、expvar、goroutine、构建信息、配置、日志级别、路由表、健康检查详情和摘流。
+// AllowIPs 和 Token 都为空时 DebugHandler 只允许本机访问，WithDebug 要求至少设置其中一个
+type DebugConfig struct {
+	// 路径前缀，默认 /debug
+	Prefix string
+	// 允许访问的 IP 或 CIDR，按连接的 RemoteAddr 判断，不信任 X-Forwarded-For
+	AllowIPs []string
+	// 不为空时允许通过 Authorization: Bearer <Token> 访问，不支持 query 参数，避免 token 出现在访问日志中
+	Token string
+	// 生效的配置，输出时隐藏密码等敏感字段
+	Config interface{}
+	// 追加需要隐藏的配置 key
+	MaskKeys []string
+}
+
+func (c DebugConfig) prefix() string {
+	if c.Prefix == "" {
+		return "/debug"
+	}
+	return strings.TrimSuffix(c.Prefix, "/")
+}
+
+// DebugHandler 返回调试接口，可以用 http.ListenAndServe 在单独的端口提供服务，
+// 避免 profile、trace 等耗时请求受 WriteTimeout 限制
+func (s *Server) DebugHandler(conf DebugConfig) http.Handler {
+	prefix := conf.prefix()
+	guard := newDebugGuard(conf)
+	maskKeys := append(append([]string{}, defaultMaskKeys...), conf.MaskKeys...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/pprof/", func(rw http.ResponseWriter, r *http.Request) {
+		servePprof(rw, r, prefix, strings.TrimPrefix(r.URL.Path, prefix+"/pprof/"))
+	})
+	mux.HandleFunc(prefix+"/goroutines", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = runtimepprof.Lookup("goroutine").WriteTo(rw, 2)
+	})
+	mux.HandleFunc(prefix+"/vars", serveExpvar)
+	mux.Handle(prefix+"/loglevel", xlog.Level())
+	mux.HandleFunc(prefix+"/build", func(rw http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(rw, buildInfo())
+	})
+	mux.HandleFunc(prefix+"/config", func(rw http.ResponseWriter, r *http.Request) {
+		conf, err := maskConfig(conf.Config, maskKeys)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDebugJSON(rw, conf)
+	})
+	mux.HandleFunc(prefix+"/routes", func(rw http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(rw, s.routeTable())
+	})
+	if s.options.Health != nil {
+		mux.Handle(prefix+"/health", s.options.Health.DetailHandler())
+		mux.Handle(prefix+"/drain", s.options.Health.DrainHandler())
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !guard.allow(r) {
+			xlog.S(r.Context()).Warnw("拒绝访问调试接口", "remoteAddr", r.RemoteAddr, "path", r.URL.Path)
+			http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(rw, r)
+	})
+}
+
+// debugGuard 调试接口的访问控制
+type debugGuard struct {
+	nets  []*net.IPNet
+	token string
+}
+
+func newDebugGuard(conf DebugConfig) *debugGuard {
+	g := &debugGuard{token: conf.Token}
+	allow := conf.AllowIPs
+	if len(allow) == 0 && conf.Token == "" {
+		allow = []string{"127.0.0.0/8", "::1/128"}
+	}
+	nets, err := parseIPNets(allow)
+	if err != nil {
+		panic("hserver: 无效的调试接口 AllowIPs: " + err.Error())
+	}
+	g.nets = nets
+	return g
+}
+
+func (g *debugGuard) allow(r *http.Request) bool {
+	if g.token != "" {
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token != auth && subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1 {
+			return true
+		}
+	}
+	return containsIP(g.nets, net.ParseIP(remoteHost(r)))
+}
+
+// routeInfo 路由表中的一项
+type routeInfo struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Summary string `json:"summary,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	Hidden  bool   `json:"hidden,omitempty"`
+}
+
+func (s *Server) routeTable() []routeInfo {
+	routes := make([]routeInfo, 0, len(s.routes))
+	for _, r := range s.routes {
+		info := routeInfo{Method: r.Method, Path: r.Path, Summary: r.summary, Hidden: r.hidden}
+		if r.timeout > 0 {
+			info.Timeout = r.timeout.String()
+		}
+		routes = append(routes, info)
+	}
+	return routes
+}
+
+func buildInfo() map[string]interface{} {
+	hostname, _ := os.Hostname()
+	info := map[string]interface{}{
+		"goVersion":  runtime.Version(),
+		"goos":       runtime.GOOS,
+		"goarch":     runtime.GOARCH,
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"goroutines": runtime.NumGoroutine(),
+		"hostname":   hostname,
+		"pid":        os.Getpid(),
+		"startTime":  startTime,
+		"uptime":     time.Since(startTime).Round(time.Second).String(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["path"] = bi.Path
+		info["main"] = bi.Main
+		info["deps"] = bi.Deps
+	}
+	return info
+}
+
+// maskConfig 通过 JSON 转换后隐藏敏感字段，不修改原配置
+func maskConfig(conf interface{}, keys []string) (interface{}, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return maskValue(v, keys), nil
+}
+
+func maskValue(v interface{}, keys []string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if sensitiveKey(k, keys) {
+				if child != nil && child != "" {
+					t[k] = "******"
+				}
+				continue
+			}
+			t[k] = maskValue(child, keys)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = maskValue(t[i], keys)
+		}
+	}
+	return v
+}
+
+func sensitiveKey(key string, keys []string) bool {
+	key = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, k := range keys {
+		if strings.Contains(key, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeDebugJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,161 @@
+package hserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 不引用 net/http/pprof，它的 init 会在 http.DefaultServeMux 上注册没有访问控制的 /debug/pprof/
+
+// servePprof 输出 pprof 的 profile，name 为 /pprof/ 之后的部分
+func servePprof(rw http.ResponseWriter, r *http.Request, prefix, name string) {
+	switch name {
+	case "":
+		pprofIndex(rw, prefix)
+	case "cmdline":
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprint(rw, strings.Join(os.Args, "\x00"))
+	case "profile":
+		pprofCPU(rw, r)
+	case "trace":
+		pprofTrace(rw, r)
+	case "symbol":
+		pprofSymbol(rw, r)
+	default:
+		pprofLookup(rw, r, name)
+	}
+}
+
+func pprofIndex(rw http.ResponseWriter, prefix string) {
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+
+	var b bytes.Buffer
+	b.WriteString("<html><head><title>" + prefix + "/pprof/</title></head><body>\n<table>\n")
+	for _, p := range profiles {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(&b, "<tr><td>%d</td><td><a href=\"%s?debug=1\">%s</a></td></tr>\n", p.Count(), name, name)
+	}
+	b.WriteString("<tr><td></td><td><a href=\"profile\">profile</a></td></tr>\n")
+	b.WriteString("<tr><td></td><td><a href=\"trace?seconds=1\">trace</a></td></tr>\n")
+	b.WriteString("</table>\n</body></html>\n")
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = rw.Write(b.Bytes())
+}
+
+// pprofSeconds 采集时长，默认 def 秒，挂在 Server 上时受 WriteTimeout 限制
+func pprofSeconds(r *http.Request, def int) time.Duration {
+	sec, err := strconv.Atoi(r.FormValue("seconds"))
+	if err != nil || sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec) * time.Second
+}
+
+func setAttachment(rw http.ResponseWriter, name string) {
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+}
+
+// waitProfile 等待采集结束，请求取消时提前结束
+func waitProfile(r *http.Request, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+func pprofCPU(rw http.ResponseWriter, r *http.Request) {
+	d := pprofSeconds(r, 30)
+	setAttachment(rw, "profile")
+	if err := pprof.StartCPUProfile(rw); err != nil {
+		rw.Header().Del("Content-Disposition")
+		http.Error(rw, "无法开启 CPU profile: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	waitProfile(r, d)
+	pprof.StopCPUProfile()
+}
+
+func pprofTrace(rw http.ResponseWriter, r *http.Request) {
+	d := pprofSeconds(r, 1)
+	setAttachment(rw, "trace")
+	if err := trace.Start(rw); err != nil {
+		rw.Header().Del("Content-Disposition")
+		http.Error(rw, "无法开启 trace: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	waitProfile(r, d)
+	trace.Stop()
+}
+
+// pprofSymbol 按 go tool pprof 的协议将 + 分隔的十六进制地址转换为函数名
+func pprofSymbol(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var b bytes.Buffer
+	b.WriteString("num_symbols: 1\n")
+
+	var input string
+	if r.Method == http.MethodPost {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<20))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		input = string(body)
+	} else {
+		input = r.URL.RawQuery
+	}
+	for _, word := range strings.Split(input, "+") {
+		pc, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(word), "0x"), 16, 64)
+		if err != nil || pc == 0 {
+			continue
+		}
+		if fn := runtime.FuncForPC(uintptr(pc)); fn != nil {
+			fmt.Fprintf(&b, "%#x %s\n", pc, fn.Name())
+		}
+	}
+	_, _ = rw.Write(b.Bytes())
+}
+
+func pprofLookup(rw http.ResponseWriter, r *http.Request, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(rw, "未知的 profile "+name, http.StatusNotFound)
+		return
+	}
+	debugLevel, _ := strconv.Atoi(r.FormValue("debug"))
+	if name == "heap" && r.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	if debugLevel != 0 {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		setAttachment(rw, name)
+	}
+	_ = p.WriteTo(rw, debugLevel)
+}
+
+// serveExpvar 输出 expvar 注册的变量，格式与 expvar.Handler 相同
+func serveExpvar(rw http.ResponseWriter, _ *http.Request) {
+	vars := map[string]json.RawMessage{}
+	expvar.Do(func(kv expvar.KeyValue) {
+		vars[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+	writeDebugJSON(rw, vars)
+}
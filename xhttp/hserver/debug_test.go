@@ -0,0 +1,129 @@
+package hserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhealth"
+)
+
+func TestDebug(t *testing.T) {
+	type redisConf struct {
+		Addr     string `json:"addr"`
+		Password string `json:"password"`
+	}
+	conf := map[string]interface{}{
+		"redis": redisConf{Addr: "127.0.0.1:6379", Password: "p"},
+		"db":    map[string]string{"dsn": "root:p@tcp(db)/app", "max_open": "10"},
+	}
+	s := New(WithDebug(DebugConfig{AllowIPs: []string{"10.0.0.0/8"}, Token: "t0ken", Config: conf}))
+	s.GET("/users/:id", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return nil, nil
+	}, WithRouteSummary("查询用户"))
+
+	get := func(path, remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(s, req)
+	}
+
+	assert.Equal(t, http.StatusForbidden, get("/debug/routes", "192.168.1.1:1234", "").Code)
+	assert.Equal(t, http.StatusForbidden, get("/debug/routes", "192.168.1.1:1234", "wrong").Code)
+	// X-Forwarded-For 不能绕过 IP 限制
+	req := httptest.NewRequest(http.MethodGet, "/debug/routes", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	assert.Equal(t, http.StatusForbidden, serve(s, req).Code)
+
+	rw := get("/debug/routes", "10.1.2.3:1234", "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var routes []routeInfo
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &routes))
+	assert.Contains(t, routes, routeInfo{Method: http.MethodGet, Path: "/users/:id", Summary: "查询用户"})
+
+	rw = get("/debug/config", "192.168.1.1:1234", "t0ken")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.JSONEq(t, `{"redis":{"addr":"127.0.0.1:6379","password":"******"},"db":{"dsn":"******","max_open":"10"}}`, rw.Body.String())
+
+	rw = get("/debug/pprof/", "10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), "goroutine")
+	rw = get("/debug/pprof/heap?debug=1", "10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, strings.HasPrefix(rw.Body.String(), "heap profile"))
+	assert.Contains(t, get("/debug/goroutines", "10.0.0.1:1234", "").Body.String(), "goroutine")
+	assert.Contains(t, get("/debug/build", "10.0.0.1:1234", "").Body.String(), "goVersion")
+	assert.Contains(t, get("/debug/vars", "10.0.0.1:1234", "").Body.String(), "memstats")
+	assert.Contains(t, get("/debug/loglevel", "10.0.0.1:1234", "").Body.String(), "level")
+}
+
+func TestDebugHandlerLocalOnly(t *testing.T) {
+	h := New().DebugHandler(DebugConfig{})
+	req := httptest.NewRequest(http.MethodGet, "/debug/build", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	req.RemoteAddr = "10.0.0.1:1234"
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	assert.Equal(t, http.StatusForbidden, rw.Code)
+}
+
+func TestDebugHealthDetail(t *testing.T) {
+	observeLogs(t)
+	registry := xhealth.New()
+	registry.Register("db", func(ctx context.Context) error { return errors.New("dial tcp db:3306: connection refused") })
+	s := New(WithHealth(registry), WithDebug(DebugConfig{AllowIPs: []string{"127.0.0.1"}}))
+
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	assert.NotContains(t, rw.Body.String(), "db:3306")
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/health", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rw = serve(s, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), "db:3306")
+
+	req = httptest.NewRequest(http.MethodPost, "/debug/drain", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rw = serve(s, req)
+	assert.JSONEq(t, `{"draining":true}`, rw.Body.String())
+	assert.True(t, registry.Draining())
+}
+
+func TestDebugNoDefaultServeMux(t *testing.T) {
+	// 调试接口不注册到 http.DefaultServeMux
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap"} {
+		_, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Empty(t, pattern, path)
+	}
+
+	// 与业务共用端口时必须设置 Token 或 AllowIPs
+	assert.Panics(t, func() { New(WithDebug(DebugConfig{})) })
+
+	s := New(WithDebug(DebugConfig{Token: "t0ken"}))
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline?token=t0ken", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	assert.Equal(t, http.StatusForbidden, serve(s, req).Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/pprof/trace?seconds=1", nil)
+	req.Header.Set("Authorization", "Bearer t0ken")
+	ctx, cancel := context.WithCancel(req.Context())
+	cancel()
+	rw := serve(s, req.WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "application/octet-stream", rw.Header().Get("Content-Type"))
+}
@@ -0,0 +1,41 @@
+package hserver
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// notModified 为 GET、HEAD 响应设置 ETag，If-None-Match 匹配时写出 304 并返回 true
+func notModified(rw http.ResponseWriter, r *http.Request, body []byte) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	rw.Header().Set("ETag", etag)
+
+	if !etagMatch(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	h := rw.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	rw.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch 按弱比较判断 If-None-Match 是否包含 etag
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package hserver
+
+import (
+	"context"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// exchange 记录一次请求在 handler 中产生的响应数据和错误，
+// 由外层中间件在 next 返回后读取，替代原先覆盖 *r 的做法
+type exchange struct {
+	resp interface{}
+	err  error
+	// 当前 Server 的 ErrRespFactory，中间件通过 WriteError 输出错误时使用
+	trans ErrRespFactory
+	// 当前 Server 配置的非 JSON 编码
+	codecs []Codec
+	// 内层中间件追加到响应日志的字段
+	fields []zap.Field
+}
+
+type exchangeKey struct{}
+
+// withExchange 返回携带 exchange 的请求，如果外层中间件已经创建过则直接复用
+func withExchange(r *http.Request) (*http.Request, *exchange) {
+	if ex, ok := r.Context().Value(exchangeKey{}).(*exchange); ok {
+		return r, ex
+	}
+	ex := &exchange{}
+	return r.WithContext(exchangeCtx{Context: r.Context(), ex: ex}), ex
+}
+
+// exchangeCtx 携带 exchange 的 context，兼容通过 ErrKey、RespKey 读取 handler 结果的旧代码
+type exchangeCtx struct {
+	context.Context
+	ex *exchange
+}
+
+func (c exchangeCtx) Value(key interface{}) interface{} {
+	switch key {
+	case exchangeKey{}:
+		return c.ex
+	case ErrKey:
+		if c.ex.err != nil {
+			return c.ex.err
+		}
+	case RespKey:
+		if c.ex.resp != nil {
+			return c.ex.resp
+		}
+	}
+	return c.Context.Value(key)
+}
+
+func exchangeFrom(ctx context.Context) *exchange {
+	ex, _ := ctx.Value(exchangeKey{}).(*exchange)
+	return ex
+}
+
+// recordResult 记录 handler 的处理结果
+func recordResult(ctx context.Context, resp interface{}, err error) {
+	if ex := exchangeFrom(ctx); ex != nil {
+		ex.resp = resp
+		ex.err = err
+	}
+}
+
+// ErrorFromContext 获取 handler 返回的错误，只在中间件的 next 返回后有值，兼容旧代码通过 ErrKey 写入的错误
+func ErrorFromContext(ctx context.Context) error {
+	if ex := exchangeFrom(ctx); ex != nil && ex.err != nil {
+		return ex.err
+	}
+	err, _ := ctx.Value(ErrKey).(error)
+	return err
+}
+
+// RespFromContext 获取 handler 返回的响应数据，只在中间件的 next 返回后有值，兼容旧代码通过 RespKey 写入的数据
+func RespFromContext(ctx context.Context) interface{} {
+	if ex := exchangeFrom(ctx); ex != nil && ex.resp != nil {
+		return ex.resp
+	}
+	return ctx.Value(RespKey)
+}
+
+// AddLogFields 向 LogMiddleware 的响应日志追加字段，用于在内层中间件中记录认证用户等信息
+func AddLogFields(ctx context.Context, fields ...zap.Field) {
+	if ex := exchangeFrom(ctx); ex != nil {
+		ex.fields = append(ex.fields, fields...)
+	}
+}
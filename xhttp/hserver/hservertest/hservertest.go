@@ -0,0 +1,302 @@
+// Package hservertest 在进程内通过完整的中间件调用 hserver.Server，断言响应状态、业务码、响应头和日志
+package hservertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// AccessLogMessage LogMiddleware 输出的响应日志
+const AccessLogMessage = "发送响应[http.server]"
+
+// Harness 测试用的调用方，创建时替换全局 logger 用于断言日志，使用 Harness 的测试不能并行执行
+type Harness struct {
+	t       testing.TB
+	handler http.Handler
+	logs    *observer.ObservedLogs
+	header  http.Header
+
+	codeKey string
+	msgKey  string
+	dataKey string
+}
+
+type Option func(*Harness)
+
+// WithEnvelopeKeys 自定义 SuccFactory 时设置响应结构的字段名，默认 code、msg、data
+func WithEnvelopeKeys(code, msg, data string) Option {
+	return func(h *Harness) {
+		h.codeKey, h.msgKey, h.dataKey = code, msg, data
+	}
+}
+
+// WithHeader 每个请求都带上的请求头，如鉴权信息
+func WithHeader(key, value string) Option {
+	return func(h *Harness) {
+		h.header.Add(key, value)
+	}
+}
+
+// New handler 一般为 *hserver.Server，测试结束后恢复全局 logger。
+// 没有设置全局 tracer 时使用不上报的 jaeger tracer，使响应带有 trace-id
+func New(t testing.TB, handler http.Handler, opts ...Option) *Harness {
+	core, logs := observer.New(zapcore.DebugLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+	if _, ok := opentracing.GlobalTracer().(opentracing.NoopTracer); ok {
+		tracer, closer := jaeger.NewTracer("hservertest", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+		opentracing.SetGlobalTracer(tracer)
+		t.Cleanup(func() {
+			opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+			_ = closer.Close()
+		})
+	}
+
+	h := &Harness{
+		t:       t,
+		handler: handler,
+		logs:    logs,
+		header:  http.Header{},
+		codeKey: "code",
+		msgKey:  "msg",
+		dataKey: "data",
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Logs 所有请求输出的日志
+func (h *Harness) Logs() *observer.ObservedLogs {
+	return h.logs
+}
+
+func (h *Harness) GET(path string) *Request {
+	return h.NewRequest(http.MethodGet, path)
+}
+
+func (h *Harness) POST(path string) *Request {
+	return h.NewRequest(http.MethodPost, path)
+}
+
+func (h *Harness) PUT(path string) *Request {
+	return h.NewRequest(http.MethodPut, path)
+}
+
+func (h *Harness) PATCH(path string) *Request {
+	return h.NewRequest(http.MethodPatch, path)
+}
+
+func (h *Harness) DELETE(path string) *Request {
+	return h.NewRequest(http.MethodDelete, path)
+}
+
+func (h *Harness) NewRequest(method, path string) *Request {
+	return &Request{h: h, method: method, path: path, header: h.header.Clone(), query: url.Values{}}
+}
+
+// Request 请求构造器
+type Request struct {
+	h      *Harness
+	method string
+	path   string
+	header http.Header
+	query  url.Values
+	body   io.Reader
+}
+
+func (r *Request) Header(key, value string) *Request {
+	r.header.Add(key, value)
+	return r
+}
+
+func (r *Request) Query(key, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+// JSON 请求体编码为 JSON
+func (r *Request) JSON(v interface{}) *Request {
+	b, err := json.Marshal(v)
+	if err != nil {
+		r.h.t.Fatalf("hservertest: 编码请求体失败: %v", err)
+	}
+	r.header.Set("Content-Type", "application/json")
+	r.body = bytes.NewReader(b)
+	return r
+}
+
+func (r *Request) Body(contentType string, body []byte) *Request {
+	r.header.Set("Content-Type", contentType)
+	r.body = bytes.NewReader(body)
+	return r
+}
+
+// Do 经过完整的中间件处理请求
+func (r *Request) Do() *Response {
+	r.h.t.Helper()
+	target := r.path
+	if len(r.query) > 0 {
+		target += "?" + r.query.Encode()
+	}
+	req := httptest.NewRequest(r.method, target, r.body)
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	rw := httptest.NewRecorder()
+	r.h.handler.ServeHTTP(rw, req)
+	return &Response{h: r.h, Recorder: rw}
+}
+
+// Response 响应，Assert 开头的方法失败时标记测试失败并继续执行
+type Response struct {
+	h        *Harness
+	Recorder *httptest.ResponseRecorder
+
+	decoded  bool
+	envelope map[string]json.RawMessage
+}
+
+// Status HTTP 状态码
+func (r *Response) Status() int {
+	return r.Recorder.Code
+}
+
+// Header 响应头
+func (r *Response) Header() http.Header {
+	return r.Recorder.Header()
+}
+
+// TraceId 响应头中的 trace-id
+func (r *Response) TraceId() string {
+	return r.Recorder.Header().Get("trace-id")
+}
+
+// Body 原始响应体
+func (r *Response) Body() []byte {
+	return r.Recorder.Body.Bytes()
+}
+
+func (r *Response) field(key string) json.RawMessage {
+	if !r.decoded {
+		r.decoded = true
+		_ = json.Unmarshal(r.Recorder.Body.Bytes(), &r.envelope)
+	}
+	return r.envelope[key]
+}
+
+// Code 响应结构中的业务码
+func (r *Response) Code() int {
+	var code int
+	_ = json.Unmarshal(r.field(r.h.codeKey), &code)
+	return code
+}
+
+// Msg 响应结构中的提示信息
+func (r *Response) Msg() string {
+	var msg string
+	_ = json.Unmarshal(r.field(r.h.msgKey), &msg)
+	return msg
+}
+
+// Data 解析响应结构中的 data
+func (r *Response) Data(v interface{}) error {
+	data := r.field(r.h.dataKey)
+	if data == nil {
+		return json.Unmarshal(r.Recorder.Body.Bytes(), v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Error 按 herror.Envelope 还原错误，成功响应返回 nil
+func (r *Response) Error() *herror.Error {
+	return herror.Decode(r.Recorder.Code, r.Recorder.Body.Bytes())
+}
+
+// Logs 当前请求输出的日志，按 trace-id 过滤
+func (r *Response) Logs() []observer.LoggedEntry {
+	traceId := r.TraceId()
+	return r.h.logs.Filter(func(e observer.LoggedEntry) bool {
+		return traceId != "" && e.ContextMap()["traceId"] == traceId
+	}).All()
+}
+
+// LogFields 当前请求中 msg 日志的 xlog 字段，没有时返回 nil
+func (r *Response) LogFields(msg string) map[string]interface{} {
+	for _, e := range r.Logs() {
+		if e.Message == msg {
+			fields, _ := e.ContextMap()[xlog.LogField].(map[string]interface{})
+			return fields
+		}
+	}
+	return nil
+}
+
+func (r *Response) AssertStatus(status int) *Response {
+	r.h.t.Helper()
+	assert.Equal(r.h.t, status, r.Recorder.Code, "响应: %s", r.Recorder.Body.String())
+	return r
+}
+
+func (r *Response) AssertCode(code int) *Response {
+	r.h.t.Helper()
+	assert.Equal(r.h.t, code, r.Code(), "响应: %s", r.Recorder.Body.String())
+	return r
+}
+
+func (r *Response) AssertMsg(msg string) *Response {
+	r.h.t.Helper()
+	assert.Equal(r.h.t, msg, r.Msg())
+	return r
+}
+
+// AssertData data 与 expected 编码后的 JSON 相同
+func (r *Response) AssertData(expected interface{}) *Response {
+	r.h.t.Helper()
+	b, err := json.Marshal(expected)
+	if assert.NoError(r.h.t, err) {
+		assert.JSONEq(r.h.t, string(b), string(r.field(r.h.dataKey)))
+	}
+	return r
+}
+
+func (r *Response) AssertHeader(key, value string) *Response {
+	r.h.t.Helper()
+	assert.Equal(r.h.t, value, r.Recorder.Header().Get(key), "响应头 %s", key)
+	return r
+}
+
+// AssertTraceId 响应头带有 trace-id
+func (r *Response) AssertTraceId() *Response {
+	r.h.t.Helper()
+	assert.NotEmpty(r.h.t, r.TraceId(), "响应头缺少 trace-id")
+	return r
+}
+
+// AssertLogged 当前请求输出了 msg 日志，并且 xlog 字段包含 fields
+func (r *Response) AssertLogged(msg string, fields map[string]interface{}) *Response {
+	r.h.t.Helper()
+	logged := r.LogFields(msg)
+	if !assert.NotNil(r.h.t, logged, "没有输出日志 %s", msg) {
+		return r
+	}
+	for k, v := range fields {
+		assert.EqualValues(r.h.t, v, logged[k], "日志字段 %s", k)
+	}
+	return r
+}
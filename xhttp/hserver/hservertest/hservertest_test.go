@@ -0,0 +1,69 @@
+package hservertest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xhttp/hserver"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+type user struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestHarness(t *testing.T) {
+	s := hserver.New()
+	s.GET("/users/:id", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		if req.URL.Query().Get("missing") != "" {
+			return nil, herror.ErrNotFound.WithMessage("用户不存在")
+		}
+		xlog.S(ctx).Infow("查询用户", "token", req.Header.Get("X-Token"))
+		return user{Id: "1", Name: "tom"}, nil
+	})
+	s.POST("/users", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		var u user
+		if err := json.NewDecoder(req.Body).Decode(&u); err != nil {
+			return nil, herror.ErrBadRequest.WithCause(err)
+		}
+		hserver.AddLogFields(ctx, zap.String("user", u.Name))
+		return u, nil
+	})
+
+	h := New(t, s, WithHeader("X-Token", "t1"))
+
+	resp := h.GET("/users/1").Do().
+		AssertStatus(http.StatusOK).
+		AssertCode(0).
+		AssertMsg("succ").
+		AssertTraceId().
+		AssertData(user{Id: "1", Name: "tom"}).
+		AssertLogged("查询用户", map[string]interface{}{"token": "t1"}).
+		AssertLogged(AccessLogMessage, map[string]interface{}{"status": 200})
+	var u user
+	assert.NoError(t, resp.Data(&u))
+	assert.Equal(t, "tom", u.Name)
+	assert.Nil(t, resp.Error())
+
+	resp = h.GET("/users/2").Query("missing", "1").Do().
+		AssertStatus(http.StatusNotFound).
+		AssertCode(http.StatusNotFound).
+		AssertMsg("用户不存在")
+	assert.Equal(t, "用户不存在", resp.Error().Message)
+
+	h.POST("/users").JSON(user{Name: "jerry"}).Do().
+		AssertStatus(http.StatusOK).
+		AssertLogged(AccessLogMessage, map[string]interface{}{"user": "jerry"})
+
+	// 不同请求的日志按 trace-id 区分
+	a, b := h.GET("/users/1").Do(), h.GET("/users/1").Do()
+	assert.NotEqual(t, a.TraceId(), b.TraceId())
+	assert.Len(t, a.Logs(), 3)
+}
@@ -0,0 +1,316 @@
+package hserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xhttp/hauth"
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// 幂等请求的处理结果
+const (
+	IdempotencyStored   = "stored"
+	IdempotencyReplayed = "replayed"
+	IdempotencyInFlight = "in_flight"
+	IdempotencyMismatch = "mismatch"
+)
+
+// idempotencyFinishScript 只有仍持有锁时才写入结果，ARGV[2] 为空时删除 key
+var idempotencyFinishScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v or not string.find(v, ARGV[1], 1, true) then
+	return 0
+end
+if ARGV[2] == '' then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+end
+return 1
+`)
+
+// idempotencyRecord 保存在 redis 中的处理状态和响应
+type idempotencyRecord struct {
+	// 处理中的请求持有的锁
+	Token  string      `json:"token,omitempty"`
+	Hash   string      `json:"hash"`
+	Done   bool        `json:"done"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyMiddleware 按 Idempotency-Key 请求头保证写请求只处理一次。
+// 第一个请求处理期间重复的请求返回 409，处理完成后在 ttl 内重放保存的响应，
+// 同一个 key 对应的请求内容不同时返回 422，5xx、408、409、429 和调用方断开时的响应不保存，调用方可以用同一个 key 重试。
+// redis 不可用时与 RateLimitMiddleware 一样放行请求
+type IdempotencyMiddleware struct {
+	client   *redis.Client
+	ttl      time.Duration
+	lockTTL  time.Duration
+	prefix   string
+	maxBody  int
+	maxReq   int64
+	methods  map[string]bool
+	scopeKey RateLimitKeyFunc
+}
+
+type IdempotencyOption func(*IdempotencyMiddleware)
+
+// WithIdempotencyTTL 响应保存的时长，默认 24 小时
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(m *IdempotencyMiddleware) {
+		m.ttl = ttl
+	}
+}
+
+// WithIdempotencyLockTTL 处理中的锁的时长，需要大于 handler 的处理时限，默认 1 分钟
+func WithIdempotencyLockTTL(ttl time.Duration) IdempotencyOption {
+	return func(m *IdempotencyMiddleware) {
+		m.lockTTL = ttl
+	}
+}
+
+// WithIdempotencyKeyPrefix 设置 redis key 前缀，默认 idempotency:
+func WithIdempotencyKeyPrefix(prefix string) IdempotencyOption {
+	return func(m *IdempotencyMiddleware) {
+		m.prefix = prefix
+	}
+}
+
+// WithIdempotencyMethods 需要处理的请求方法，默认 POST、PATCH
+func WithIdempotencyMethods(methods ...string) IdempotencyOption {
+	return func(m *IdempotencyMiddleware) {
+		m.methods = map[string]bool{}
+		for _, method := range methods {
+			m.methods[method] = true
+		}
+	}
+}
+
+// WithIdempotencyScope key 的作用范围，避免不同调用方的 key 冲突，
+// 默认在认证中间件之后按 hauth.Principal 区分，没有认证信息时按 ClientIP 区分
+func WithIdempotencyScope(keyFunc RateLimitKeyFunc) IdempotencyOption {
+	return func(m *IdempotencyMiddleware) {
+		m.scopeKey = keyFunc
+	}
+}
+
+// WithIdempotencyMaxBody 可以保存的最大响应体，超出时不保存，默认 1MB
+func WithIdempotencyMaxBody(n int) IdempotencyOption {
+	return func(m *IdempotencyMiddleware) {
+		m.maxBody = n
+	}
+}
+
+// WithIdempotencyMaxRequestBody 计算请求内容摘要时读取的最大请求体，超出时返回 413，默认 1MB
+func WithIdempotencyMaxRequestBody(n int64) IdempotencyOption {
+	return func(m *IdempotencyMiddleware) {
+		m.maxReq = n
+	}
+}
+
+// DefaultIdempotencyScope 认证通过时按调用方区分，否则按 ClientIP 区分
+func DefaultIdempotencyScope(r *http.Request) string {
+	if p, ok := hauth.FromContext(r.Context()); ok {
+		return "principal:" + p.Method + ":" + p.Subject
+	}
+	return ClientIP(r)
+}
+
+func NewIdempotencyMiddleware(client *redis.Client, opts ...IdempotencyOption) *IdempotencyMiddleware {
+	m := &IdempotencyMiddleware{
+		client:   client,
+		ttl:      24 * time.Hour,
+		lockTTL:  time.Minute,
+		prefix:   "idempotency:",
+		maxBody:  1 << 20,
+		maxReq:   1 << 20,
+		methods:  map[string]bool{http.MethodPost: true, http.MethodPatch: true},
+		scopeKey: DefaultIdempotencyScope,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *IdempotencyMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" || !m.methods[r.Method] {
+		next(rw, r)
+		return
+	}
+	ctx := r.Context()
+	if len(key) > 255 {
+		WriteError(rw, r, herror.ErrBadRequest.WithMessage("Idempotency-Key 过长"))
+		return
+	}
+
+	if !limitBody(rw, r, m.maxReq) {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		// 超出限制时为 herror.ErrEntityTooLarge，返回 413
+		WriteError(rw, r, err)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	hash := payloadHash(r, body)
+
+	redisKey := m.prefix + m.scopeKey(r) + ":" + r.Method + ":" + r.URL.Path + ":" + key
+	client := xrds.Trace(ctx, m.client)
+	token := idempotencyToken()
+	pending, _ := json.Marshal(idempotencyRecord{Token: token, Hash: hash})
+
+	locked, err := client.SetNX(redisKey, pending, m.lockTTL).Result()
+	if err != nil {
+		xlog.S(ctx).Errorw("幂等判断失败，放行请求", "key", redisKey, "err", err)
+		next(rw, r)
+		return
+	}
+	if !locked {
+		m.replay(rw, r, client, redisKey, hash)
+		return
+	}
+
+	header := rw.Header().Clone()
+	rec := newResponseRecorder(rw, m.maxBody)
+	// result 为空时删除 key，允许使用同一个 key 重试；handler panic 时同样释放锁，不需要等到 lockTTL
+	var result []byte
+	defer func() {
+		err := idempotencyFinishScript.Run(client, []string{redisKey}, token, string(result), m.ttl.Milliseconds()).Err()
+		if err != nil {
+			xlog.S(ctx).Errorw("保存幂等结果失败", "key", redisKey, "err", err)
+			return
+		}
+		if result != nil {
+			countIdempotency(r.Method, IdempotencyStored)
+		}
+	}()
+	next(rec, r)
+
+	if body, truncated := rec.Body(); !truncated && finalResult(r, rec.Status()) {
+		result, _ = json.Marshal(idempotencyRecord{
+			Hash:   hash,
+			Done:   true,
+			Status: rec.Status(),
+			Header: headerDiff(header, rec.Header()),
+			Body:   body,
+		})
+	}
+}
+
+// finalResult 响应是否为最终结果：调用方断开、5xx 以及 408、409、429 等可以重试的响应不保存，
+// 否则调用方用同一个 key 重试时只能拿到这些响应，无法知道请求是否处理成功
+func finalResult(r *http.Request, status int) bool {
+	if r.Context().Err() != nil || status >= http.StatusInternalServerError {
+		return false
+	}
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+	return true
+}
+
+func (m *IdempotencyMiddleware) replay(rw http.ResponseWriter, r *http.Request, client *redis.Client, redisKey, hash string) {
+	b, err := client.Get(redisKey).Bytes()
+	if err == redis.Nil {
+		// 刚好处理失败被删除，让调用方重试
+		countIdempotency(r.Method, IdempotencyInFlight)
+		rw.Header().Set("Retry-After", "1")
+		WriteError(rw, r, status.Error(codes.Aborted, "请求正在处理，请稍后重试"))
+		return
+	}
+	var rec idempotencyRecord
+	if err == nil {
+		err = json.Unmarshal(b, &rec)
+	}
+	if err != nil {
+		xlog.S(r.Context()).Errorw("读取幂等结果失败", "key", redisKey, "err", err)
+		WriteError(rw, r, herror.ErrUnavailable.WithCause(err))
+		return
+	}
+
+	if rec.Hash != hash {
+		countIdempotency(r.Method, IdempotencyMismatch)
+		WriteError(rw, r, herror.New(http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, "Idempotency-Key 已用于其他请求"))
+		return
+	}
+	if !rec.Done {
+		countIdempotency(r.Method, IdempotencyInFlight)
+		rw.Header().Set("Retry-After", "1")
+		WriteError(rw, r, status.Error(codes.Aborted, "请求正在处理，请稍后重试"))
+		return
+	}
+
+	countIdempotency(r.Method, IdempotencyReplayed)
+	h := rw.Header()
+	for k, v := range rec.Header {
+		h[k] = v
+	}
+	h.Set(IdempotencyReplayedHeader, "true")
+	rw.WriteHeader(rec.Status)
+	_, _ = rw.Write(rec.Body)
+}
+
+// payloadHash 请求方法、路径、query 和请求体的 sha256
+func payloadHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// headerDiff handler 设置的响应头，不包含 trace-id 等外层中间件设置的头
+func headerDiff(before, after http.Header) http.Header {
+	diff := http.Header{}
+	for k, v := range after {
+		if k == "Set-Cookie" || k == "Date" {
+			continue
+		}
+		if old, ok := before[k]; ok && equalValues(old, v) {
+			continue
+		}
+		diff[k] = v
+	}
+	return diff
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func idempotencyToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,167 @@
+package hserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+
+	"github.com/yituoshiniao/kit/xhttp/hauth"
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	observeLogs(t)
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	var orders, failures int32
+	s := New(WithMiddleware(NewIdempotencyMiddleware(client)))
+	s.POST("/orders", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		return map[string]interface{}{"id": atomic.AddInt32(&orders, 1), "req": string(b)}, nil
+	})
+	s.POST("/fail", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		atomic.AddInt32(&failures, 1)
+		return nil, herror.ErrInternal
+	})
+
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		return serve(s, req)
+	}
+
+	first := post("/orders", "k1", `{"sku":1}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	second := post("/orders", "k1", `{"sku":1}`)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get(IdempotencyReplayedHeader))
+	assert.Equal(t, "application/json; charset=utf-8", second.Header().Get("Content-Type"))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&orders))
+
+	// 同一个 key 用于不同的请求
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/orders", "k1", `{"sku":2}`).Code)
+
+	// 没有 key 的请求不受影响
+	assert.Equal(t, http.StatusOK, post("/orders", "", `{"sku":1}`).Code)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&orders))
+
+	// 5xx 不保存，可以重试
+	assert.Equal(t, http.StatusInternalServerError, post("/fail", "k2", "").Code)
+	assert.Equal(t, http.StatusInternalServerError, post("/fail", "k2", "").Code)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&failures))
+
+	// 处理中的请求
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	hash := payloadHash(req, []byte("x"))
+	assert.NoError(t, mr.Set("idempotency:"+KeyByIP(req)+":POST:/orders:k3", `{"token":"t","hash":"`+hash+`"}`))
+	rw := post("/orders", "k3", "x")
+	assert.Equal(t, http.StatusConflict, rw.Code)
+	assert.Equal(t, "1", rw.Header().Get("Retry-After"))
+}
+
+func TestIdempotencyScopeAndBodyLimit(t *testing.T) {
+	observeLogs(t)
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	// 模拟认证中间件
+	authenticate := negroni.HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if sub := r.Header.Get("X-Test-User"); sub != "" {
+			r = r.WithContext(hauth.NewContext(r.Context(), &hauth.Principal{Subject: sub, Method: hauth.MethodJWT}))
+		}
+		next(rw, r)
+	})
+	var orders int32
+	s := New(WithMiddleware(authenticate, NewIdempotencyMiddleware(client, WithIdempotencyMaxRequestBody(16))))
+	s.POST("/orders", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return atomic.AddInt32(&orders, 1), nil
+	})
+
+	post := func(user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		req.Header.Set("X-Test-User", user)
+		return serve(s, req)
+	}
+
+	// 不同用户使用同一个 key 互不影响，来自同一个 IP 也不会读到对方的响应
+	a := post("alice", `{}`)
+	b := post("bob", `{}`)
+	assert.JSONEq(t, `{"code":0,"msg":"succ","data":1}`, a.Body.String())
+	assert.Empty(t, b.Header().Get(IdempotencyReplayedHeader))
+	assert.Equal(t, "true", post("alice", `{}`).Header().Get(IdempotencyReplayedHeader))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&orders))
+	assert.True(t, mr.Exists("idempotency:principal:jwt:alice:POST:/orders:k1"))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("carol", `{"sku":"0123456789abcdef"}`).Code)
+	req := httptest.NewRequest(http.MethodPost, "/orders", ioutil.NopCloser(strings.NewReader(`{"sku":"0123456789abcdef"}`)))
+	req.ContentLength = -1
+	req.Header.Set(IdempotencyKeyHeader, "k2")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(s, req).Code)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&orders))
+}
+
+func TestIdempotencyNonFinalResults(t *testing.T) {
+	observeLogs(t)
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	var calls int32
+	s := New(WithMiddleware(NewIdempotencyMiddleware(client)))
+	s.POST("/canceled", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		ctx.Value(cancelKey{}).(context.CancelFunc)()
+		return nil, ctx.Err()
+	})
+	s.POST("/limited", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, herror.ErrTooManyRequests
+	})
+	s.POST("/panic", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		panic("boom")
+	})
+
+	post := func(path string) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, "k-"+path)
+		return serve(s, req.WithContext(context.WithValue(ctx, cancelKey{}, cancel)))
+	}
+
+	// 调用方断开、429、panic 都不保存结果并释放锁，重试时重新处理
+	for _, path := range []string{"/canceled", "/limited", "/panic"} {
+		atomic.StoreInt32(&calls, 0)
+		first := post(path)
+		second := post(path)
+		assert.Empty(t, second.Header().Get(IdempotencyReplayedHeader), path)
+		assert.NotEqual(t, http.StatusConflict, second.Code, path)
+		assert.Equal(t, first.Code, second.Code, path)
+		assert.EqualValues(t, 2, atomic.LoadInt32(&calls), path)
+		assert.False(t, mr.Exists("idempotency:"+KeyByIP(httptest.NewRequest(http.MethodPost, path, nil))+":POST:"+path+":k-"+path), path)
+	}
+}
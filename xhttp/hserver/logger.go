@@ -2,6 +2,7 @@ package hserver
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -18,20 +19,69 @@ var (
 	ServerField = zap.String("span.kind", "server")
 )
 
+// 默认记录的响应体最大字节数
+const defaultLogBodyLimit = 4 << 10
+
 // LogMiddleware is a middleware interceptor that logs the request as it goes in and the response as it goes out.
 type LogMiddleware struct {
+	bodyLimit int
+	skipPaths []string
+	levels    map[int]zapcore.Level
+}
+
+type LogOption func(*LogMiddleware)
+
+// WithLogBodyLimit 设置记录的响应体最大字节数，超出部分截断，<=0 表示不记录响应体
+func WithLogBodyLimit(limit int) LogOption {
+	return func(m *LogMiddleware) {
+		m.bodyLimit = limit
+	}
+}
+
+// WithLogSkipPaths 不记录日志的路径，以 * 结尾表示前缀匹配，如 /health、/static/*
+func WithLogSkipPaths(paths ...string) LogOption {
+	return func(m *LogMiddleware) {
+		m.skipPaths = append(m.skipPaths, paths...)
+	}
+}
+
+// WithLogLevel 设置某类状态码响应的日志级别，statusClass 取状态码的百位，如 4 表示 4xx
+func WithLogLevel(statusClass int, level zapcore.Level) LogOption {
+	return func(m *LogMiddleware) {
+		m.levels[statusClass] = level
+	}
 }
 
 // NewLogger returns a new LogMiddleware instance
-func NewLogMiddleware() *LogMiddleware {
-	return &LogMiddleware{}
+func NewLogMiddleware(opts ...LogOption) *LogMiddleware {
+	m := &LogMiddleware{
+		bodyLimit: defaultLogBodyLimit,
+		levels: map[int]zapcore.Level{
+			1: zapcore.InfoLevel,
+			2: zapcore.InfoLevel,
+			3: zapcore.InfoLevel,
+			4: zapcore.WarnLevel,
+			5: zapcore.ErrorLevel,
+		},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 func (s *LogMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if s.skip(r.URL.Path) {
+		next(rw, r)
+		return
+	}
+
 	startTime := time.Now()
+	r, ex := withExchange(r)
 	reqFs := []zap.Field{
 		SystemField,
 		ServerField,
+		zap.String("method", r.Method),
 		zap.String("uri", r.URL.String()),
 		zap.Object("header", &xlog.JsonMarshaler{Key: "header", Data: r.Header}),
 		zap.Object("body", &xlog.JsonMarshaler{Key: "body", Data: r.Body}),
@@ -43,26 +93,47 @@ func (s *LogMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next
 
 	xlog.L(r.Context()).Check(zap.InfoLevel, "接收请求[http.server]").Write(reqFs...)
 
-	next(rw, r)
-
-	resp := r.Context().Value(RespKey)
-	err, _ := r.Context().Value(ErrKey).(error)
-
-	// code := status.Code(err)
-	// level := grpc_zap.DefaultCodeToLevel(code)
-
-	level := zapcore.InfoLevel
+	rec := newResponseRecorder(rw, s.bodyLimit)
+	next(rec, r)
 
+	status := rec.Status()
 	respFs := []zap.Field{
-		zap.Error(err),
+		zap.Error(ex.err),
+		zap.Int("status", status),
+		zap.Int("size", rec.Size()),
 		DurationToTimeMillisField(time.Since(startTime)),
 	}
+	respFs = append(respFs, ex.fields...)
+
+	if body, truncated := rec.Body(); len(body) > 0 {
+		if !truncated && strings.Contains(rec.Header().Get("Content-Type"), "json") {
+			respFs = append(respFs, zap.Object("resp", &xlog.ByteMarshaler{Key: "resp", Data: body}))
+		} else {
+			respFs = append(respFs, zap.ByteString("resp", body), zap.Bool("respTruncated", truncated))
+		}
+	}
+
+	xlog.L(r.Context()).Check(s.level(status), "发送响应[http.server]").Write(respFs...)
+}
 
-	if resp != nil {
-		respFs = append(respFs, zap.Object("resp", &xlog.JsonMarshaler{Key: "resp", Data: resp}))
+func (s *LogMiddleware) level(status int) zapcore.Level {
+	if level, ok := s.levels[status/100]; ok {
+		return level
 	}
+	return zapcore.InfoLevel
+}
 
-	xlog.L(r.Context()).Check(level, "发送响应[http.server]").Write(respFs...)
+func (s *LogMiddleware) skip(path string) bool {
+	for _, p := range s.skipPaths {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if p == path {
+			return true
+		}
+	}
+	return false
 }
 
 func DurationToTimeMillisField(duration time.Duration) zapcore.Field {
@@ -0,0 +1,92 @@
+package hserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	restore := zap.ReplaceGlobals(zap.New(core))
+	t.Cleanup(restore)
+	return logs
+}
+
+// logFields 返回 xlog 命名空间下的日志字段
+func logFields(entry observer.LoggedEntry) map[string]interface{} {
+	fields, _ := entry.ContextMap()["xlog"].(map[string]interface{})
+	return fields
+}
+
+func TestLogMiddleware(t *testing.T) {
+	logs := observeLogs(t)
+
+	n := negroni.New(NewLogMiddleware(WithLogBodyLimit(5), WithLogSkipPaths("/static/*")))
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+		_, _ = rw.Write([]byte("hello world"))
+	})
+
+	n.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	entries := logs.FilterMessage("发送响应[http.server]").All()
+	if assert.Len(t, entries, 1) {
+		fields := logFields(entries[0])
+		assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+		assert.EqualValues(t, http.StatusBadGateway, fields["status"])
+		assert.EqualValues(t, 11, fields["size"])
+		assert.Equal(t, "hello", fields["resp"])
+		assert.Equal(t, true, fields["respTruncated"])
+	}
+
+	n.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/static/app.js", nil))
+	assert.Equal(t, 1, logs.FilterMessage("发送响应[http.server]").Len())
+}
+
+func TestLogMiddlewareHandlerError(t *testing.T) {
+	logs := observeLogs(t)
+
+	s := New()
+	s.GET("/hello", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	rw := httptest.NewRecorder()
+	s.rootHandler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+
+	entries := logs.FilterMessage("发送响应[http.server]").All()
+	if assert.Len(t, entries, 1) {
+		fields := logFields(entries[0])
+		assert.EqualValues(t, http.StatusNotFound, fields["status"])
+		assert.Equal(t, "rpc error: code = NotFound desc = not found", fields["error"])
+	}
+}
+
+func TestDeprecatedContextKeys(t *testing.T) {
+	var gotErr, gotResp interface{}
+	s := New(WithMiddleware(negroni.HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		next(rw, r)
+		gotErr = r.Context().Value(ErrKey)
+		gotResp = r.Context().Value(RespKey)
+	})))
+	s.GET("/hello", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	s.rootHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.EqualError(t, gotErr.(error), "rpc error: code = NotFound desc = not found")
+	assert.Nil(t, gotResp)
+
+	ctx := context.WithValue(context.Background(), ErrKey, status.Error(codes.Internal, "legacy"))
+	assert.EqualError(t, ErrorFromContext(ctx), "rpc error: code = Internal desc = legacy")
+	assert.Equal(t, "data", RespFromContext(context.WithValue(context.Background(), RespKey, "data")))
+}
@@ -0,0 +1,112 @@
+package hserver
+
+import (
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var HttpServerShedCounter *kitprometheus.Counter
+
+const (
+	HttpServerShedCounterMethod string = "method"
+	HttpServerShedCounterReason string = "reason"
+)
+
+// 请求被拒绝的原因
+const (
+	ShedReasonQueueFull    = "queue_full"
+	ShedReasonQueueTimeout = "queue_timeout"
+	// 排队时调用方取消了请求，不是服务过载
+	ShedReasonClientCanceled = "client_canceled"
+	ShedReasonAdaptive       = "adaptive"
+	ShedReasonRateLimit      = "rate_limit"
+)
+
+func InitHttpServerShedMetrics() {
+	HttpServerShedCounter = kitprometheus.NewCounterFrom(
+		stdprometheus.CounterOpts{
+			Namespace: "http_server",
+			Name:      "shed_count",
+			Help:      "http server 过载保护拒绝的请求数",
+		},
+		[]string{
+			HttpServerShedCounterMethod,
+			HttpServerShedCounterReason,
+		})
+}
+
+func countShed(method, reason string) {
+	if HttpServerShedCounter != nil {
+		HttpServerShedCounter.With(
+			HttpServerShedCounterMethod, method,
+			HttpServerShedCounterReason, reason,
+		).Add(1)
+	}
+}
+
+var HttpServerIdempotencyCounter *kitprometheus.Counter
+
+const (
+	HttpServerIdempotencyCounterMethod string = "method"
+	HttpServerIdempotencyCounterResult string = "result"
+)
+
+func InitHttpServerIdempotencyMetrics() {
+	HttpServerIdempotencyCounter = kitprometheus.NewCounterFrom(
+		stdprometheus.CounterOpts{
+			Namespace: "http_server",
+			Name:      "idempotency_count",
+			Help:      "http server 幂等请求的处理结果",
+		},
+		[]string{
+			HttpServerIdempotencyCounterMethod,
+			HttpServerIdempotencyCounterResult,
+		})
+}
+
+func countIdempotency(method, result string) {
+	if HttpServerIdempotencyCounter != nil {
+		HttpServerIdempotencyCounter.With(
+			HttpServerIdempotencyCounterMethod, method,
+			HttpServerIdempotencyCounterResult, result,
+		).Add(1)
+	}
+}
+
+var HttpServerLateHandlerCounter *kitprometheus.Counter
+
+const (
+	HttpServerLateHandlerCounterMethod string = "method"
+	HttpServerLateHandlerCounterPath   string = "path"
+	HttpServerLateHandlerCounterResult string = "result"
+)
+
+// 超时的 handler 最终的结果
+const (
+	LateHandlerCompleted = "completed"
+	LateHandlerPanic     = "panic"
+)
+
+func InitHttpServerLateHandlerMetrics() {
+	HttpServerLateHandlerCounter = kitprometheus.NewCounterFrom(
+		stdprometheus.CounterOpts{
+			Namespace: "http_server",
+			Name:      "late_handler_count",
+			Help:      "http server 超时后才返回或 panic 的 handler 数",
+		},
+		[]string{
+			HttpServerLateHandlerCounterMethod,
+			HttpServerLateHandlerCounterPath,
+			HttpServerLateHandlerCounterResult,
+		})
+}
+
+func countLateHandler(method, path, result string) {
+	if HttpServerLateHandlerCounter != nil {
+		HttpServerLateHandlerCounter.With(
+			HttpServerLateHandlerCounterMethod, method,
+			HttpServerLateHandlerCounterPath, path,
+			HttpServerLateHandlerCounterResult, result,
+		).Add(1)
+	}
+}
@@ -0,0 +1,413 @@
+package hserver
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+const defaultOpenAPIPath = "/openapi.json"
+
+// OpenAPIConfig OpenAPI 文档配置
+type OpenAPIConfig struct {
+	// 文档地址，默认 /openapi.json
+	Path        string
+	Title       string
+	Version     string
+	Description string
+	// 服务地址，如 https://api.example.com
+	Servers []string
+}
+
+func (c *OpenAPIConfig) path() string {
+	if c.Path == "" {
+		return defaultOpenAPIPath
+	}
+	return c.Path
+}
+
+// 用于从 SuccFactory 的输出中找到 data 字段
+const openAPIDataPlaceholder = "__hserver_openapi_data__"
+
+// OpenAPI 根据已注册的路由生成 OpenAPI 3 文档。
+// 响应结构通过调用 SuccFactory、ErrFactory 得到，自定义的工厂同样适用
+func (s *Server) OpenAPI() ([]byte, error) {
+	conf := OpenAPIConfig{}
+	if s.options.OpenAPI != nil {
+		conf = *s.options.OpenAPI
+	}
+	if conf.Title == "" {
+		conf.Title = "API"
+	}
+	if conf.Version == "" {
+		conf.Version = "1.0.0"
+	}
+
+	b := &schemaBuilder{components: map[string]interface{}{}, names: map[reflect.Type]string{}}
+	succ := s.succEnvelope()
+	b.components["Error"] = s.errEnvelope()
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range s.routes {
+		if route.hidden {
+			continue
+		}
+		path, params := openAPIPath(route.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.Method)] = b.operation(route, params, succ)
+	}
+
+	info := map[string]interface{}{"title": conf.Title, "version": conf.Version}
+	if conf.Description != "" {
+		info["description"] = conf.Description
+	}
+	doc := map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.components},
+	}
+	if len(conf.Servers) > 0 {
+		var servers []map[string]string
+		for _, url := range conf.Servers {
+			servers = append(servers, map[string]string{"url": url})
+		}
+		doc["servers"] = servers
+	}
+
+	return json.Marshal(doc)
+}
+
+func (s *Server) openAPIHandler() http.Handler {
+	var (
+		once sync.Once
+		body []byte
+		err  error
+	)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// 路由在启动前注册完成，第一次请求时生成
+		once.Do(func() {
+			body, err = s.OpenAPI()
+		})
+		if err != nil {
+			WriteError(rw, r, err)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = rw.Write(body)
+	})
+}
+
+// envelope 成功响应的结构，dataKey 为空时 SuccFactory 的输出不是 JSON 对象，直接使用 data 的 schema
+type envelope struct {
+	schema  map[string]interface{}
+	dataKey string
+}
+
+func (e envelope) wrap(data map[string]interface{}) map[string]interface{} {
+	if e.dataKey == "" {
+		return data
+	}
+	props := map[string]interface{}{}
+	for k, v := range e.schema["properties"].(map[string]interface{}) {
+		props[k] = v
+	}
+	props[e.dataKey] = data
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// succEnvelope 用占位符调用 SuccFactory，得到 code/msg/data 结构
+func (s *Server) succEnvelope() envelope {
+	body, _, err := s.options.SuccFactory(openAPIDataPlaceholder)
+	if err != nil {
+		return envelope{}
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(body, &m) != nil {
+		return envelope{}
+	}
+	for k, v := range m {
+		if v == openAPIDataPlaceholder {
+			return envelope{schema: inferSchema(m), dataKey: k}
+		}
+	}
+	return envelope{}
+}
+
+func (s *Server) errEnvelope() map[string]interface{} {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	body, _ := s.options.ErrFactory(herror.ErrBadRequest.WithDetail("field", ""), r)
+	var v interface{}
+	if json.Unmarshal(body, &v) != nil {
+		return map[string]interface{}{}
+	}
+	return inferSchema(v)
+}
+
+// inferSchema 根据 JSON 值推断 schema
+func inferSchema(v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	case float64:
+		if val == float64(int64(val)) {
+			return map[string]interface{}{"type": "integer"}
+		}
+		return map[string]interface{}{"type": "number"}
+	case string:
+		return map[string]interface{}{"type": "string"}
+	case []interface{}:
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{}}
+	case map[string]interface{}:
+		props := map[string]interface{}{}
+		for k, item := range val {
+			props[k] = inferSchema(item)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
+
+// openAPIPath 将 httprouter 的 /user/:id、/files/*path 转换为 /user/{id}、/files/{path}
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if len(seg) > 1 && (seg[0] == ':' || seg[0] == '*') {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+type schemaBuilder struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+func (b *schemaBuilder) operation(route *Route, params []string, succ envelope) map[string]interface{} {
+	op := map[string]interface{}{}
+	if route.summary != "" {
+		op["summary"] = route.summary
+	}
+	if len(route.tags) > 0 {
+		op["tags"] = route.tags
+	}
+
+	var parameters []map[string]interface{}
+	for _, name := range params {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if route.request != nil {
+		switch route.Method {
+		case http.MethodGet, http.MethodHead, http.MethodDelete:
+			parameters = append(parameters, b.queryParams(route.request)...)
+		default:
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(b.schema(route.request)),
+			}
+		}
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	ok := map[string]interface{}{"description": "成功"}
+	switch route.kind {
+	case routeEnvelope:
+		data := map[string]interface{}{}
+		if route.response != nil {
+			data = b.schema(route.response)
+		}
+		ok["content"] = jsonContent(succ.wrap(data))
+	case routeRaw:
+		if route.response != nil {
+			ok["content"] = jsonContent(b.schema(route.response))
+		}
+	case routeStream:
+		item := map[string]interface{}{}
+		if route.response != nil {
+			item = b.schema(route.response)
+		}
+		ok["content"] = map[string]interface{}{
+			strings.SplitN(route.format.contentType(), ";", 2)[0]: map[string]interface{}{"schema": item},
+		}
+	}
+
+	responses := map[string]interface{}{"200": ok}
+	if route.kind != routeRaw {
+		responses["default"] = map[string]interface{}{
+			"description": "错误",
+			"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func (b *schemaBuilder) queryParams(t reflect.Type) []map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var params []map[string]interface{}
+	eachField(t, func(f reflect.StructField, name string, _ bool) {
+		if q := strings.Split(f.Tag.Get("query"), ",")[0]; q != "" {
+			name = q
+		}
+		params = append(params, map[string]interface{}{
+			"name":   name,
+			"in":     "query",
+			"schema": b.schema(f.Type),
+		})
+	})
+	return params
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schema 按 encoding/json 的规则生成 schema，命名结构体放入 components
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case reflect.PtrTo(t).Implements(jsonMarshalerType):
+		// 自定义编码无法得知结构
+		return map[string]interface{}{}
+	case reflect.PtrTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := b.name(t)
+		if _, ok := b.components[name]; !ok {
+			// 先占位，避免递归结构无限展开
+			b.components[name] = nil
+			b.components[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	eachField(t, func(f reflect.StructField, name string, omitempty bool) {
+		props[name] = b.schema(f.Type)
+		if !omitempty {
+			required = append(required, name)
+		}
+	})
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// name 命名结构体在 components 中的名称，不同包的同名结构体加上包名区分
+func (b *schemaBuilder) name(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := b.components[name]; taken {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	b.names[t] = name
+	return name
+}
+
+// eachField 遍历会被 encoding/json 输出的字段，匿名结构体字段展开
+func eachField(t reflect.Type, fn func(f reflect.StructField, name string, omitempty bool)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				eachField(ft, fn)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		var omitempty bool
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				omitempty = true
+			}
+		}
+		fn(f, name, omitempty)
+	}
+}
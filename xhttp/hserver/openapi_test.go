@@ -0,0 +1,85 @@
+package hserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type openAPIUser struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Friends   []*openAPIUser
+	secret    string
+}
+
+type openAPIListReq struct {
+	Cursor  int    `json:"cursor" query:"cursor"`
+	Keyword string `json:"kw"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	observeLogs(t)
+	s := New(WithOpenAPI(OpenAPIConfig{Title: "user", Version: "v1"}))
+	noop := func(ctx context.Context, req *http.Request) (interface{}, error) { return nil, nil }
+	s.GET("/users/:id", noop, WithRouteSummary("获取用户"), WithRouteTags("user"), WithRouteResponse(openAPIUser{}))
+	s.GET("/users", noop, WithRouteRequest(openAPIListReq{}), WithRouteResponse([]openAPIUser{}))
+	s.POST("/users", noop, WithRouteRequest(&openAPIUser{}))
+
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var doc struct {
+		Info  map[string]string
+		Paths map[string]map[string]struct {
+			Summary     string
+			Tags        []string
+			Parameters  []map[string]interface{}
+			RequestBody map[string]interface{}
+			Responses   map[string]struct {
+				Content map[string]struct {
+					Schema map[string]interface{}
+				}
+			}
+		}
+		Components struct {
+			Schemas map[string]map[string]interface{}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &doc))
+	assert.Equal(t, "user", doc.Info["title"])
+	assert.NotContains(t, doc.Paths, "/health")
+	assert.NotContains(t, doc.Paths, "/openapi.json")
+
+	get := doc.Paths["/users/{id}"]["get"]
+	assert.Equal(t, "获取用户", get.Summary)
+	assert.Equal(t, []string{"user"}, get.Tags)
+	assert.Equal(t, "path", get.Parameters[0]["in"])
+	assert.Equal(t, "id", get.Parameters[0]["name"])
+
+	props := get.Responses["200"].Content["application/json"].Schema["properties"].(map[string]interface{})
+	assert.Contains(t, props, "code")
+	assert.Contains(t, props, "msg")
+	assert.Equal(t, "#/components/schemas/openAPIUser", props["data"].(map[string]interface{})["$ref"])
+	assert.Contains(t, doc.Paths["/users/{id}"]["get"].Responses, "default")
+
+	user := doc.Components.Schemas["openAPIUser"]
+	userProps := user["properties"].(map[string]interface{})
+	assert.Len(t, userProps, 5)
+	assert.Equal(t, "date-time", userProps["createdAt"].(map[string]interface{})["format"])
+	assert.ElementsMatch(t, []interface{}{"id", "name", "createdAt", "Friends"}, user["required"])
+	assert.Contains(t, doc.Components.Schemas["Error"]["properties"], "details")
+
+	list := doc.Paths["/users"]["get"]
+	assert.Len(t, list.Parameters, 2)
+	assert.Equal(t, "cursor", list.Parameters[0]["name"])
+	assert.Equal(t, "kw", list.Parameters[1]["name"])
+	assert.NotNil(t, doc.Paths["/users"]["post"].RequestBody)
+}
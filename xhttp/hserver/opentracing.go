@@ -7,6 +7,7 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
+	"github.com/urfave/negroni"
 
 	"github.com/yituoshiniao/kit/xlog"
 )
@@ -23,15 +24,15 @@ func NewOpentracingMiddleware() *OpentracingMiddleware {
 
 func (m *OpentracingMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
+	r, ex := withExchange(r)
 	ctx, sp := newServerSpanFromInbound(r)
-	*r = *r.WithContext(ctx)
 
-	next(rw, r)
-
-	err, _ := r.Context().Value(ErrKey).(error)
-
-	finishServerSpan(sp, err)
+	next(rw, r.WithContext(ctx))
 
+	if nrw, ok := rw.(negroni.ResponseWriter); ok && nrw.Written() {
+		ext.HTTPStatusCode.Set(sp, uint16(nrw.Status()))
+	}
+	finishServerSpan(sp, ex.err)
 }
 
 func newServerSpanFromInbound(r *http.Request) (context.Context, opentracing.Span) {
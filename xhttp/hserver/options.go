@@ -8,7 +8,9 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/urfave/negroni"
 	"go.uber.org/zap"
-	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xhealth"
+	"github.com/yituoshiniao/kit/xhttp/herror"
 )
 
 type ErrRespFactory func(err error, r *http.Request) (body []byte, contentType string)
@@ -32,12 +34,9 @@ func defaultSuccFactory(data interface{}) (body []byte, contentType string, err
 	return body, "application/json; charset=utf-8", err
 }
 
+// defaultErrFactory 按 herror.Envelope 输出错误，非 *herror.Error 的内部错误信息不会返回给调用方
 func defaultErrFactory(err error, _ *http.Request) (body []byte, contentType string) {
-	body, _ = json.Marshal(map[string]interface{}{
-		"code": HTTPStatusFromCode(status.Code(err)),
-		"msg":  err.Error(),
-		"data": nil,
-	})
+	body, _ = json.Marshal(herror.NewEnvelope(err))
 
 	return body, "application/json; charset=utf-8"
 }
@@ -48,7 +47,8 @@ func defaultMiddlewareFactory(o *Options) *negroni.Negroni {
 	middleware := negroni.New()
 	middleware.Use(NewOpentracingMiddleware())
 	middleware.Use(NewTraceIdMiddleware())
-	middleware.Use(NewLogMiddleware())
+	middleware.Use(NewRequestIdMiddleware())
+	middleware.Use(NewLogMiddleware(o.LogOptions...))
 	middleware.Use(NewRecoveryMiddleware(o.ErrFactory))
 
 	return middleware
@@ -72,6 +72,23 @@ type Options struct {
 	ReadTimeout       time.Duration
 	WriteTimeout      time.Duration
 	IdleTimeout       time.Duration
+	// 所有路由默认的 handler 处理时限，路由可以通过 WithRouteTimeout 单独设置
+	HandlerTimeout time.Duration
+	LogOptions     []LogOption
+	// 追加在 MiddlewareFactory 返回的中间件之后
+	Middlewares []negroni.Handler
+	// 不为空时开启跨域支持
+	CORS *CORSConfig
+	// 按 Accept 协商的非 JSON 编码，如 MsgpackCodec、ProtobufCodec
+	Codecs []Codec
+	// 所有路由开启 ETag
+	ETag bool
+	// 不为空时提供 OpenAPI 文档
+	OpenAPI *OpenAPIConfig
+	// 不为空时提供 /livez、/readyz
+	Health *xhealth.Registry
+	// 不为空时在 Prefix 下提供调试接口
+	Debug *DebugConfig
 }
 
 // Deprecated
@@ -106,6 +123,73 @@ func WithIdleTimeout(t time.Duration) Option {
 	}
 }
 
+// WithLogOptions 设置默认 MiddlewareFactory 中 LogMiddleware 的参数
+func WithLogOptions(opts ...LogOption) Option {
+	return func(o *Options) {
+		o.LogOptions = append(o.LogOptions, opts...)
+	}
+}
+
+// WithMiddleware 在默认中间件之后追加中间件，如 NewBodyLimitMiddleware、NewConcurrencyLimitMiddleware
+func WithMiddleware(handlers ...negroni.Handler) Option {
+	return func(o *Options) {
+		o.Middlewares = append(o.Middlewares, handlers...)
+	}
+}
+
+// WithHandlerTimeout 设置所有路由默认的 handler 处理时限，handler 需要监听 ctx.Done() 及时返回，
+// 流式响应和 WebSocket 路由不受影响，需要时通过 WithRouteTimeout 单独设置
+func WithHandlerTimeout(t time.Duration) Option {
+	return func(o *Options) {
+		o.HandlerTimeout = t
+	}
+}
+
+// WithCORS 开启跨域支持，CORSMiddleware 在其他追加的中间件之前执行，限流等错误响应也会带上跨域头
+func WithCORS(conf CORSConfig) Option {
+	return func(o *Options) {
+		o.CORS = &conf
+	}
+}
+
+// WithCodecs 开启响应内容协商，请求的 Accept 匹配时使用对应编码，否则使用 JSON
+func WithCodecs(codecs ...Codec) Option {
+	return func(o *Options) {
+		o.Codecs = append(o.Codecs, codecs...)
+	}
+}
+
+// WithETag 所有路由开启 ETag，单个路由可以使用 WithRouteETag
+func WithETag() Option {
+	return func(o *Options) {
+		o.ETag = true
+	}
+}
+
+// WithOpenAPI 根据注册的路由生成 OpenAPI 3 文档，通过 GET conf.Path 访问
+func WithOpenAPI(conf OpenAPIConfig) Option {
+	return func(o *Options) {
+		o.OpenAPI = &conf
+	}
+}
+
+// WithHealth 通过 GET /livez、/readyz 输出 registry 的检查结果，原有的 /health 保持不变。
+// 开启 WithDebug 或使用 DebugHandler 时，检查详情和摘流接口在调试接口的 /health、/drain 下
+func WithHealth(registry *xhealth.Registry) Option {
+	return func(o *Options) {
+		o.Health = registry
+	}
+}
+
+// WithDebug 在同一个端口的 conf.Prefix 下提供调试接口，受 WriteTimeout 限制，
+// 需要采集较长时间的 profile 时使用 Server.DebugHandler 单独监听端口。
+// conf.Token 和 conf.AllowIPs 不能都为空，否则 New 时 panic
+func WithDebug(conf DebugConfig) Option {
+	return func(o *Options) {
+		o.Debug = &conf
+	}
+}
+
 func WithMiddlewareFactory(factory MiddlewareFactory) Option {
 	return func(o *Options) {
 		o.MiddlewareFactory = factory
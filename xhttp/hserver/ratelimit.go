@@ -0,0 +1,308 @@
+package hserver
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xlog"
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+// RateLimitResult 一次限流判断的结果
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// 令牌桶恢复到满额需要的时间
+	Reset time.Duration
+	// 被拒绝时，距离下一个令牌可用的时间
+	RetryAfter time.Duration
+}
+
+// RateLimiter 按 key 进行限流
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+type rateLimitOptions struct {
+	burst     int
+	keyPrefix string
+}
+
+type RateLimiterOption func(*rateLimitOptions)
+
+// WithBurst 设置令牌桶容量，即允许的突发请求数，默认等于 limit
+func WithBurst(burst int) RateLimiterOption {
+	return func(o *rateLimitOptions) {
+		o.burst = burst
+	}
+}
+
+// WithKeyPrefix 设置 redis key 前缀，默认 ratelimit:
+func WithKeyPrefix(prefix string) RateLimiterOption {
+	return func(o *rateLimitOptions) {
+		o.keyPrefix = prefix
+	}
+}
+
+// tokenBucket 令牌桶参数，每 window 时间补充 limit 个令牌
+type tokenBucket struct {
+	limit  int
+	burst  int
+	window time.Duration
+}
+
+// newTokenBucket 令牌桶按毫秒计算，window 小于 1ms 或 limit 不大于 0 时 panic
+func newTokenBucket(limit int, window time.Duration, o rateLimitOptions) tokenBucket {
+	if window < time.Millisecond {
+		panic("hserver: 限流 window 不能小于 1ms，当前为 " + window.String())
+	}
+	if limit <= 0 {
+		panic("hserver: 限流 limit 必须大于 0，当前为 " + strconv.Itoa(limit))
+	}
+	if o.burst <= 0 {
+		o.burst = limit
+	}
+	return tokenBucket{limit: limit, burst: o.burst, window: window}
+}
+
+// ratePerMs 每毫秒补充的令牌数
+func (b tokenBucket) ratePerMs() float64 {
+	return float64(b.limit) / float64(b.window.Milliseconds())
+}
+
+// result 根据剩余令牌数计算限流结果
+func (b tokenBucket) result(allowed bool, tokens float64) RateLimitResult {
+	rate := b.ratePerMs()
+	res := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     b.burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(b.burst)-tokens)/rate) * time.Millisecond,
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration(math.Ceil((1-tokens)/rate)) * time.Millisecond
+	}
+	return res
+}
+
+// take 从令牌桶中取一个令牌，返回是否成功和剩余令牌数
+func (b tokenBucket) take(tokens float64, last, now time.Time) (bool, float64) {
+	if elapsed := now.Sub(last).Milliseconds(); elapsed > 0 {
+		tokens = math.Min(float64(b.burst), tokens+float64(elapsed)*b.ratePerMs())
+	}
+	if tokens < 1 {
+		return false, tokens
+	}
+	return true, tokens - 1
+}
+
+// tokenBucketScript 与 tokenBucket.take 逻辑一致，使用调用方传入的时间，避免脚本中调用 TIME
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], ttl)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimiter 基于 redis 的令牌桶限流，多个实例共享额度；
+// redis 不可用时退化为单机的 MemoryRateLimiter
+type RedisRateLimiter struct {
+	client    *redis.Client
+	bucket    tokenBucket
+	keyPrefix string
+	fallback  *MemoryRateLimiter
+}
+
+func NewRedisRateLimiter(client *redis.Client, limit int, window time.Duration, opts ...RateLimiterOption) *RedisRateLimiter {
+	o := rateLimitOptions{keyPrefix: "ratelimit:"}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &RedisRateLimiter{
+		client:    client,
+		bucket:    newTokenBucket(limit, window, o),
+		keyPrefix: o.keyPrefix,
+		fallback:  NewMemoryRateLimiter(limit, window, opts...),
+	}
+}
+
+func (l *RedisRateLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	b := l.bucket
+	// 令牌桶从空到满所需时间之后 key 自动过期
+	ttl := int64(float64(b.burst)/b.ratePerMs()) + 1000
+	ret, err := tokenBucketScript.Run(
+		xrds.Trace(ctx, l.client),
+		[]string{l.keyPrefix + key},
+		b.ratePerMs(), b.burst, time.Now().UnixNano()/int64(time.Millisecond), ttl,
+	).Result()
+	if err != nil {
+		xlog.S(ctx).Warnw("redis 限流失败，使用单机限流", "key", key, "err", err)
+		return l.fallback.Allow(ctx, key)
+	}
+
+	vals, _ := ret.([]interface{})
+	if len(vals) != 2 {
+		return l.fallback.Allow(ctx, key)
+	}
+	allowed, _ := vals[0].(int64)
+	tokensStr, _ := vals[1].(string)
+	tokens, _ := strconv.ParseFloat(tokensStr, 64)
+	return b.result(allowed == 1, tokens), nil
+}
+
+// MemoryRateLimiter 单机令牌桶限流
+type MemoryRateLimiter struct {
+	bucket tokenBucket
+
+	mu      sync.Mutex
+	states  map[string]*bucketState
+	cleanAt time.Time
+}
+
+type bucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewMemoryRateLimiter(limit int, window time.Duration, opts ...RateLimiterOption) *MemoryRateLimiter {
+	var o rateLimitOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &MemoryRateLimiter{
+		bucket: newTokenBucket(limit, window, o),
+		states: make(map[string]*bucketState),
+	}
+}
+
+func (l *MemoryRateLimiter) Allow(_ context.Context, key string) (RateLimitResult, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.clean(now)
+	st, ok := l.states[key]
+	if !ok {
+		st = &bucketState{tokens: float64(l.bucket.burst), last: now}
+		l.states[key] = st
+	}
+	allowed, tokens := l.bucket.take(st.tokens, st.last, now)
+	st.tokens, st.last = tokens, now
+
+	return l.bucket.result(allowed, tokens), nil
+}
+
+// clean 定期删除已经恢复满额的令牌桶，避免 key 无限增长
+func (l *MemoryRateLimiter) clean(now time.Time) {
+	if now.Before(l.cleanAt) {
+		return
+	}
+	full := time.Duration(float64(l.bucket.burst)/l.bucket.ratePerMs()) * time.Millisecond
+	for key, st := range l.states {
+		if now.Sub(st.last) > full {
+			delete(l.states, key)
+		}
+	}
+	l.cleanAt = now.Add(full + time.Minute)
+}
+
+// RateLimitKeyFunc 从请求中提取限流的 key
+type RateLimitKeyFunc func(r *http.Request) string
+
+// KeyByIP 按连接的 RemoteAddr 限流，服务在反向代理之后时使用 TrustedProxies.KeyByIP
+func KeyByIP(r *http.Request) string {
+	return ClientIP(r)
+}
+
+// KeyByHeader 按请求头限流，如用户 id
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// KeyByRoute 整个路由共享一个额度，配合 WithRouteRateLimit 使用
+func KeyByRoute(*http.Request) string {
+	return ""
+}
+
+// RateLimitMiddleware 限流中间件，设置 RateLimit-* 响应头，超出限制时返回 codes.ResourceExhausted
+type RateLimitMiddleware struct {
+	limiter RateLimiter
+	keyFunc RateLimitKeyFunc
+	scope   string
+}
+
+func NewRateLimitMiddleware(limiter RateLimiter, keyFunc RateLimitKeyFunc) *RateLimitMiddleware {
+	return &RateLimitMiddleware{limiter: limiter, keyFunc: keyFunc, scope: "global"}
+}
+
+// WithRouteRateLimit 为单个路由限流，key 会加上路由的 method 和 path
+func WithRouteRateLimit(limiter RateLimiter, keyFunc RateLimitKeyFunc) RouteOption {
+	return func(r *Route) {
+		m := NewRateLimitMiddleware(limiter, keyFunc)
+		m.scope = r.Method + ":" + r.Path
+		r.middlewares = append(r.middlewares, m)
+	}
+}
+
+func (m *RateLimitMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := m.scope + ":" + m.keyFunc(r)
+	res, err := m.limiter.Allow(r.Context(), key)
+	if err != nil {
+		xlog.S(r.Context()).Errorw("限流判断失败，放行请求", "key", key, "err", err)
+		next(rw, r)
+		return
+	}
+
+	h := rw.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+	if !res.Allowed {
+		countShed(r.Method, ShedReasonRateLimit)
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		WriteError(rw, r, status.Error(codes.ResourceExhausted, "请求过于频繁"))
+		return
+	}
+
+	next(rw, r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,104 @@
+package hserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisRateLimiter(t *testing.T) {
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	limiter := NewRedisRateLimiter(client, 2, time.Minute)
+	ctx := context.Background()
+
+	res, err := limiter.Allow(ctx, "u1")
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	res, _ = limiter.Allow(ctx, "u1")
+	assert.True(t, res.Allowed)
+	res, _ = limiter.Allow(ctx, "u1")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.True(t, res.RetryAfter > 25*time.Second && res.RetryAfter <= 30*time.Second, res.RetryAfter)
+
+	// 其他 key 不受影响
+	res, _ = limiter.Allow(ctx, "u2")
+	assert.True(t, res.Allowed)
+	assert.True(t, mr.Exists("ratelimit:u1"))
+
+	// redis 不可用时退化为单机限流
+	mr.Close()
+	res, err = limiter.Allow(ctx, "u3")
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	observeLogs(t)
+	s := New()
+	s.GET("/sms", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		return "ok", nil
+	}, WithRouteRateLimit(NewMemoryRateLimiter(1, time.Minute), KeyByHeader("X-User-Id")))
+
+	req := httptest.NewRequest(http.MethodGet, "/sms", nil)
+	req.Header.Set("X-User-Id", "42")
+	rw := serve(s, req)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "1", rw.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rw.Header().Get("RateLimit-Remaining"))
+
+	rw = serve(s, req)
+	assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+	assert.Equal(t, "60", rw.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":429,"msg":"请求过于频繁","data":null}`, rw.Body.String())
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies("10.0.0.0/8", "192.168.1.1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	newReq := func(remote, xff string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote + ":1234"
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		return req
+	}
+
+	// 默认不信任请求头
+	assert.Equal(t, "1.2.3.4", ClientIP(newReq("1.2.3.4", "5.6.7.8")))
+	// 连接不是来自可信代理时忽略请求头
+	assert.Equal(t, "1.2.3.4", proxies.ClientIP(newReq("1.2.3.4", "5.6.7.8")))
+	// 客户端伪造的 X-Forwarded-For 在最左边，取最右边第一个不可信的地址
+	assert.Equal(t, "5.6.7.8", proxies.ClientIP(newReq("10.0.0.2", "9.9.9.9, 5.6.7.8, 192.168.1.1")))
+	assert.Equal(t, "10.0.0.3", proxies.ClientIP(newReq("10.0.0.2", "10.0.0.3")))
+	assert.Equal(t, "10.0.0.2", proxies.ClientIP(newReq("10.0.0.2", "bad, 10.0.0.3")))
+
+	req := newReq("10.0.0.2", "")
+	req.Header.Set("X-Real-Ip", "5.6.7.8")
+	assert.Equal(t, "5.6.7.8", proxies.KeyByIP(req))
+
+	_, err = NewTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestRateLimitWindowValidation(t *testing.T) {
+	assert.Panics(t, func() { NewMemoryRateLimiter(10, time.Microsecond) })
+	assert.Panics(t, func() { NewMemoryRateLimiter(0, time.Second) })
+}
@@ -1,12 +1,12 @@
 package hserver
 
 import (
-	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/pkg/errors"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
 )
 
 type RecoveryMiddleware struct {
@@ -20,14 +20,7 @@ func NewRecoveryMiddleware(errBodyTransform ErrRespFactory) *RecoveryMiddleware
 func (m *RecoveryMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	defer func() {
 		if rec := recover(); rec != nil {
-			err := recoverFrom(rec)
-			*r = *r.WithContext(context.WithValue(r.Context(), ErrKey, errors.WithStack(err)))
-
-			body, ct := m.trans.Handle(err, r)
-
-			rw.Header().Set("Content-Type", ct)
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, _ = rw.Write(body)
+			writeError(rw, r, m.trans, errors.WithStack(recoverFrom(rec)))
 		}
 	}()
 
@@ -35,5 +28,5 @@ func (m *RecoveryMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request,
 }
 
 func recoverFrom(p interface{}) error {
-	return status.Errorf(codes.Internal, "%s", p)
+	return herror.ErrInternal.WithCause(fmt.Errorf("panic: %v", p))
 }
@@ -0,0 +1,39 @@
+package hserver
+
+import (
+	"net/http"
+
+	"github.com/yituoshiniao/kit/xtrace"
+)
+
+// 请求 id 的最大长度，超出或包含非法字符时重新生成
+const maxRequestIdLen = 128
+
+// RequestIdMiddleware 接收请求头中的 X-Request-Id，没有时生成一个，
+// 放入 ctx 供 xlog 输出，并在响应头中原样返回
+type RequestIdMiddleware struct{}
+
+func NewRequestIdMiddleware() *RequestIdMiddleware {
+	return &RequestIdMiddleware{}
+}
+
+func (m *RequestIdMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(xtrace.RequestIdHeader)
+	if !validRequestId(id) {
+		id = xtrace.NewTraceId()
+	}
+	rw.Header().Set(xtrace.RequestIdHeader, id)
+	next(rw, r.WithContext(xtrace.NewCtxWithRequestId(r.Context(), id)))
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package hserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/urfave/negroni"
+)
+
+// responseRecorder 包装 negroni.ResponseWriter，在写出响应的同时记录最多 limit 字节的响应体
+type responseRecorder struct {
+	negroni.ResponseWriter
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func newResponseRecorder(rw http.ResponseWriter, limit int) *responseRecorder {
+	nrw, ok := rw.(negroni.ResponseWriter)
+	if !ok {
+		nrw = negroni.NewResponseWriter(rw)
+	}
+	return &responseRecorder{ResponseWriter: nrw, limit: limit}
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if w.limit > 0 && n > 0 {
+		if remain := w.limit - w.body.Len(); remain < n {
+			if remain > 0 {
+				w.body.Write(b[:remain])
+			}
+			w.truncated = true
+		} else {
+			w.body.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+// Status 返回响应状态码，handler 未写出任何内容时视为 200
+func (w *responseRecorder) Status() int {
+	if st := w.ResponseWriter.Status(); st != 0 {
+		return st
+	}
+	return http.StatusOK
+}
+
+// Body 返回记录的响应体，以及是否因超出 limit 被截断
+func (w *responseRecorder) Body() ([]byte, bool) {
+	return w.body.Bytes(), w.truncated
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *responseRecorder) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
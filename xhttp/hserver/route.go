@@ -0,0 +1,223 @@
+package hserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/urfave/negroni"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// errHandlerTimeout handler 超时，GRPCStatus 为 codes.DeadlineExceeded
+var errHandlerTimeout = herror.New(http.StatusGatewayTimeout, http.StatusGatewayTimeout, "请求处理超时")
+
+// Route 路由注册信息
+type Route struct {
+	Method string
+	Path   string
+
+	timeout      time.Duration
+	maxBodyBytes int64
+	middlewares  []negroni.Handler
+	etag         bool
+
+	// 生成 OpenAPI 文档使用
+	summary  string
+	tags     []string
+	request  reflect.Type
+	response reflect.Type
+	kind     routeKind
+	format   StreamFormat
+	hidden   bool
+
+	// 流式响应和 WebSocket 等长连接，不使用 WithHandlerTimeout 的全局时限
+	longLived bool
+	stream    streamOptions
+	ws        *wsOptions
+}
+
+type routeKind int
+
+const (
+	// HandlerFunc，响应为 SuccFactory 输出的 code/msg/data 结构
+	routeEnvelope routeKind = iota
+	// http.Handler，响应由 handler 自己输出
+	routeRaw
+	// StreamHandlerFunc
+	routeStream
+)
+
+// RouteOption 路由级别的配置，在 Server.Handle 等注册路由时传入
+type RouteOption func(*Route)
+
+// WithRouteTimeout 设置 handler 的处理时限，超时后 ctx 被取消并返回 codes.DeadlineExceeded，
+// handler 需要监听 ctx.Done() 及时返回，见 serveWithDeadline
+func WithRouteTimeout(timeout time.Duration) RouteOption {
+	return func(r *Route) {
+		r.timeout = timeout
+	}
+}
+
+// WithRouteMaxBodyBytes 限制请求体大小，超出时返回 413
+func WithRouteMaxBodyBytes(n int64) RouteOption {
+	return func(r *Route) {
+		r.maxBodyBytes = n
+	}
+}
+
+// WithRouteETag 为 GET、HEAD 请求的成功响应计算 ETag，If-None-Match 匹配时返回 304
+func WithRouteETag() RouteOption {
+	return func(r *Route) {
+		r.etag = true
+	}
+}
+
+// WithRouteMiddleware 为单个路由添加中间件，在全局中间件之后执行
+func WithRouteMiddleware(handlers ...negroni.Handler) RouteOption {
+	return func(r *Route) {
+		r.middlewares = append(r.middlewares, handlers...)
+	}
+}
+
+// WithRouteSummary 设置路由在 OpenAPI 文档中的摘要
+func WithRouteSummary(summary string) RouteOption {
+	return func(r *Route) {
+		r.summary = summary
+	}
+}
+
+// WithRouteTags 设置路由在 OpenAPI 文档中的分组
+func WithRouteTags(tags ...string) RouteOption {
+	return func(r *Route) {
+		r.tags = append(r.tags, tags...)
+	}
+}
+
+// WithRouteRequest 设置请求结构，v 只用于取类型，如 WithRouteRequest(CreateUserReq{})。
+// GET、HEAD、DELETE 请求的字段作为 query 参数，字段名优先取 query tag，其他方法作为 JSON 请求体
+func WithRouteRequest(v interface{}) RouteOption {
+	return func(r *Route) {
+		r.request = reflect.TypeOf(v)
+	}
+}
+
+// WithRouteResponse 设置响应中 data 的结构，流式路由为每条数据的结构
+func WithRouteResponse(v interface{}) RouteOption {
+	return func(r *Route) {
+		r.response = reflect.TypeOf(v)
+	}
+}
+
+// hideRoute 不出现在 OpenAPI 文档中
+func hideRoute(r *Route) {
+	r.hidden = true
+}
+
+func newRoute(method, path string, opts []RouteOption) *Route {
+	r := &Route{Method: method, Path: path}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// wrap 为 handle 加上路由级别的请求体限制、中间件和处理时限，
+// 并将路径参数放入 ctx，handler 中可以通过 httprouter.ParamsFromContext 获取
+func (rt *Route) wrap(handle httprouter.Handle) httprouter.Handle {
+	if rt.timeout > 0 {
+		inner := handle
+		handle = func(rw http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			ctx, cancel := context.WithTimeout(r.Context(), rt.timeout)
+			defer cancel()
+			inner(rw, r.WithContext(ctx), params)
+		}
+	}
+
+	var chain *negroni.Negroni
+	if len(rt.middlewares) > 0 {
+		chain = negroni.New(rt.middlewares...)
+		chain.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			handle(rw, r, httprouter.ParamsFromContext(r.Context()))
+		})
+	}
+
+	return func(rw http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if rt.maxBodyBytes > 0 && !limitBody(rw, r, rt.maxBodyBytes) {
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+		if chain != nil {
+			chain.ServeHTTP(rw, r)
+			return
+		}
+		handle(rw, r, params)
+	}
+}
+
+type handlerResult struct {
+	resp  interface{}
+	err   error
+	panic interface{}
+	stack []byte
+}
+
+// serveWithDeadline 在 ctx 带有截止时间时异步执行 handler，到期后不再等待 handler 返回。
+// 超时后 handler 所在的 goroutine 不会被终止，而响应已经写出、r 和请求体可能已被 http.Server 回收，
+// 所以 handler 必须监听 ctx.Done() 及时返回，超时后不能再读取 r.Body；
+// 超时后才返回或 panic 的 handler 记录日志和 HttpServerLateHandlerCounter
+func serveWithDeadline(ctx context.Context, r *http.Request, route *Route, handler Handler) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return handler.ServeHTTP(ctx, r)
+	}
+
+	done := make(chan handlerResult, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- handlerResult{panic: p, stack: debug.Stack()}
+			}
+		}()
+		resp, err := handler.ServeHTTP(ctx, r)
+		done <- handlerResult{resp: resp, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.panic != nil {
+			// 交给 RecoveryMiddleware 处理
+			panic(res.panic)
+		}
+		if res.err != nil && ctx.Err() == context.DeadlineExceeded {
+			return nil, errHandlerTimeout
+		}
+		return res.resp, res.err
+	case <-ctx.Done():
+		go reportLate(ctx, route, time.Now(), done)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errHandlerTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// reportLate 等待超时的 handler 返回，记录返回前多用的时间和超时后的 panic
+func reportLate(ctx context.Context, route *Route, timeoutAt time.Time, done <-chan handlerResult) {
+	res := <-done
+	late := time.Since(timeoutAt)
+	if res.panic != nil {
+		countLateHandler(route.Method, route.Path, LateHandlerPanic)
+		xlog.S(ctx).Errorw("handler 超时后 panic", "method", route.Method, "path", route.Path,
+			"late", late.String(), "panic", fmt.Sprint(res.panic), "stack", string(res.stack))
+		return
+	}
+	countLateHandler(route.Method, route.Path, LateHandlerCompleted)
+	xlog.S(ctx).Warnw("handler 超时后才返回，需要监听 ctx.Done()", "method", route.Method, "path", route.Path,
+		"late", late.String())
+}
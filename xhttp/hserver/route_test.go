@@ -0,0 +1,140 @@
+package hserver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(s *Server, req *http.Request) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	s.rootHandler().ServeHTTP(rw, req)
+	return rw
+}
+
+func TestRouteTimeout(t *testing.T) {
+	observeLogs(t)
+	s := New()
+	s.GET("/slow", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, WithRouteTimeout(10*time.Millisecond))
+
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rw.Code)
+	assert.JSONEq(t, `{"code":504,"msg":"请求处理超时","data":null}`, rw.Body.String())
+}
+
+func TestRouteMaxBodyBytes(t *testing.T) {
+	observeLogs(t)
+	s := New()
+	s.POST("/echo", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		var v map[string]string
+		if err := json.NewDecoder(req.Body).Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}, WithRouteMaxBodyBytes(16))
+
+	rw := serve(s, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(`{"a":"b"}`)))
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	rw = serve(s, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(`{"a":"0123456789abcdef"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+
+	// 未知长度的请求体在读取时才发现超限
+	req := httptest.NewRequest(http.MethodPost, "/echo", ioutil.NopCloser(strings.NewReader(`{"a":"0123456789abcdef"}`)))
+	req.ContentLength = -1
+	rw = serve(s, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+}
+
+func TestRouteTimeoutLatePanic(t *testing.T) {
+	logs := observeLogs(t)
+	proceed := make(chan struct{})
+	s := New()
+	s.GET("/late", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		<-ctx.Done()
+		<-proceed
+		panic("boom")
+	}, WithRouteTimeout(10*time.Millisecond))
+
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/late", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rw.Code)
+
+	// 响应已经返回，handler 之后的 panic 不会丢失
+	close(proceed)
+	assert.Eventually(t, func() bool {
+		return logs.FilterMessage("handler 超时后 panic").Len() == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	observeLogs(t)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	s := New(WithMiddleware(NewConcurrencyLimitMiddleware(1, WithQueue(1, 20*time.Millisecond))))
+	s.GET("/block", func(ctx context.Context, req *http.Request) (interface{}, error) {
+		close(entered)
+		<-release
+		return "ok", nil
+	})
+
+	codes := make(chan int, 3)
+	var wg sync.WaitGroup
+	send := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(s, httptest.NewRequest(http.MethodGet, "/block", nil)).Code
+		}()
+	}
+
+	// 第一个请求在处理时，另外两个一个排队超时，一个因队列已满被拒绝，
+	// 两个都被拒绝后才让第一个请求返回
+	send()
+	<-entered
+	send()
+	send()
+	assert.Equal(t, http.StatusServiceUnavailable, <-codes)
+	assert.Equal(t, http.StatusServiceUnavailable, <-codes)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, http.StatusOK, <-codes)
+}
+
+func TestAdaptiveShedInFlightLatency(t *testing.T) {
+	m := NewAdaptiveShedMiddleware(100*time.Millisecond, WithShedLimits(1, 10))
+	now := time.Now()
+
+	// 慢请求未结束时，新请求到达即可发现堆积并降低上限
+	slow, ok := m.acquire(now)
+	assert.True(t, ok)
+	_, ok = m.acquire(now.Add(200 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 9, m.Limit())
+	// 同一个 targetLatency 内只降低一次
+	_, ok = m.acquire(now.Add(250 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 9, m.Limit())
+
+	m.release(slow, now.Add(300*time.Millisecond))
+	assert.Equal(t, 9, m.Limit())
+}
+
+func TestConcurrencyLimitClientCanceled(t *testing.T) {
+	m := NewConcurrencyLimitMiddleware(1, WithQueue(1, time.Minute))
+	m.sem <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	assert.Equal(t, ShedReasonClientCanceled, m.acquire(req))
+}
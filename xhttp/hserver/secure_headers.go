@@ -0,0 +1,70 @@
+package hserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SecureHeadersConfig 安全相关的响应头，字段为空时不设置对应的响应头
+type SecureHeadersConfig struct {
+	// Strict-Transport-Security 的 max-age，只对 https 请求生效
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// X-Frame-Options，如 DENY、SAMEORIGIN
+	FrameOptions string
+	// 是否设置 X-Content-Type-Options: nosniff
+	ContentTypeNosniff bool
+	// Referrer-Policy
+	ReferrerPolicy string
+	// Content-Security-Policy
+	ContentSecurityPolicy string
+}
+
+// DefaultSecureHeadersConfig 适用于纯 API 服务的默认配置
+var DefaultSecureHeadersConfig = SecureHeadersConfig{
+	HSTSMaxAge:         365 * 24 * time.Hour,
+	FrameOptions:       "DENY",
+	ContentTypeNosniff: true,
+	ReferrerPolicy:     "no-referrer",
+}
+
+type SecureHeadersMiddleware struct {
+	conf SecureHeadersConfig
+	hsts string
+}
+
+func NewSecureHeadersMiddleware(conf SecureHeadersConfig) *SecureHeadersMiddleware {
+	m := &SecureHeadersMiddleware{conf: conf}
+	if conf.HSTSMaxAge > 0 {
+		m.hsts = "max-age=" + strconv.Itoa(int(conf.HSTSMaxAge.Seconds()))
+		if conf.HSTSIncludeSubdomains {
+			m.hsts += "; includeSubDomains"
+		}
+		if conf.HSTSPreload {
+			m.hsts += "; preload"
+		}
+	}
+	return m
+}
+
+func (m *SecureHeadersMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	h := rw.Header()
+	if m.hsts != "" && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+		h.Set("Strict-Transport-Security", m.hsts)
+	}
+	if m.conf.FrameOptions != "" {
+		h.Set("X-Frame-Options", m.conf.FrameOptions)
+	}
+	if m.conf.ContentTypeNosniff {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	if m.conf.ReferrerPolicy != "" {
+		h.Set("Referrer-Policy", m.conf.ReferrerPolicy)
+	}
+	if m.conf.ContentSecurityPolicy != "" {
+		h.Set("Content-Security-Policy", m.conf.ContentSecurityPolicy)
+	}
+	next(rw, r)
+}
@@ -3,102 +3,211 @@ package hserver
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
 	"github.com/julienschmidt/httprouter"
 	"github.com/urfave/negroni"
-	"github.com/yituoshiniao/kit/xlog"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-	"net/http"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
 )
 
 type errKey struct{}
 type respKey struct{}
 
+// Deprecated: 使用 ErrorFromContext，中间件在 next 返回后通过 r.Context().Value(ErrKey) 读取仍然有效
 var ErrKey = errKey{}
+
+// Deprecated: 使用 RespFromContext，中间件在 next 返回后通过 r.Context().Value(RespKey) 读取仍然有效
 var RespKey = respKey{}
 
 type Server struct {
 	options    *Options
 	middleware *negroni.Negroni
 	router     *httprouter.Router
+	routes     []*Route
+	once       sync.Once
+
+	// ListenAndServe 启动的服务，Shutdown 时关闭
+	httpServer *http.Server
+	// 活跃的 WebSocket 连接，http.Server.Shutdown 不处理被接管的连接
+	wsMu         sync.Mutex
+	wsConns      map[*WSConn]struct{}
+	wsDrained    chan struct{}
+	shuttingDown bool
 }
 
 func New(opts ...Option) *Server {
-	o := &defaultOptions
+	o := defaultOptions
 	for _, opt := range opts {
-		opt(o)
+		opt(&o)
 	}
 
-	middleware := o.MiddlewareFactory(o)
+	middleware := o.MiddlewareFactory(&o)
 	router := httprouter.New()
 	router.NotFound = NotFound(o.ErrFactory)
 	router.MethodNotAllowed = MethodNotAllowed(o.ErrFactory)
 
-	s := &Server{options: o, middleware: middleware, router: router}
+	if o.CORS != nil {
+		cors := NewCORSMiddleware(*o.CORS)
+		cors.methods = routeMethods(router)
+		middleware.Use(cors)
+		router.GlobalOPTIONS = cors.Preflight()
+	}
+	for _, h := range o.Middlewares {
+		middleware.Use(h)
+	}
+
+	s := &Server{options: &o, middleware: middleware, router: router}
 	s.HandlerFunc(http.MethodGet, "/health", func(rw http.ResponseWriter, request *http.Request) {
 		_, _ = fmt.Fprintf(rw, "ok")
-	})
+	}, hideRoute)
+	if o.OpenAPI != nil {
+		s.Handler(http.MethodGet, o.OpenAPI.path(), s.openAPIHandler(), hideRoute)
+	}
+	if o.Health != nil {
+		s.Handler(http.MethodGet, "/livez", o.Health.LiveHandler(), hideRoute)
+		s.Handler(http.MethodGet, "/readyz", o.Health.ReadyHandler(), hideRoute)
+	}
+	if o.Debug != nil {
+		// 业务端口通常在反向代理之后，代理的连接来自本机，不能使用只允许本机访问的默认规则
+		if o.Debug.Token == "" && len(o.Debug.AllowIPs) == 0 {
+			panic("hserver: WithDebug 需要设置 Token 或 AllowIPs")
+		}
+		debug := s.DebugHandler(*o.Debug)
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut} {
+			s.Handler(method, o.Debug.prefix()+"/*path", debug, hideRoute)
+		}
+	}
 
 	return s
 }
 
-func (s *Server) GET(path string, handler HandlerFunc) {
-	s.Handle(http.MethodGet, path, handler)
+func (s *Server) GET(path string, handler HandlerFunc, opts ...RouteOption) {
+	s.Handle(http.MethodGet, path, handler, opts...)
 }
 
-func (s *Server) HEAD(path string, handler HandlerFunc) {
-	s.Handle(http.MethodHead, path, handler)
+func (s *Server) HEAD(path string, handler HandlerFunc, opts ...RouteOption) {
+	s.Handle(http.MethodHead, path, handler, opts...)
 }
 
-func (s *Server) OPTIONS(path string, handler HandlerFunc) {
-	s.Handle(http.MethodOptions, path, handler)
+func (s *Server) OPTIONS(path string, handler HandlerFunc, opts ...RouteOption) {
+	s.Handle(http.MethodOptions, path, handler, opts...)
 }
 
-func (s *Server) POST(path string, handler HandlerFunc) {
-	s.Handle(http.MethodPost, path, handler)
+func (s *Server) POST(path string, handler HandlerFunc, opts ...RouteOption) {
+	s.Handle(http.MethodPost, path, handler, opts...)
 }
 
-func (s *Server) PUT(path string, handler HandlerFunc) {
-	s.Handle(http.MethodPut, path, handler)
+func (s *Server) PUT(path string, handler HandlerFunc, opts ...RouteOption) {
+	s.Handle(http.MethodPut, path, handler, opts...)
 }
 
-func (s *Server) PATCH(path string, handler HandlerFunc) {
-	s.Handle(http.MethodPatch, path, handler)
+func (s *Server) PATCH(path string, handler HandlerFunc, opts ...RouteOption) {
+	s.Handle(http.MethodPatch, path, handler, opts...)
 }
 
-func (s *Server) DELETE(path string, handler HandlerFunc) {
-	s.Handle(http.MethodDelete, path, handler)
+func (s *Server) DELETE(path string, handler HandlerFunc, opts ...RouteOption) {
+	s.Handle(http.MethodDelete, path, handler, opts...)
 }
 
-func (s *Server) Handle(method, path string, handler HandlerFunc) {
-	xlog.S(context.Background()).Infof("添加 http 路由 %s %s", method, path)
-	s.router.Handle(method, path, s.warp(handler))
+func (s *Server) Handle(method, path string, handler HandlerFunc, opts ...RouteOption) {
+	route := newRoute(method, path, opts)
+	s.handle(route, s.warp(route, handler))
 }
 
-func (s *Server) Handler(method, path string, handler http.Handler) {
-	xlog.S(context.Background()).Infof("添加 http 路由 %s %s", method, path)
-	s.router.Handler(method, path, handler)
+func (s *Server) Handler(method, path string, handler http.Handler, opts ...RouteOption) {
+	route := newRoute(method, path, opts)
+	route.kind = routeRaw
+	s.handle(route, func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		handler.ServeHTTP(rw, r)
+	})
 }
 
-func (s *Server) HandlerFunc(method, path string, handler http.HandlerFunc) {
-	xlog.S(context.Background()).Infof("添加 http 路由 %s %s", method, path)
-	s.router.HandlerFunc(method, path, handler)
+func (s *Server) HandlerFunc(method, path string, handler http.HandlerFunc, opts ...RouteOption) {
+	s.Handler(method, path, handler, opts...)
 }
 
-func (s *Server) ListenAndServe(addr string) error {
-	s.middleware.UseHandler(s.router)
+func (s *Server) handle(route *Route, handle httprouter.Handle) {
+	if route.timeout == 0 && !route.longLived {
+		route.timeout = s.options.HandlerTimeout
+	}
+	if s.options.ETag {
+		route.etag = true
+	}
+	xlog.S(context.Background()).Infof("添加 http 路由 %s %s", route.Method, route.Path)
+	s.routes = append(s.routes, route)
+	s.router.Handle(route.Method, route.Path, route.wrap(handle))
+}
+
+// rootHandler 返回完整的 http.Handler，请求进入中间件前先放入 exchange
+func (s *Server) rootHandler() http.Handler {
+	s.once.Do(func() {
+		s.middleware.UseHandler(s.router)
+	})
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ex := &exchange{trans: s.options.ErrFactory, codecs: s.options.Codecs}
+		if len(ex.codecs) > 0 {
+			rw.Header().Add("Vary", "Accept")
+		}
+		s.middleware.ServeHTTP(rw, r.WithContext(exchangeCtx{Context: r.Context(), ex: ex}))
+	})
+}
 
+// ServeHTTP 经过完整的中间件处理请求，可以挂到其他 http.Server 或在测试中直接调用，
+// 注册路由使用同名的 Handler 方法
+func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.rootHandler().ServeHTTP(rw, r)
+}
+
+func (s *Server) ListenAndServe(addr string) error {
 	server := &http.Server{
 		Addr:         addr,
-		Handler:      s.middleware,
+		Handler:      s.rootHandler(),
 		ReadTimeout:  s.options.ReadTimeout,
 		WriteTimeout: s.options.WriteTimeout,
 		IdleTimeout:  s.options.IdleTimeout,
+		ConnContext:  ConnContext,
 	}
 
+	s.wsMu.Lock()
+	s.httpServer = server
+	s.wsMu.Unlock()
 	return server.ListenAndServe()
 }
 
+// Shutdown 停止接收新请求，向 WebSocket 连接发送 1001 关闭帧，等待处理中的请求和连接结束
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.wsMu.Lock()
+	server := s.httpServer
+	s.wsMu.Unlock()
+
+	wsErr := s.closeWebSockets(ctx)
+	if server == nil {
+		return wsErr
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+	return wsErr
+}
+
+type connKey struct{}
+
+// ConnContext 将连接放入 ctx，流式响应按每次写入设置写超时，
+// 服务挂在其他 http.Server 上时设置 http.Server.ConnContext = hserver.ConnContext
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+func connFromContext(ctx context.Context) net.Conn {
+	c, _ := ctx.Value(connKey{}).(net.Conn)
+	return c
+}
+
 type Handler interface {
 	ServeHTTP(ctx context.Context, req *http.Request) (resp interface{}, err error)
 }
@@ -109,96 +218,89 @@ func (f HandlerFunc) ServeHTTP(ctx context.Context, req *http.Request) (resp int
 	return f(ctx, req)
 }
 
-func (s *Server) warp(handler HandlerFunc) httprouter.Handle {
+func (s *Server) warp(route *Route, handler HandlerFunc) httprouter.Handle {
 	return func(rw http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		resp, err := handler.ServeHTTP(r.Context(), r)
+		resp, err := serveWithDeadline(r.Context(), r, route, handler)
 		if err != nil {
-			body, ct := s.options.ErrFactory.Handle(err, r)
-			rw.Header().Set("Content-Type", ct)
-			st, _ := status.FromError(err)
-			rw.WriteHeader(HTTPStatusFromCode(st.Code()))
-			_, _ = rw.Write(body)
+			writeError(rw, r, s.options.ErrFactory, err)
+			return
+		}
+
+		body, ct, err := s.marshalSucc(r, resp)
+		if err != nil {
+			writeError(rw, r, s.options.ErrFactory, err)
+			return
+		}
+		recordResult(r.Context(), resp, nil)
+
+		rw.Header().Set("Content-Type", ct)
+		if route.etag && notModified(rw, r, body) {
+			return
+		}
+		_, _ = rw.Write(body)
+	}
+}
+
+// marshalSucc 按 Accept 协商编码成功响应，没有匹配的 Codec 时使用 SuccRespFactory
+func (s *Server) marshalSucc(r *http.Request, data interface{}) (body []byte, contentType string, err error) {
+	body, contentType, err = negotiate(r, s.options.Codecs, func(c Codec) ([]byte, error) {
+		return c.Succ(data)
+	})
+	if contentType != "" {
+		return body, contentType, err
+	}
+	return s.options.SuccFactory.Handle(data)
+}
+
+// WriteError 使用当前 Server 的 ErrRespFactory 输出错误响应，供中间件使用
+func WriteError(rw http.ResponseWriter, r *http.Request, err error) {
+	writeError(rw, r, nil, err)
+}
+
+// writeError 使用 trans 输出错误响应，并记录错误供外层中间件读取，trans 为空时使用当前 Server 的配置
+func writeError(rw http.ResponseWriter, r *http.Request, trans ErrRespFactory, err error) {
+	recordResult(r.Context(), nil, err)
+	ex := exchangeFrom(r.Context())
+	if trans == nil {
+		if ex != nil && ex.trans != nil {
+			trans = ex.trans
 		} else {
-			body, ct, err := s.options.SuccFactory.Handle(resp)
-			if err != nil {
-				body, ct := s.options.ErrFactory.Handle(err, r)
-				rw.Header().Set("Content-Type", ct)
-				rw.WriteHeader(http.StatusInternalServerError)
-				_, _ = rw.Write(body)
-			} else {
-				rw.Header().Set("Content-Type", ct)
-				_, _ = rw.Write(body)
-			}
+			trans = defaultErrFactory
 		}
+	}
 
-		*r = *r.WithContext(context.WithValue(r.Context(), ErrKey, err))
-		*r = *r.WithContext(context.WithValue(r.Context(), RespKey, resp))
+	var body []byte
+	var ct string
+	if ex != nil {
+		body, ct, _ = negotiate(r, ex.codecs, func(c Codec) ([]byte, error) {
+			return c.Err(herror.NewEnvelope(err))
+		})
+	}
+	if ct == "" {
+		body, ct = trans.Handle(err, r)
 	}
+	rw.Header().Set("Content-Type", ct)
+	rw.WriteHeader(HTTPStatusFromError(err))
+	_, _ = rw.Write(body)
 }
 
 func NotFound(trans ErrRespFactory) http.HandlerFunc {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		err := status.Error(codes.NotFound, "404 page not found")
-		body, ct := trans.Handle(err, r)
-
-		*r = *r.WithContext(context.WithValue(r.Context(), ErrKey, err))
-
-		rw.Header().Set("Content-Type", ct)
-		rw.WriteHeader(http.StatusNotFound)
-		_, _ = rw.Write(body)
+		writeError(rw, r, trans, herror.New(http.StatusNotFound, http.StatusNotFound, "404 page not found"))
 	})
 }
 
 func MethodNotAllowed(trans ErrRespFactory) http.HandlerFunc {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		err := status.Error(codes.FailedPrecondition, "405 Method Not Allowed")
-		body, ct := trans.Handle(err, r)
-
-		*r = *r.WithContext(context.WithValue(r.Context(), ErrKey, err))
-
-		rw.Header().Set("Content-Type", ct)
-		rw.WriteHeader(http.StatusMethodNotAllowed)
-		_, _ = rw.Write(body)
+		writeError(rw, r, trans, herror.New(http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, "405 Method Not Allowed"))
 	})
 }
 
+// HTTPStatusFromError 获取错误对应的 HTTP 状态码，支持 *herror.Error 和 grpc status
+func HTTPStatusFromError(err error) int {
+	return herror.FromError(err).Status
+}
+
 func HTTPStatusFromCode(code codes.Code) int {
-	switch code {
-	case codes.OK:
-		return http.StatusOK
-	case codes.Canceled:
-		return http.StatusRequestTimeout
-	case codes.Unknown:
-		return http.StatusInternalServerError
-	case codes.InvalidArgument:
-		return http.StatusBadRequest
-	case codes.DeadlineExceeded:
-		return http.StatusGatewayTimeout
-	case codes.NotFound:
-		return http.StatusNotFound
-	case codes.AlreadyExists:
-		return http.StatusConflict
-	case codes.PermissionDenied:
-		return http.StatusForbidden
-	case codes.Unauthenticated:
-		return http.StatusUnauthorized
-	case codes.ResourceExhausted:
-		return http.StatusTooManyRequests
-	case codes.FailedPrecondition:
-		// Note, this deliberately doesn't translate to the similarly named '412 Precondition Failed' HTTP response status.
-		return http.StatusBadRequest
-	case codes.Aborted:
-		return http.StatusConflict
-	case codes.OutOfRange:
-		return http.StatusBadRequest
-	case codes.Unimplemented:
-		return http.StatusNotImplemented
-	case codes.Internal:
-		return http.StatusInternalServerError
-	case codes.Unavailable:
-		return http.StatusServiceUnavailable
-	case codes.DataLoss:
-		return http.StatusInternalServerError
-	}
-	return http.StatusInternalServerError
+	return herror.HTTPStatusFromCode(code)
 }
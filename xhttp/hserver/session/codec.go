@@ -0,0 +1,65 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+var errInvalidCookie = errors.New("session: invalid cookie")
+
+// codec 使用 AES-256-GCM 加密 cookie，同时保证内容不可篡改。
+// 第一个密钥用于加密，其余密钥只用于解密，轮换密钥时将新密钥放在最前面
+type codec struct {
+	aeads []cipher.AEAD
+}
+
+func newCodec(secrets [][]byte) (*codec, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("session: secrets required")
+	}
+	c := &codec{}
+	for _, secret := range secrets {
+		key := sha256.Sum256(secret)
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// encode name 作为附加数据，避免把一个 cookie 的值用在另一个 cookie 上
+func (c *codec) encode(name string, plain []byte) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(name))), nil
+}
+
+func (c *codec) decode(name, value string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	for _, aead := range c.aeads {
+		if len(b) < aead.NonceSize() {
+			continue
+		}
+		if plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errInvalidCookie
+}
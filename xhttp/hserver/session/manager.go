@@ -0,0 +1,288 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/urfave/negroni"
+
+	"github.com/yituoshiniao/kit/xcookie"
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xhttp/hserver"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// 浏览器对单个 cookie 的大小限制
+const maxCookieSize = 4000
+
+// cookiePayload cookie 中加密保存的内容
+type cookiePayload struct {
+	Id  string  `json:"i,omitempty"`
+	Rec *record `json:"r,omitempty"`
+	// cookie 过期时间，避免浏览器不按 Max-Age 删除的 cookie 继续使用
+	Exp int64 `json:"e"`
+}
+
+// Manager 会话中间件，请求进入时从 cookie 加载会话，响应头写出前保存会话并设置 cookie。
+// store 为 nil 时会话数据加密后直接保存在 cookie 中，数据需要控制在 4KB 以内
+type Manager struct {
+	store    Store
+	codec    *codec
+	name     string
+	domain   string
+	path     string
+	secure   bool
+	sameSite xcookie.SameSite
+	idle     time.Duration
+	absolute time.Duration
+	now      func() time.Time
+}
+
+type Option func(*Manager)
+
+// WithCookieName 设置 cookie 名称，默认 sid
+func WithCookieName(name string) Option {
+	return func(m *Manager) {
+		m.name = name
+	}
+}
+
+func WithCookieDomain(domain string) Option {
+	return func(m *Manager) {
+		m.domain = domain
+	}
+}
+
+// WithCookiePath 设置 cookie 路径，默认 /
+func WithCookiePath(path string) Option {
+	return func(m *Manager) {
+		m.path = path
+	}
+}
+
+// WithSecure cookie 只通过 https 发送
+func WithSecure() Option {
+	return func(m *Manager) {
+		m.secure = true
+	}
+}
+
+// WithSameSite 默认 xcookie.SameSiteLaxMode
+func WithSameSite(sameSite xcookie.SameSite) Option {
+	return func(m *Manager) {
+		m.sameSite = sameSite
+	}
+}
+
+// WithIdleTimeout 空闲超时，每次访问会延长有效期，默认 30 分钟
+func WithIdleTimeout(d time.Duration) Option {
+	return func(m *Manager) {
+		m.idle = d
+	}
+}
+
+// WithAbsoluteTimeout 从创建开始计算的最长有效期，默认不限制
+func WithAbsoluteTimeout(d time.Duration) Option {
+	return func(m *Manager) {
+		m.absolute = d
+	}
+}
+
+// NewManager secrets 用于加密 cookie，第一个用于加密，其余用于解密轮换前的 cookie
+func NewManager(store Store, secrets [][]byte, opts ...Option) (*Manager, error) {
+	c, err := newCodec(secrets)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		store:    store,
+		codec:    c,
+		name:     "sid",
+		path:     "/",
+		sameSite: xcookie.SameSiteLaxMode,
+		idle:     30 * time.Minute,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+func (m *Manager) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	ctx := r.Context()
+	s, err := m.load(ctx, r)
+	if err != nil {
+		hserver.WriteError(rw, r, herror.ErrUnavailable.WithCause(err))
+		return
+	}
+	ctx = newContext(ctx, s)
+
+	var once sync.Once
+	nrw := negroni.NewResponseWriter(rw)
+	commit := func() {
+		once.Do(func() {
+			m.commit(ctx, nrw, s)
+		})
+	}
+	nrw.Before(func(negroni.ResponseWriter) {
+		commit()
+	})
+
+	next(nrw, r.WithContext(ctx))
+	// handler 没有写出响应时，响应头仍然可以修改
+	commit()
+}
+
+// load cookie 无效或会话已过期时返回新会话，只有存储出错时返回错误
+func (m *Manager) load(ctx context.Context, r *http.Request) (*Session, error) {
+	cookies := xcookie.ReadCookies(r.Header["Cookie"], m.name)
+	if len(cookies) == 0 {
+		return newSession(), nil
+	}
+	plain, err := m.codec.decode(m.name, cookies[0].Value)
+	if err != nil {
+		return newSession(), nil
+	}
+	var p cookiePayload
+	if json.Unmarshal(plain, &p) != nil || p.Exp < m.now().Unix() {
+		return newSession(), nil
+	}
+
+	var rec record
+	if m.store == nil {
+		if p.Rec == nil {
+			return newSession(), nil
+		}
+		rec = *p.Rec
+	} else {
+		if p.Id == "" {
+			return newSession(), nil
+		}
+		data, err := m.store.Load(ctx, p.Id)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil || json.Unmarshal(data, &rec) != nil {
+			return newSession(), nil
+		}
+	}
+
+	if m.absolute > 0 && m.now().After(time.Unix(rec.Created, 0).Add(m.absolute)) {
+		return newSession(), nil
+	}
+	if rec.Values == nil {
+		rec.Values = map[string]json.RawMessage{}
+	}
+	return &Session{id: p.Id, rec: rec}, nil
+}
+
+// commit 在响应头写出前保存会话，数据没有变化时每经过 1/10 的空闲超时才延长一次有效期
+func (m *Manager) commit(ctx context.Context, rw http.ResponseWriter, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.destroyed {
+		if s.isNew {
+			return
+		}
+		if m.store != nil && s.id != "" {
+			if err := m.store.Delete(ctx, s.id); err != nil {
+				xlog.S(ctx).Errorw("删除会话失败", "err", err)
+			}
+		}
+		m.setCookie(rw, "", -1)
+		return
+	}
+
+	now := m.now()
+	touch := !s.isNew && now.Sub(time.Unix(s.rec.Seen, 0)) >= m.idle/10
+	if !s.dirty && !touch {
+		return
+	}
+	if s.isNew && len(s.rec.Values) == 0 && len(s.rec.Flashes) == 0 {
+		return
+	}
+
+	if s.rec.Created == 0 {
+		s.rec.Created = now.Unix()
+	}
+	s.rec.Seen = now.Unix()
+	ttl := m.idle
+	if m.absolute > 0 {
+		if remaining := time.Unix(s.rec.Created, 0).Add(m.absolute).Sub(now); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if ttl <= 0 {
+		m.setCookie(rw, "", -1)
+		return
+	}
+	p := cookiePayload{Exp: now.Add(ttl).Unix()}
+
+	if m.store == nil {
+		rec := s.rec
+		p.Rec = &rec
+	} else {
+		if err := m.save(ctx, s, ttl); err != nil {
+			xlog.S(ctx).Errorw("保存会话失败", "err", err)
+			return
+		}
+		p.Id = s.id
+	}
+
+	plain, err := json.Marshal(p)
+	if err != nil {
+		xlog.S(ctx).Errorw("编码会话失败", "err", err)
+		return
+	}
+	value, err := m.codec.encode(m.name, plain)
+	if err != nil {
+		xlog.S(ctx).Errorw("加密会话失败", "err", err)
+		return
+	}
+	if len(value) > maxCookieSize {
+		xlog.S(ctx).Errorw("会话 cookie 超过大小限制", "size", len(value))
+		return
+	}
+	m.setCookie(rw, value, int(ttl/time.Second))
+}
+
+// save 新会话和调用过 Rotate 的会话使用新的 id，旧 id 的数据被删除
+func (m *Manager) save(ctx context.Context, s *Session, ttl time.Duration) error {
+	if s.id == "" || s.rotated {
+		id, err := newId()
+		if err != nil {
+			return err
+		}
+		if s.id != "" {
+			if err := m.store.Delete(ctx, s.id); err != nil {
+				return err
+			}
+		}
+		s.id = id
+		s.rotated = false
+	}
+	data, err := json.Marshal(s.rec)
+	if err != nil {
+		return err
+	}
+	return m.store.Save(ctx, s.id, data, ttl)
+}
+
+func (m *Manager) setCookie(rw http.ResponseWriter, value string, maxAge int) {
+	c := &xcookie.Cookie{
+		Name:     m.name,
+		Value:    value,
+		Path:     m.path,
+		Domain:   m.domain,
+		MaxAge:   maxAge,
+		Secure:   m.secure,
+		HttpOnly: true,
+		SameSite: m.sameSite,
+	}
+	rw.Header().Add("Set-Cookie", c.String())
+}
@@ -0,0 +1,144 @@
+// Package session hserver 的会话管理，会话数据保存在 redis 或加密后直接保存在 cookie 中
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+)
+
+// record 会话数据，redis 模式下保存在服务端，cookie 模式下加密后保存在 cookie 中
+type record struct {
+	Values  map[string]json.RawMessage `json:"v,omitempty"`
+	Flashes []string                   `json:"f,omitempty"`
+	// 创建时间，用于绝对超时
+	Created int64 `json:"c"`
+	// 最近一次延长有效期的时间
+	Seen int64 `json:"s"`
+}
+
+// Session 一次请求中的会话，可以在多个 goroutine 中使用
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	rec       record
+	isNew     bool
+	dirty     bool
+	rotated   bool
+	destroyed bool
+}
+
+func newSession() *Session {
+	return &Session{isNew: true, rec: record{Values: map[string]json.RawMessage{}}}
+}
+
+// ID 会话 id，cookie 模式和还未保存的新会话为空
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew 请求没有携带有效的会话
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Get 将 key 对应的值解码到 v 中，key 不存在时返回 false
+func (s *Session) Get(key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	raw, ok := s.rec.Values[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// GetString 获取字符串类型的值，不存在或类型不符时返回空字符串
+func (s *Session) GetString(key string) string {
+	var v string
+	_, _ = s.Get(key, &v)
+	return v
+}
+
+// Set 设置值，v 需要能编码为 JSON
+func (s *Session) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Values[key] = raw
+	s.dirty = true
+	return nil
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rec.Values[key]; ok {
+		delete(s.rec.Values, key)
+		s.dirty = true
+	}
+}
+
+// AddFlash 添加只在下一次读取时出现的消息，如表单提交后跳转页面的提示
+func (s *Session) AddFlash(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Flashes = append(s.rec.Flashes, msg)
+	s.dirty = true
+}
+
+// Flashes 读取并清空消息
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.rec.Flashes
+	if len(flashes) > 0 {
+		s.rec.Flashes = nil
+		s.dirty = true
+	}
+	return flashes
+}
+
+// Rotate 更换会话 id 并保留数据，登录、提升权限后调用，避免会话固定攻击
+func (s *Session) Rotate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotated = true
+	s.dirty = true
+}
+
+// Destroy 删除会话，如退出登录
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+type sessionKey struct{}
+
+func newContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// From 获取当前请求的会话，没有经过 Manager 中间件时返回 nil
+func From(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+func newId() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
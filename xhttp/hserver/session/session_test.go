@@ -0,0 +1,109 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// do 执行一次请求，cookie 为上一次响应设置的 cookie
+func do(m *Manager, cookie string, handler func(s *Session)) string {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != "" {
+		r.Header.Set("Cookie", cookie)
+	}
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, r, func(rw http.ResponseWriter, r *http.Request) {
+		handler(From(r.Context()))
+		_, _ = rw.Write([]byte("ok"))
+	})
+	set := rw.Header().Get("Set-Cookie")
+	if set == "" {
+		return cookie
+	}
+	return strings.Split(set, ";")[0]
+}
+
+func TestRedisSession(t *testing.T) {
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	m, err := NewManager(NewRedisStore(client), [][]byte{[]byte("secret")})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// 匿名访问不创建会话
+	assert.Empty(t, do(m, "", func(s *Session) { assert.True(t, s.IsNew()) }))
+
+	var id string
+	cookie := do(m, "", func(s *Session) {
+		assert.NoError(t, s.Set("uid", "u1"))
+		s.AddFlash("欢迎")
+	})
+	assert.True(t, strings.HasPrefix(cookie, "sid="))
+	assert.Len(t, mr.Keys(), 1)
+
+	cookie = do(m, cookie, func(s *Session) {
+		assert.False(t, s.IsNew())
+		assert.Equal(t, "u1", s.GetString("uid"))
+		assert.Equal(t, []string{"欢迎"}, s.Flashes())
+		id = s.ID()
+	})
+	do(m, cookie, func(s *Session) {
+		assert.Empty(t, s.Flashes())
+	})
+	assert.Equal(t, 30*time.Minute, mr.TTL("session:"+id))
+
+	// 提升权限后更换 id，旧 id 失效
+	rotated := do(m, cookie, func(s *Session) { s.Rotate() })
+	assert.NotEqual(t, cookie, rotated)
+	assert.False(t, mr.Exists("session:"+id))
+	do(m, cookie, func(s *Session) { assert.True(t, s.IsNew()) })
+	do(m, rotated, func(s *Session) { assert.Equal(t, "u1", s.GetString("uid")) })
+
+	do(m, rotated, func(s *Session) { s.Destroy() })
+	assert.Empty(t, mr.Keys())
+}
+
+func TestCookieSession(t *testing.T) {
+	now := time.Now()
+	m, err := NewManager(nil, [][]byte{[]byte("old")}, WithIdleTimeout(time.Hour), WithAbsoluteTimeout(2*time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+	m.now = func() time.Time { return now }
+
+	cookie := do(m, "", func(s *Session) { assert.NoError(t, s.Set("n", 1)) })
+
+	// 密钥轮换后旧 cookie 仍然有效
+	m2, _ := NewManager(nil, [][]byte{[]byte("new"), []byte("old")}, WithIdleTimeout(time.Hour), WithAbsoluteTimeout(2*time.Hour))
+	m2.now = m.now
+	do(m2, cookie, func(s *Session) {
+		var n int
+		ok, err := s.Get("n", &n)
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	// 篡改的 cookie 视为新会话
+	do(m, cookie[:len(cookie)-2]+"xx", func(s *Session) { assert.True(t, s.IsNew()) })
+
+	// 空闲超时内访问延长有效期，超过绝对超时后失效
+	now = now.Add(50 * time.Minute)
+	cookie = do(m, cookie, func(s *Session) { assert.False(t, s.IsNew()) })
+	now = now.Add(50 * time.Minute)
+	cookie = do(m, cookie, func(s *Session) { assert.False(t, s.IsNew()) })
+	now = now.Add(30 * time.Minute)
+	do(m, cookie, func(s *Session) { assert.True(t, s.IsNew()) })
+}
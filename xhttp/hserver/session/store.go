@@ -0,0 +1,57 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+// Store 服务端保存会话数据
+type Store interface {
+	// Load 会话不存在时返回 nil, nil
+	Load(ctx context.Context, id string) ([]byte, error)
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+// RedisStore 使用 redis 保存会话，过期时间与空闲超时一致
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+type RedisStoreOption func(*RedisStore)
+
+// WithKeyPrefix 设置 redis key 前缀，默认 session:
+func WithKeyPrefix(prefix string) RedisStoreOption {
+	return func(s *RedisStore) {
+		s.prefix = prefix
+	}
+}
+
+func NewRedisStore(client *redis.Client, opts ...RedisStoreOption) *RedisStore {
+	s := &RedisStore{client: client, prefix: "session:"}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *RedisStore) Load(ctx context.Context, id string) ([]byte, error) {
+	b, err := xrds.Trace(ctx, s.client).Get(s.prefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (s *RedisStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return xrds.Trace(ctx, s.client).Set(s.prefix+id, data, ttl).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return xrds.Trace(ctx, s.client).Del(s.prefix + id).Err()
+}
@@ -0,0 +1,197 @@
+package hserver
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// ConcurrencyLimitMiddleware 限制同时处理的请求数，超出的请求排队等待，
+// 队列已满或等待超时时直接拒绝，避免突发流量压垮后端的 MySQL、Redis
+type ConcurrencyLimitMiddleware struct {
+	sem          chan struct{}
+	queueSize    int64
+	queueTimeout time.Duration
+	queued       int64
+	rejectErr    error
+}
+
+type ConcurrencyLimitOption func(*ConcurrencyLimitMiddleware)
+
+// WithQueue 设置排队的最大请求数和最长等待时间
+func WithQueue(size int, timeout time.Duration) ConcurrencyLimitOption {
+	return func(m *ConcurrencyLimitMiddleware) {
+		m.queueSize = int64(size)
+		m.queueTimeout = timeout
+	}
+}
+
+// WithRejectError 设置拒绝请求时返回的错误，默认 503，可以改为 herror.ErrTooManyRequests 返回 429
+func WithRejectError(err error) ConcurrencyLimitOption {
+	return func(m *ConcurrencyLimitMiddleware) {
+		m.rejectErr = err
+	}
+}
+
+func NewConcurrencyLimitMiddleware(maxInFlight int, opts ...ConcurrencyLimitOption) *ConcurrencyLimitMiddleware {
+	m := &ConcurrencyLimitMiddleware{
+		sem:       make(chan struct{}, maxInFlight),
+		rejectErr: herror.ErrUnavailable,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *ConcurrencyLimitMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if reason := m.acquire(r); reason != "" {
+		countShed(r.Method, reason)
+		if reason == ShedReasonClientCanceled {
+			xlog.S(r.Context()).Infow("排队时调用方取消了请求", "path", r.URL.Path)
+		} else {
+			xlog.S(r.Context()).Warnw("请求数超出限制，拒绝请求", "reason", reason, "path", r.URL.Path)
+		}
+		WriteError(rw, r, m.rejectErr)
+		return
+	}
+	defer func() { <-m.sem }()
+
+	next(rw, r)
+}
+
+// acquire 获取处理名额，失败时返回拒绝原因
+func (m *ConcurrencyLimitMiddleware) acquire(r *http.Request) string {
+	select {
+	case m.sem <- struct{}{}:
+		return ""
+	default:
+	}
+
+	if atomic.AddInt64(&m.queued, 1) > m.queueSize {
+		atomic.AddInt64(&m.queued, -1)
+		return ShedReasonQueueFull
+	}
+	defer atomic.AddInt64(&m.queued, -1)
+
+	timer := time.NewTimer(m.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case m.sem <- struct{}{}:
+		return ""
+	case <-timer.C:
+		return ShedReasonQueueTimeout
+	case <-r.Context().Done():
+		return ShedReasonClientCanceled
+	}
+}
+
+// AdaptiveShedMiddleware 根据处理中请求的耗时自适应调整并发上限：
+// 最早的处理中请求已超过 targetLatency 时按比例降低上限，请求堆积时不用等到慢请求结束，
+// 否则每完成一个请求缓慢提高，达到上限的请求直接拒绝
+type AdaptiveShedMiddleware struct {
+	targetLatency time.Duration
+	minLimit      float64
+	maxLimit      float64
+	rejectErr     error
+
+	mu    sync.Mutex
+	limit float64
+	// 处理中请求的开始时间，按开始时间排序，最前面的是最早的请求
+	inFlight *list.List
+	// 上次降低上限的时间，每个 targetLatency 最多降低一次
+	lastDecrease time.Time
+}
+
+type AdaptiveShedOption func(*AdaptiveShedMiddleware)
+
+// WithShedLimits 设置并发上限的调整范围
+func WithShedLimits(min, max int) AdaptiveShedOption {
+	return func(m *AdaptiveShedMiddleware) {
+		m.minLimit = float64(min)
+		m.maxLimit = float64(max)
+	}
+}
+
+// WithShedRejectError 设置拒绝请求时返回的错误，默认 503
+func WithShedRejectError(err error) AdaptiveShedOption {
+	return func(m *AdaptiveShedMiddleware) {
+		m.rejectErr = err
+	}
+}
+
+func NewAdaptiveShedMiddleware(targetLatency time.Duration, opts ...AdaptiveShedOption) *AdaptiveShedMiddleware {
+	m := &AdaptiveShedMiddleware{
+		targetLatency: targetLatency,
+		minLimit:      10,
+		maxLimit:      1000,
+		rejectErr:     herror.ErrUnavailable,
+		inFlight:      list.New(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.limit = m.maxLimit
+	return m
+}
+
+func (m *AdaptiveShedMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	el, ok := m.acquire(time.Now())
+	if !ok {
+		countShed(r.Method, ShedReasonAdaptive)
+		xlog.S(r.Context()).Warnw("服务过载，拒绝请求", "limit", m.Limit(), "path", r.URL.Path)
+		WriteError(rw, r, m.rejectErr)
+		return
+	}
+	defer m.release(el, time.Now())
+
+	next(rw, r)
+}
+
+// Limit 返回当前的并发上限
+func (m *AdaptiveShedMiddleware) Limit() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int(m.limit)
+}
+
+func (m *AdaptiveShedMiddleware) acquire(now time.Time) (*list.Element, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.adjust(now, false)
+	if float64(m.inFlight.Len()) >= m.limit {
+		return nil, false
+	}
+	return m.inFlight.PushBack(now), true
+}
+
+func (m *AdaptiveShedMiddleware) release(el *list.Element, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight.Remove(el)
+	m.adjust(now, now.Sub(el.Value.(time.Time)) <= m.targetLatency)
+}
+
+// adjust 按最早的处理中请求的耗时调整上限，completed 为在 targetLatency 内完成了一个请求
+func (m *AdaptiveShedMiddleware) adjust(now time.Time, completed bool) {
+	if oldest := m.inFlight.Front(); oldest != nil && now.Sub(oldest.Value.(time.Time)) > m.targetLatency {
+		if now.Sub(m.lastDecrease) >= m.targetLatency {
+			m.limit *= 0.9
+			m.lastDecrease = now
+		}
+	} else if completed {
+		m.limit += 1 / m.limit
+	}
+	if m.limit < m.minLimit {
+		m.limit = m.minLimit
+	}
+	if m.limit > m.maxLimit {
+		m.limit = m.maxLimit
+	}
+}
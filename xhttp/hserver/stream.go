@@ -0,0 +1,343 @@
+package hserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// StreamFormat 流式响应的格式
+type StreamFormat int
+
+const (
+	// SSE Server-Sent Events，text/event-stream
+	SSE StreamFormat = iota
+	// NDJSON 每行一个 JSON，application/x-ndjson
+	NDJSON
+	// CSV 每次发送一行记录，text/csv
+	CSV
+)
+
+func (f StreamFormat) contentType() string {
+	switch f {
+	case NDJSON:
+		return "application/x-ndjson"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	}
+	return "text/event-stream; charset=utf-8"
+}
+
+// ErrStreamNotSupported ResponseWriter 不支持 Flush，无法流式输出
+var ErrStreamNotSupported = herror.New(http.StatusInternalServerError, http.StatusInternalServerError, "不支持流式响应")
+
+// StreamHandlerFunc 流式响应 handler。第一次 Send 之前返回的错误按普通错误响应输出；
+// 之后返回的错误在 SSE 中以 error 事件、在 NDJSON 中以一行错误结构发送。
+// 流式路由不使用 WithHandlerTimeout 的全局时限，需要时通过 WithRouteTimeout 设置；
+// Server 的 WriteTimeout 作用于每次 Send 和心跳，而不是整个响应，见 WithStreamWriteTimeout。
+type StreamHandlerFunc func(ctx context.Context, req *http.Request, stream *Stream) error
+
+// SSEvent Server-Sent Events 事件
+type SSEvent struct {
+	Id    string
+	Event string
+	// string 和 []byte 原样输出，其他类型编码为 JSON
+	Data  interface{}
+	Retry time.Duration
+}
+
+// Stream 流式响应的写入器，可以在多个 goroutine 中使用
+type Stream struct {
+	ctx     context.Context
+	rw      http.ResponseWriter
+	flusher http.Flusher
+	format  StreamFormat
+	// conn 为空时无法设置写超时，见 ConnContext
+	conn         net.Conn
+	writeTimeout time.Duration
+
+	mu      sync.Mutex
+	started bool
+	sent    int
+}
+
+// Send 发送一条数据：SSE 为一个只包含 data 的事件，NDJSON 为一行 JSON，CSV 中 v 必须为 []string
+func (s *Stream) Send(v interface{}) error {
+	switch s.format {
+	case SSE:
+		return s.Event(SSEvent{Data: v})
+	case CSV:
+		record, ok := v.([]string)
+		if !ok {
+			return fmt.Errorf("hserver: csv stream requires []string, got %T", v)
+		}
+		return s.write(func(buf *bytes.Buffer) error {
+			w := csv.NewWriter(buf)
+			if err := w.Write(record); err != nil {
+				return err
+			}
+			w.Flush()
+			return w.Error()
+		})
+	}
+
+	return s.write(jsonLine(v))
+}
+
+func jsonLine(v interface{}) func(buf *bytes.Buffer) error {
+	return func(buf *bytes.Buffer) error {
+		return json.NewEncoder(buf).Encode(v)
+	}
+}
+
+// Event 发送 SSE 事件，只能用于 SSE 格式
+func (s *Stream) Event(ev SSEvent) error {
+	if s.format != SSE {
+		return errors.New("hserver: Event requires SSE stream")
+	}
+	return s.write(sseEvent(ev))
+}
+
+func sseEvent(ev SSEvent) func(buf *bytes.Buffer) error {
+	return func(buf *bytes.Buffer) error {
+		if ev.Id != "" {
+			buf.WriteString("id: " + ev.Id + "\n")
+		}
+		if ev.Event != "" {
+			buf.WriteString("event: " + ev.Event + "\n")
+		}
+		if ev.Retry > 0 {
+			buf.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+		}
+		data, err := sseData(ev.Data)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(data, "\n") {
+			buf.WriteString("data: " + line + "\n")
+		}
+		buf.WriteString("\n")
+		return nil
+	}
+}
+
+// Sent 已发送的数据条数
+func (s *Stream) Sent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+func sseData(v interface{}) (string, error) {
+	switch d := v.(type) {
+	case string:
+		return d, nil
+	case []byte:
+		return string(d), nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// write 编码并立即发送，客户端断开或超时后返回 ctx 的错误
+func (s *Stream) write(encode func(buf *bytes.Buffer) error) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.emit(encode)
+}
+
+// emit 编码并发送，不检查 ctx
+func (s *Stream) emit(encode func(buf *bytes.Buffer) error) error {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start()
+	if err := s.flush(buf.Bytes()); err != nil {
+		return err
+	}
+	s.sent++
+	return nil
+}
+
+// flush 写入并立即发送，写入期间设置写超时，写完后清除，等待下一条数据时不受 WriteTimeout 限制；
+// 调用方需要持有锁
+func (s *Stream) flush(b []byte) error {
+	if s.conn != nil && s.writeTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+	_, err := s.rw.Write(b)
+	if err == nil {
+		s.flusher.Flush()
+	}
+	if s.conn != nil && s.writeTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Time{})
+	}
+	return err
+}
+
+// start 写出响应头，调用方需要持有锁
+func (s *Stream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	h := s.rw.Header()
+	h.Set("Content-Type", s.format.contentType())
+	h.Set("Cache-Control", "no-cache")
+	// 关闭 nginx 的响应缓冲
+	h.Set("X-Accel-Buffering", "no")
+	s.rw.WriteHeader(http.StatusOK)
+}
+
+func (s *Stream) heartbeat(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.start()
+			err := s.flush([]byte(": ping\n\n"))
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// fail 在已经开始输出后发送错误，处理超时后也会发送
+func (s *Stream) fail(err error) {
+	env := herror.NewEnvelope(err)
+	switch s.format {
+	case SSE:
+		_ = s.emit(sseEvent(SSEvent{Event: "error", Data: env}))
+	case NDJSON:
+		_ = s.emit(jsonLine(env))
+	}
+}
+
+type streamOptions struct {
+	heartbeat time.Duration
+	// 为 0 时使用 Server 的 WriteTimeout
+	writeTimeout time.Duration
+}
+
+// WithHeartbeat SSE 连接空闲时定期发送注释行，避免被代理断开
+func WithHeartbeat(interval time.Duration) RouteOption {
+	return func(r *Route) {
+		r.stream.heartbeat = interval
+	}
+}
+
+// WithStreamWriteTimeout 流式路由单次 Send 和心跳的写超时，默认使用 Server 的 WriteTimeout；
+// 服务挂在其他 http.Server 上时需要设置 http.Server.ConnContext = ConnContext 才会生效
+func WithStreamWriteTimeout(d time.Duration) RouteOption {
+	return func(r *Route) {
+		r.stream.writeTimeout = d
+	}
+}
+
+// Stream 注册流式响应路由
+func (s *Server) Stream(method, path string, format StreamFormat, handler StreamHandlerFunc, opts ...RouteOption) {
+	route := newRoute(method, path, opts)
+	route.kind = routeStream
+	route.format = format
+	route.longLived = true
+	if route.stream.writeTimeout == 0 {
+		route.stream.writeTimeout = s.options.WriteTimeout
+	}
+	s.handle(route, s.warpStream(format, route.stream, handler))
+}
+
+// SSE 注册 GET 方法的 Server-Sent Events 路由
+func (s *Server) SSE(path string, handler StreamHandlerFunc, opts ...RouteOption) {
+	s.Stream(http.MethodGet, path, SSE, handler, opts...)
+}
+
+func (s *Server) warpStream(format StreamFormat, so streamOptions, handler StreamHandlerFunc) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			writeError(rw, r, s.options.ErrFactory, ErrStreamNotSupported)
+			return
+		}
+
+		ctx := r.Context()
+		stream := &Stream{ctx: ctx, rw: rw, flusher: flusher, format: format, writeTimeout: so.writeTimeout}
+		// HTTP/2 的连接由多个请求共用，不能设置连接的写超时
+		if r.ProtoMajor == 1 {
+			stream.conn = connFromContext(ctx)
+		}
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		if format == SSE && so.heartbeat > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stream.heartbeat(so.heartbeat, done)
+			}()
+		}
+
+		err := handler(ctx, r, stream)
+		// handler 返回后不能再写 ResponseWriter
+		close(done)
+		wg.Wait()
+
+		stream.mu.Lock()
+		started := stream.started
+		stream.mu.Unlock()
+
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			// 超过 WithRouteTimeout 的时限，客户端仍然连接
+			xlog.S(ctx).Warnw("流式响应超过处理时限", "sent", stream.Sent(), "err", err)
+			if started {
+				recordResult(ctx, nil, errHandlerTimeout)
+				stream.fail(errHandlerTimeout)
+			} else {
+				writeError(rw, r, s.options.ErrFactory, errHandlerTimeout)
+			}
+		} else if err != nil && ctx.Err() != nil {
+			// 客户端断开连接，不再输出
+			xlog.S(ctx).Infow("流式响应客户端已断开", "sent", stream.Sent(), "err", err)
+			recordResult(ctx, nil, err)
+		} else if err != nil && !started {
+			writeError(rw, r, s.options.ErrFactory, err)
+		} else if err != nil {
+			recordResult(ctx, nil, err)
+			stream.fail(err)
+		}
+
+		if sp := opentracing.SpanFromContext(ctx); sp != nil {
+			sp.SetTag("http.stream.sent", stream.Sent())
+			if ctx.Err() == context.Canceled {
+				sp.LogFields(log.String("event", "client disconnected"))
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package hserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+)
+
+func TestStreamSSE(t *testing.T) {
+	observeLogs(t)
+	s := New()
+	s.SSE("/events", func(ctx context.Context, req *http.Request, stream *Stream) error {
+		if err := stream.Event(SSEvent{Id: "1", Event: "msg", Data: "hello\nworld"}); err != nil {
+			return err
+		}
+		time.Sleep(30 * time.Millisecond)
+		return stream.Send(map[string]int{"n": 2})
+	}, WithHeartbeat(10*time.Millisecond))
+
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/events", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "text/event-stream; charset=utf-8", rw.Header().Get("Content-Type"))
+	body := rw.Body.String()
+	assert.True(t, strings.HasPrefix(body, "id: 1\nevent: msg\ndata: hello\ndata: world\n\n"))
+	assert.Contains(t, body, ": ping\n\n")
+	assert.Contains(t, body, "data: {\"n\":2}\n\n")
+}
+
+func TestStreamNDJSONError(t *testing.T) {
+	observeLogs(t)
+	s := New()
+	s.Stream(http.MethodGet, "/rows", NDJSON, func(ctx context.Context, req *http.Request, stream *Stream) error {
+		if err := stream.Send(map[string]int{"id": 1}); err != nil {
+			return err
+		}
+		return herror.ErrConflict
+	})
+	s.Stream(http.MethodGet, "/csv", CSV, func(ctx context.Context, req *http.Request, stream *Stream) error {
+		return herror.ErrNotFound
+	})
+
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/rows", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	lines := strings.Split(strings.TrimSpace(rw.Body.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, `{"id":1}`, lines[0])
+		assert.Contains(t, lines[1], `"code":`)
+	}
+
+	// 还未输出数据时按普通错误响应
+	rw = serve(s, httptest.NewRequest(http.MethodGet, "/csv", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rw.Header().Get("Content-Type"))
+}
+
+func TestStreamClientGone(t *testing.T) {
+	observeLogs(t)
+	s := New()
+	s.Stream(http.MethodGet, "/csv", CSV, func(ctx context.Context, req *http.Request, stream *Stream) error {
+		for i := 0; ; i++ {
+			if err := stream.Send([]string{"a", "b,c"}); err != nil {
+				return err
+			}
+			if i == 1 {
+				cancel := ctx.Value(cancelKey{}).(context.CancelFunc)
+				cancel()
+			}
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = context.WithValue(ctx, cancelKey{}, cancel)
+	req := httptest.NewRequest(http.MethodGet, "/csv", nil).WithContext(ctx)
+	rw := serve(s, req)
+	assert.Equal(t, "a,\"b,c\"\na,\"b,c\"\n", rw.Body.String())
+}
+
+type cancelKey struct{}
+
+func TestStreamTimeouts(t *testing.T) {
+	observeLogs(t)
+	s := New(WithHandlerTimeout(50*time.Millisecond), WithWriteTimeout(50*time.Millisecond))
+	ticks := func(ctx context.Context, req *http.Request, stream *Stream) error {
+		for i := 0; i < 6; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if err := stream.Send([]string{strconv.Itoa(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// 流式路由不使用全局的 handler 时限
+	s.Stream(http.MethodGet, "/export", CSV, ticks)
+	s.SSE("/progress", func(ctx context.Context, req *http.Request, stream *Stream) error {
+		if err := stream.Send("start"); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithRouteTimeout(30*time.Millisecond))
+	s.SSE("/pending", func(ctx context.Context, req *http.Request, stream *Stream) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithRouteTimeout(30*time.Millisecond))
+
+	// WriteTimeout 只作用于单次写入，整个响应可以超过 WriteTimeout
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config.WriteTimeout = 50 * time.Millisecond
+	ts.Config.ConnContext = ConnContext
+	ts.Start()
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/export")
+	if assert.NoError(t, err) {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "0\n1\n2\n3\n4\n5\n", string(b))
+	}
+
+	// WithRouteTimeout 超时后发送错误事件，不当作客户端断开
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/progress", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), "event: error\ndata: {\"code\":504")
+	rw = serve(s, httptest.NewRequest(http.MethodGet, "/pending", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rw.Code)
+}
@@ -14,9 +14,8 @@ func NewTraceIdMiddleware() *TraceIdMiddleware {
 
 func (l *TraceIdMiddleware) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	newCtx := xtrace.NewCtxWithTraceId(r.Context())
-	*r = *r.WithContext(newCtx)
-	next(rw, r)
 	if traceId := xtrace.TraceIdFromContext(newCtx); traceId != "" {
 		rw.Header().Set("trace-id", traceId)
 	}
+	next(rw, r.WithContext(newCtx))
 }
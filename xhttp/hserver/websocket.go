@@ -0,0 +1,442 @@
+package hserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"go.uber.org/zap"
+
+	"github.com/yituoshiniao/kit/xhttp/herror"
+	"github.com/yituoshiniao/kit/xlog"
+)
+
+// 消息方向
+const (
+	WSInbound  = "in"
+	WSOutbound = "out"
+)
+
+// ErrWSClosed 连接已关闭
+var ErrWSClosed = errors.New("websocket: 连接已关闭")
+
+// WSHandlerFunc 处理 WebSocket 连接，返回时关闭连接，返回 nil 时关闭码为 1000，否则为 1011。
+// ctx 在连接断开或服务关闭时取消
+type WSHandlerFunc func(ctx context.Context, conn *WSConn) error
+
+// WSMessageHook 每条消息的回调，可用于日志、统计，data 不能修改
+type WSMessageHook func(ctx context.Context, direction string, messageType int, data []byte)
+
+type wsOptions struct {
+	pingInterval time.Duration
+	writeTimeout time.Duration
+	readLimit    int64
+	sendQueue    int
+	checkOrigin  func(r *http.Request) bool
+	hooks        []WSMessageHook
+}
+
+func defaultWSOptions() *wsOptions {
+	return &wsOptions{
+		pingInterval: 30 * time.Second,
+		writeTimeout: 10 * time.Second,
+		readLimit:    64 << 10,
+		sendQueue:    64,
+	}
+}
+
+// wsOption 修改路由的 WebSocket 配置
+func wsOption(fn func(o *wsOptions)) RouteOption {
+	return func(r *Route) {
+		if r.ws == nil {
+			r.ws = defaultWSOptions()
+		}
+		fn(r.ws)
+	}
+}
+
+// WithWSPingInterval 发送 ping 的间隔，超过两个间隔没有收到 pong 时断开连接，默认 30 秒
+func WithWSPingInterval(d time.Duration) RouteOption {
+	return wsOption(func(o *wsOptions) {
+		o.pingInterval = d
+	})
+}
+
+// WithWSWriteTimeout 单条消息的写超时，默认 10 秒
+func WithWSWriteTimeout(d time.Duration) RouteOption {
+	return wsOption(func(o *wsOptions) {
+		o.writeTimeout = d
+	})
+}
+
+// WithWSReadLimit 单条消息的最大字节数，超出时断开连接，默认 64KB
+func WithWSReadLimit(n int64) RouteOption {
+	return wsOption(func(o *wsOptions) {
+		o.readLimit = n
+	})
+}
+
+// WithWSSendQueue Send 的队列长度，队列满时认为客户端过慢并断开连接，默认 64
+func WithWSSendQueue(n int) RouteOption {
+	return wsOption(func(o *wsOptions) {
+		o.sendQueue = n
+	})
+}
+
+// WithWSCheckOrigin 校验 Origin 请求头，默认要求与 Host 一致
+func WithWSCheckOrigin(fn func(r *http.Request) bool) RouteOption {
+	return wsOption(func(o *wsOptions) {
+		o.checkOrigin = fn
+	})
+}
+
+// WithWSHook 添加消息回调
+func WithWSHook(hook WSMessageHook) RouteOption {
+	return wsOption(func(o *wsOptions) {
+		o.hooks = append(o.hooks, hook)
+	})
+}
+
+// WithWSLogMessages 记录每条消息的日志，redact 用于隐藏消息中的敏感内容，为 nil 时只记录消息大小
+func WithWSLogMessages(redact func(data []byte) []byte) RouteOption {
+	return WithWSHook(func(ctx context.Context, direction string, messageType int, data []byte) {
+		fields := []interface{}{"direction", direction, "type", messageType, "size", len(data)}
+		if redact != nil {
+			body := redact(data)
+			if len(body) > 1024 {
+				body = body[:1024]
+			}
+			fields = append(fields, "body", string(body))
+		}
+		xlog.S(ctx).Infow("websocket 消息", fields...)
+	})
+}
+
+// wsMessage 读取到的消息
+type wsMessage struct {
+	typ  int
+	data []byte
+}
+
+// WSConn WebSocket 连接，读写都可以在多个 goroutine 中调用
+type WSConn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	conn   *websocket.Conn
+	req    *http.Request
+	opts   wsOptions
+
+	writeMu sync.Mutex
+	in      chan wsMessage
+	send    chan wsMessage
+
+	closeOnce sync.Once
+
+	received int64
+	sent     int64
+	// 连接关闭的原因
+	closeCode int
+	closeErr  error
+}
+
+// Request 升级前的 http 请求
+func (c *WSConn) Request() *http.Request {
+	return c.req
+}
+
+// ReadMessage 读取一条消息，连接关闭后返回 ErrWSClosed。
+// 未读取的消息超过缓冲后不再从连接读取，只推送消息的 handler 需要客户端不发送数据消息
+func (c *WSConn) ReadMessage() (messageType int, data []byte, err error) {
+	select {
+	case m, ok := <-c.in:
+		if !ok {
+			return 0, nil, ErrWSClosed
+		}
+		return m.typ, m.data, nil
+	case <-c.ctx.Done():
+		return 0, nil, ErrWSClosed
+	}
+}
+
+// ReadJSON 读取一条消息并解析 JSON
+func (c *WSConn) ReadJSON(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage 同步写出一条消息
+func (c *WSConn) WriteMessage(messageType int, data []byte) error {
+	if c.ctx.Err() != nil {
+		return ErrWSClosed
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.opts.writeTimeout))
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		c.shutdown(websocket.CloseAbnormalClosure, err)
+		return err
+	}
+	atomic.AddInt64(&c.sent, 1)
+	c.runHooks(WSOutbound, messageType, data)
+	return nil
+}
+
+// WriteJSON 同步写出一条 JSON 文本消息
+func (c *WSConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
+
+// Send 异步写出一条消息，用于广播等不能被单个慢连接阻塞的场景，队列满时断开连接并返回 false
+func (c *WSConn) Send(messageType int, data []byte) bool {
+	if c.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case c.send <- wsMessage{typ: messageType, data: data}:
+		return true
+	default:
+		xlog.S(c.ctx).Warnw("websocket 发送队列已满，断开连接", "queue", cap(c.send))
+		c.Close(websocket.ClosePolicyViolation, "slow consumer")
+		return false
+	}
+}
+
+// Close 发送关闭帧并断开连接
+func (c *WSConn) Close(code int, reason string) {
+	c.writeMu.Lock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	c.shutdown(code, nil)
+}
+
+// Context 连接断开时取消
+func (c *WSConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *WSConn) shutdown(code int, err error) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeErr = err
+		c.cancel()
+		_ = c.conn.Close()
+	})
+}
+
+func (c *WSConn) runHooks(direction string, messageType int, data []byte) {
+	for _, hook := range c.opts.hooks {
+		hook(c.ctx, direction, messageType, data)
+	}
+}
+
+func (c *WSConn) readLoop() {
+	defer close(c.in)
+	c.conn.SetReadLimit(c.opts.readLimit)
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * c.opts.pingInterval))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(2 * c.opts.pingInterval))
+	})
+	for {
+		typ, data, err := c.conn.ReadMessage()
+		if err != nil {
+			code := websocket.CloseAbnormalClosure
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) {
+				code, err = ce.Code, nil
+			}
+			c.shutdown(code, err)
+			return
+		}
+		atomic.AddInt64(&c.received, 1)
+		c.runHooks(WSInbound, typ, data)
+		select {
+		case c.in <- wsMessage{typ: typ, data: data}:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *WSConn) pingLoop() {
+	ticker := time.NewTicker(c.opts.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.writeMu.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.opts.writeTimeout))
+			c.writeMu.Unlock()
+			if err != nil {
+				c.shutdown(websocket.CloseAbnormalClosure, err)
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *WSConn) writeLoop() {
+	for {
+		select {
+		case m := <-c.send:
+			if c.WriteMessage(m.typ, m.data) != nil {
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// WebSocket 注册 GET 方法的 WebSocket 路由，连接期间的日志带有升级请求的 trace-id，
+// 连接不使用 WithHandlerTimeout 的全局时限
+func (s *Server) WebSocket(path string, handler WSHandlerFunc, opts ...RouteOption) {
+	route := newRoute(http.MethodGet, path, opts)
+	route.kind = routeRaw
+	route.longLived = true
+	if route.ws == nil {
+		route.ws = defaultWSOptions()
+	}
+	s.handle(route, s.warpWebSocket(*route.ws, handler))
+}
+
+func (s *Server) warpWebSocket(o wsOptions, handler WSHandlerFunc) httprouter.Handle {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: o.checkOrigin,
+		Error: func(rw http.ResponseWriter, r *http.Request, status int, reason error) {
+			writeError(rw, r, s.options.ErrFactory, herror.New(status, status, reason.Error()))
+		},
+	}
+	return func(rw http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			// 已经输出错误响应
+			return
+		}
+
+		sp, ctx := opentracing.StartSpanFromContext(r.Context(), "websocket "+r.URL.Path)
+		ctx, cancel := context.WithCancel(ctx)
+		c := &WSConn{
+			ctx:    ctx,
+			cancel: cancel,
+			conn:   conn,
+			req:    r,
+			opts:   o,
+			in:     make(chan wsMessage, 16),
+			send:   make(chan wsMessage, o.sendQueue),
+		}
+		if !s.trackWS(c, true) {
+			c.Close(websocket.CloseGoingAway, "server shutdown")
+		}
+		defer s.trackWS(c, false)
+
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() { defer wg.Done(); c.readLoop() }()
+		go func() { defer wg.Done(); c.pingLoop() }()
+		go func() { defer wg.Done(); c.writeLoop() }()
+		xlog.S(ctx).Infow("websocket 连接建立", "path", r.URL.Path)
+
+		start := time.Now()
+		err = handler(ctx, c)
+		if err != nil && ctx.Err() == nil {
+			recordResult(r.Context(), nil, err)
+			e := herror.FromError(err)
+			c.Close(websocket.CloseInternalServerErr, e.Message)
+		} else {
+			c.Close(websocket.CloseNormalClosure, "")
+		}
+		wg.Wait()
+
+		fields := []zap.Field{
+			zap.Int("closeCode", c.closeCode),
+			zap.Int64("received", atomic.LoadInt64(&c.received)),
+			zap.Int64("sent", atomic.LoadInt64(&c.sent)),
+			DurationToTimeMillisField(time.Since(start)),
+		}
+		if c.closeErr != nil {
+			fields = append(fields, zap.Error(c.closeErr))
+		}
+		if err != nil {
+			fields = append(fields, zap.NamedError("handlerErr", err))
+		}
+		xlog.L(ctx).Info("websocket 连接关闭", fields...)
+
+		sp.SetTag("websocket.close_code", c.closeCode)
+		sp.SetTag("websocket.received", atomic.LoadInt64(&c.received))
+		sp.SetTag("websocket.sent", atomic.LoadInt64(&c.sent))
+		if err != nil {
+			ext.Error.Set(sp, true)
+			sp.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+		}
+		sp.Finish()
+	}
+}
+
+// trackWS 记录活跃连接，服务关闭后返回 false
+func (s *Server) trackWS(c *WSConn, add bool) bool {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	if !add {
+		delete(s.wsConns, c)
+		if len(s.wsConns) == 0 && s.wsDrained != nil {
+			close(s.wsDrained)
+			s.wsDrained = nil
+		}
+		return true
+	}
+	if s.shuttingDown {
+		return false
+	}
+	if s.wsConns == nil {
+		s.wsConns = map[*WSConn]struct{}{}
+	}
+	s.wsConns[c] = struct{}{}
+	return true
+}
+
+// closeWebSockets 向所有连接发送 1001 关闭帧，等待 handler 返回或 ctx 超时
+func (s *Server) closeWebSockets(ctx context.Context) error {
+	s.wsMu.Lock()
+	s.shuttingDown = true
+	conns := make([]*WSConn, 0, len(s.wsConns))
+	for c := range s.wsConns {
+		conns = append(conns, c)
+	}
+	var drained chan struct{}
+	if len(s.wsConns) > 0 {
+		drained = make(chan struct{})
+		s.wsDrained = drained
+	}
+	s.wsMu.Unlock()
+
+	for _, c := range conns {
+		c.Close(websocket.CloseGoingAway, "server shutdown")
+	}
+	if drained == nil {
+		return nil
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,122 @@
+package hserver
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis"
+
+	"github.com/yituoshiniao/kit/xlog"
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+// hubMessage redis 中广播的消息
+type hubMessage struct {
+	Topic string `json:"t"`
+	Type  int    `json:"y"`
+	Data  []byte `json:"d"`
+}
+
+// WSHub 按主题向 WebSocket 连接广播消息。
+// client 不为 nil 时消息通过 redis pub/sub 发送到所有实例，包括当前实例，否则只在当前实例内广播
+type WSHub struct {
+	client  *redis.Client
+	channel string
+	pubsub  *redis.PubSub
+
+	mu     sync.RWMutex
+	topics map[string]map[*WSConn]struct{}
+}
+
+// NewWSHub channel 为 redis 订阅的频道，同一业务的所有实例需要相同
+func NewWSHub(client *redis.Client, channel string) (hub *WSHub, cleanup func(), err error) {
+	hub = &WSHub{client: client, channel: channel, topics: map[string]map[*WSConn]struct{}{}}
+	if client == nil {
+		return hub, func() {}, nil
+	}
+
+	hub.pubsub = client.Subscribe(channel)
+	// 等待订阅成功，避免返回后立即广播的消息丢失
+	if _, err := hub.pubsub.Receive(); err != nil {
+		_ = hub.pubsub.Close()
+		return nil, nil, err
+	}
+	go hub.run()
+	cleanup = func() {
+		if err := hub.pubsub.Close(); err != nil {
+			xlog.S(context.Background()).Infow("NewWSHub-应用退出err", "err", err)
+		}
+	}
+	return hub, cleanup, nil
+}
+
+func (h *WSHub) run() {
+	for msg := range h.pubsub.Channel() {
+		var m hubMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+			xlog.S(context.Background()).Warnw("websocket 广播消息格式错误", "channel", msg.Channel, "err", err)
+			continue
+		}
+		h.deliver(m)
+	}
+}
+
+// Join 连接订阅主题，连接断开时自动取消订阅
+func (h *WSHub) Join(conn *WSConn, topics ...string) {
+	h.mu.Lock()
+	for _, topic := range topics {
+		conns, ok := h.topics[topic]
+		if !ok {
+			conns = map[*WSConn]struct{}{}
+			h.topics[topic] = conns
+		}
+		conns[conn] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	go func() {
+		<-conn.Context().Done()
+		h.Leave(conn, topics...)
+	}()
+}
+
+// Leave 取消订阅主题
+func (h *WSHub) Leave(conn *WSConn, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		conns := h.topics[topic]
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// Broadcast 向订阅主题的所有连接发送消息，慢连接会被断开，不影响其他连接
+func (h *WSHub) Broadcast(ctx context.Context, topic string, messageType int, data []byte) error {
+	m := hubMessage{Topic: topic, Type: messageType, Data: data}
+	if h.client == nil {
+		h.deliver(m)
+		return nil
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return xrds.Trace(ctx, h.client).Publish(h.channel, payload).Err()
+}
+
+func (h *WSHub) deliver(m hubMessage) {
+	h.mu.RLock()
+	conns := make([]*WSConn, 0, len(h.topics[m.Topic]))
+	for c := range h.topics[m.Topic] {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+
+	for _, c := range conns {
+		c.Send(m.Type, m.Data)
+	}
+}
@@ -0,0 +1,155 @@
+package hserver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialWS(t *testing.T, ts *httptest.Server, path string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+path, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return conn
+}
+
+func TestWebSocket(t *testing.T) {
+	logs := observeLogs(t)
+
+	var mu sync.Mutex
+	var hooked []string
+	redact := func(data []byte) []byte {
+		return bytes.Replace(data, []byte("secret"), []byte("***"), -1)
+	}
+	s := New()
+	s.WebSocket("/echo", func(ctx context.Context, conn *WSConn) error {
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return nil
+			}
+			if string(data) == "bye" {
+				return nil
+			}
+			if err := conn.WriteMessage(typ, data); err != nil {
+				return err
+			}
+		}
+	},
+		WithWSLogMessages(redact),
+		WithWSHook(func(ctx context.Context, direction string, messageType int, data []byte) {
+			mu.Lock()
+			hooked = append(hooked, direction+":"+string(data))
+			mu.Unlock()
+		}),
+	)
+	ts := httptest.NewServer(s.rootHandler())
+	defer ts.Close()
+
+	conn := dialWS(t, ts, "/echo")
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("token=secret")))
+	_, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "token=secret", string(data))
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("bye")))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	conn.Close()
+
+	assert.Eventually(t, func() bool { return logs.FilterMessage("websocket 连接关闭").Len() == 1 }, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"in:token=secret", "out:token=secret", "in:bye"}, hooked)
+	mu.Unlock()
+	msgs := logs.FilterMessage("websocket 消息").All()
+	if assert.Len(t, msgs, 3) {
+		assert.Equal(t, "token=***", logFields(msgs[0])["body"])
+	}
+
+	// 非 WebSocket 请求
+	rw := serve(s, httptest.NewRequest(http.MethodGet, "/echo", nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestWebSocketHubAndShutdown(t *testing.T) {
+	observeLogs(t)
+	mr, err := miniredis.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	hub, cleanup, err := NewWSHub(client, "ws:broadcast")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cleanup()
+
+	joined := make(chan struct{}, 2)
+	s := New()
+	s.WebSocket("/events/:room", func(ctx context.Context, conn *WSConn) error {
+		hub.Join(conn, conn.Request().URL.Path)
+		joined <- struct{}{}
+		<-ctx.Done()
+		return nil
+	})
+	ts := httptest.NewServer(s.rootHandler())
+	defer ts.Close()
+
+	a := dialWS(t, ts, "/events/a")
+	defer a.Close()
+	b := dialWS(t, ts, "/events/b")
+	defer b.Close()
+	<-joined
+	<-joined
+
+	assert.NoError(t, hub.Broadcast(context.Background(), "/events/a", websocket.TextMessage, []byte("hello a")))
+	_, data, err := a.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello a", string(data))
+
+	// 关闭服务时向所有连接发送 1001
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	_, _, err = b.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "%v", err)
+}
+
+func TestWebSocketIgnoresHandlerTimeout(t *testing.T) {
+	observeLogs(t)
+	s := New(WithHandlerTimeout(20 * time.Millisecond))
+	s.WebSocket("/echo", func(ctx context.Context, conn *WSConn) error {
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return nil
+			}
+			if err := conn.WriteMessage(typ, data); err != nil {
+				return err
+			}
+		}
+	}, WithWSPingInterval(time.Second))
+	ts := httptest.NewServer(s.rootHandler())
+	defer ts.Close()
+
+	conn := dialWS(t, ts, "/echo")
+	defer conn.Close()
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("still here")))
+	_, data, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "still here", string(data))
+}
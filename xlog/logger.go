@@ -24,6 +24,14 @@ const (
 
 type LogRotate string
 
+// level 基础日志的级别，New 时按配置设置
+var level = zap.NewAtomicLevel()
+
+// Level 返回当前日志级别，可以通过 SetLevel 动态调整，实现了 http.Handler，GET 查询、PUT 修改
+func Level() zap.AtomicLevel {
+	return level
+}
+
 func init() {
 	initLog(defaultOptions)
 }
@@ -87,6 +95,7 @@ func New(conf Config) (*zap.Logger, error) {
 }
 
 func getBaseCore(conf Config) zapcore.Core {
+	level.SetLevel(conf.level())
 	var syncers []zapcore.WriteSyncer
 
 	if conf.File.Filename != "" {
@@ -106,7 +115,7 @@ func getBaseCore(conf Config) zapcore.Core {
 	return zapcore.NewCore(
 		encoderFromFormat(conf.Format, conf.LevelColor, conf.CallerKey), // 编码器配置
 		zapcore.NewMultiWriteSyncer(syncers...),                         // 增加同步器
-		zap.NewAtomicLevelAt(conf.level()),                              // 日志级别
+		level,                                                           // 日志级别
 
 	)
 }
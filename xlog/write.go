@@ -45,10 +45,12 @@ func ExtFields(ctx context.Context) (fs []zap.Field) {
 	fs = append(
 		fs,
 		TraceIdField(ctx),
+		RequestIdField(ctx),
 		BaggageFlowField(ctx),
 		GidField(),
-		zap.Namespace(LogField),
 	)
+	fs = append(fs, CtxFields(ctx)...)
+	fs = append(fs, zap.Namespace(LogField))
 	return fs
 }
 
@@ -66,9 +68,11 @@ func ExtFieldsNotNamespace(ctx context.Context) (fs []zap.Field) {
 	fs = append(
 		fs,
 		TraceIdField(ctx),
+		RequestIdField(ctx),
 		BaggageFlowField(ctx),
 		GidField(),
 	)
+	fs = append(fs, CtxFields(ctx)...)
 	return fs
 }
 
@@ -81,6 +85,30 @@ func TraceIdField(ctx context.Context) (f zap.Field) {
 
 }
 
+// RequestIdField 写入 requestId 到日志组件中
+func RequestIdField(ctx context.Context) (f zap.Field) {
+	if id := xtrace.RequestIdFromContext(ctx); id != "" {
+		return zap.String("requestId", id)
+	}
+	return zap.Skip()
+}
+
+type ctxFieldsKey struct{}
+
+// NewCtxWithFields 将日志字段放入 ctx，之后通过 S、L 等获取的 logger 都会带上这些字段，如认证后的用户
+func NewCtxWithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	old := CtxFields(ctx)
+	fs := make([]zap.Field, 0, len(old)+len(fields))
+	fs = append(append(fs, old...), fields...)
+	return context.WithValue(ctx, ctxFieldsKey{}, fs)
+}
+
+// CtxFields 获取通过 NewCtxWithFields 放入 ctx 的日志字段
+func CtxFields(ctx context.Context) []zap.Field {
+	fs, _ := ctx.Value(ctxFieldsKey{}).([]zap.Field)
+	return fs
+}
+
 // GidField ...
 func GidField() (f zap.Field) {
 	var (
@@ -0,0 +1,16 @@
+package xrds
+
+import (
+	"context"
+
+	"github.com/go-redis/redis"
+
+	"github.com/yituoshiniao/kit/xhealth"
+)
+
+// HealthCheck redis 连接检查，用于 xhealth.Registry 注册
+func HealthCheck(client *redis.Client) xhealth.CheckFunc {
+	return func(ctx context.Context) error {
+		return client.WithContext(ctx).Ping().Err()
+	}
+}
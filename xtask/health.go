@@ -0,0 +1,31 @@
+package xtask
+
+import (
+	"context"
+
+	"github.com/hibiken/asynq"
+
+	"github.com/yituoshiniao/kit/xhealth"
+	"github.com/yituoshiniao/kit/xlog"
+	"github.com/yituoshiniao/kit/xrds"
+)
+
+// NewAsynqHealthCheck 检查 asynq 使用的 redis 是否可用，用于 xhealth.Registry 注册
+func NewAsynqHealthCheck(conf xrds.Config) (check xhealth.CheckFunc, cleanup func()) {
+	inspector := asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     conf.Addr,
+		Password: conf.Password,
+		DB:       conf.DB,
+	})
+	check = func(ctx context.Context) error {
+		// Inspector 不支持 ctx，超时由 Registry 处理
+		_, err := inspector.Queues()
+		return err
+	}
+	cleanup = func() {
+		if err := inspector.Close(); err != nil {
+			xlog.S(context.Background()).Infow("NewAsynqHealthCheck-应用退出err", "err", err)
+		}
+	}
+	return check, cleanup
+}
@@ -0,0 +1,19 @@
+package xtrace
+
+import "context"
+
+// RequestIdHeader 请求 id 的 HTTP 头，由网关或前端生成，用于串联一次用户操作的所有日志
+const RequestIdHeader = "X-Request-Id"
+
+type requestIdKey struct{}
+
+// NewCtxWithRequestId 将请求 id 放入 ctx
+func NewCtxWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestIdFromContext 从 ctx 中获取请求 id
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}